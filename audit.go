@@ -0,0 +1,231 @@
+package uploader
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"uploader/internal/auth"
+	"uploader/internal/responses"
+)
+
+// AuditAction is the kind of event recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditUpload       AuditAction = "upload"
+	AuditDelete       AuditAction = "delete"
+	AuditDeletePublic AuditAction = "delete_public"
+	AuditAuthFailure  AuditAction = "auth_failure"
+)
+
+const (
+	// AuditActorSystem is the actor of events the uploader causes itself, such as removing expired uploads.
+	AuditActorSystem = "system"
+	// AuditActorAdmin is the actor of events caused from the command line, which acts with admin rights.
+	AuditActorAdmin = "admin"
+)
+
+// AuditEvent is an entry of the audit log.
+type AuditEvent struct {
+	Time   time.Time   `json:"time"`
+	Action AuditAction `json:"action"`
+	// Actor is the authenticated user, empty for anonymous requests such as deletes using the delete key.
+	Actor string `json:"actor,omitempty"`
+	IP    string `json:"ip,omitempty"`
+	// Key is the upload acted on.
+	Key string `json:"key,omitempty"`
+	// Detail describes the event, such as the reason authentication failed.
+	Detail string `json:"detail,omitempty"`
+}
+
+// AuditFilter selects events from the audit log. Empty fields match every event.
+type AuditFilter struct {
+	// Since and Until bound the time of the events, Since inclusive and Until exclusive.
+	Since  time.Time
+	Until  time.Time
+	Action AuditAction
+	Actor  string
+	Key    string
+	// Limit is the largest number of events returned, zero for no limit.
+	Limit int
+}
+
+// Matches reports whether the event is selected by the filter.
+func (f *AuditFilter) Matches(event *AuditEvent) bool {
+	return (f.Since.IsZero() || !event.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || event.Time.Before(f.Until)) &&
+		(f.Action == "" || event.Action == f.Action) &&
+		(f.Actor == "" || event.Actor == f.Actor) &&
+		(f.Key == "" || event.Key == f.Key)
+}
+
+// DownloadCount is the number of times an upload was downloaded.
+type DownloadCount struct {
+	Key       string `json:"key"`
+	Downloads int64  `json:"downloads"`
+}
+
+type AuditMeta interface {
+	// AuditAppend adds the event to the audit log. Events are never changed or removed once appended.
+	AuditAppend(event AuditEvent) error
+	// AuditEvents returns the events selected by the filter in the order they were appended.
+	AuditEvents(filter AuditFilter) ([]AuditEvent, error)
+	// DownloadAdd counts a download of the upload at the given time. The count is removed with the upload, as its
+	// key may be reused by a later upload.
+	DownloadAdd(key string, at time.Time) error
+	// Downloads returns the number of downloads of each upload between since and until, ordered by key. Downloads are
+	// counted per UTC day, so every day overlapping the range is counted. Zero times leave the range unbounded.
+	Downloads(since, until time.Time) ([]DownloadCount, error)
+}
+
+// downloadDay is the layout of the days downloads are counted in.
+const downloadDay = "2006-01-02"
+
+// dayOverlaps reports whether the UTC day starting at day overlaps the range between since and until.
+func dayOverlaps(day, since, until time.Time) bool {
+	return (since.IsZero() || day.Add(24*time.Hour).After(since)) && (until.IsZero() || day.Before(until))
+}
+
+// ParseAuditTime parses a time given as RFC 3339, a date or a duration before now.
+func ParseAuditTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(downloadDay, value); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339, a date or a duration", value)
+}
+
+// clientIP returns the address of the client. Behind a proxy this is the address of the proxy.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// record appends an event caused by the request to the audit log. The event has already taken effect, so failing to
+// record it is logged rather than failing the request.
+func (u *Uploader) record(r *http.Request, action AuditAction, key, detail string) {
+	event := AuditEvent{Time: time.Now().UTC(), Action: action, IP: clientIP(r), Key: key, Detail: detail}
+	if user := auth.AuthUser(r.Context()); user != nil {
+		event.Actor = user.Name
+	}
+	if err := u.audit.AuditAppend(event); err != nil {
+		log.Printf("failed to record %s of %s: %s", action, key, err)
+	}
+}
+
+// recordSystem appends an event caused by the uploader itself to the audit log, if the MetaStore keeps one. Like
+// record, failures are logged as the event has already taken effect.
+func (u *uploadService) recordSystem(action AuditAction, key, detail string) {
+	audit, ok := u.meta.(AuditMeta)
+	if !ok {
+		return
+	}
+	event := AuditEvent{Time: time.Now().UTC(), Action: action, Actor: AuditActorSystem, Key: key, Detail: detail}
+	if err := audit.AuditAppend(event); err != nil {
+		log.Printf("failed to record %s of %s: %s", action, key, err)
+	}
+}
+
+// uploaded records an upload created by the request.
+func (u *Uploader) uploaded(r *http.Request, details *UploadDetails) {
+	u.record(r, AuditUpload, details.Key, "")
+	u.metrics.upload(details.Size)
+}
+
+// authFailed records a request rejected by authentication.
+func (u *Uploader) authFailed(r *http.Request, reason error) {
+	u.record(r, AuditAuthFailure, "", reason.Error())
+	u.metrics.authFailure(reason)
+}
+
+// countDownload counts a download of the upload, logging failures as the download has already been served.
+func (u *Uploader) countDownload(key string) {
+	if err := u.audit.DownloadAdd(key, time.Now()); err != nil {
+		log.Printf("failed to count download of %s: %s", key, err)
+	}
+}
+
+// auditRange parses the since and until query parameters of an admin request.
+func auditRange(r *http.Request) (since, until time.Time, err error) {
+	query, now := r.URL.Query(), time.Now()
+	if since, err = ParseAuditTime(query.Get("since"), now); err != nil {
+		return
+	}
+	until, err = ParseAuditTime(query.Get("until"), now)
+	return
+}
+
+func (u *Uploader) auditList(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	query := r.URL.Query()
+	filter := AuditFilter{Action: AuditAction(query.Get("action")), Actor: query.Get("actor"), Key: query.Get("key")}
+	var err error
+	if filter.Since, filter.Until, err = auditRange(r); err != nil {
+		responses.Error(w, response, http.StatusBadRequest, -1013, err.Error())
+		return
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit < 0 {
+			responses.Error(w, response, http.StatusBadRequest, -1002, "invalid limit")
+			return
+		}
+	}
+	events, err := u.audit.AuditEvents(filter)
+	if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	if events == nil {
+		events = []AuditEvent{}
+	}
+	response.Ok = true
+	response.Results = events
+	responses.Json(w, response, http.StatusOK)
+}
+
+func (u *Uploader) downloadList(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	since, until, err := auditRange(r)
+	if err != nil {
+		responses.Error(w, response, http.StatusBadRequest, -1013, err.Error())
+		return
+	}
+	counts, err := u.audit.Downloads(since, until)
+	if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	if key := r.URL.Query().Get("key"); key != "" {
+		counts = filterDownloads(counts, key)
+	}
+	if counts == nil {
+		counts = []DownloadCount{}
+	}
+	response.Ok = true
+	response.Results = counts
+	responses.Json(w, response, http.StatusOK)
+}
+
+func filterDownloads(counts []DownloadCount, key string) []DownloadCount {
+	for _, count := range counts {
+		if count.Key == key {
+			return []DownloadCount{count}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package uploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"uploader/internal/auth"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParseAuditTime(t *testing.T) {
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := map[string]struct {
+		value string
+		want  time.Time
+		err   bool
+	}{
+		"empty":    {"", time.Time{}, false},
+		"rfc3339":  {"2022-12-31T10:00:00Z", time.Date(2022, 12, 31, 10, 0, 0, 0, time.UTC), false},
+		"date":     {"2022-12-31", time.Date(2022, 12, 31, 0, 0, 0, 0, time.UTC), false},
+		"duration": {"24h", now.Add(-24 * time.Hour), false},
+		"negative": {"-1h", time.Time{}, true},
+		"invalid":  {"yesterday", time.Time{}, true},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := ParseAuditTime(test.value, now)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("expected %s, got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestAuditHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	admin, _ := meta.UserRegister("admin_user")
+	meta.UserSetRole("admin_user", auth.RoleAdmin)
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		return response
+	}
+
+	assertStatusCode(t, serve(uploadRequest(t, user.AuthToken)), http.StatusAccepted)
+	assertStatusCode(t, serve(uploadRequest(t, admin.AuthToken)), http.StatusAccepted)
+	assertStatusCode(t, serve(uploadRequest(t, user.AuthToken)), http.StatusAccepted)
+	assertStatusCode(t, serve(uploadRequest(t, "wrong")), http.StatusUnauthorized)
+	for i := 0; i < 2; i++ {
+		assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/files/1", nil)), http.StatusOK)
+		assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/files/3", nil)), http.StatusOK)
+	}
+	assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/files/missing", nil)), http.StatusNotFound)
+	request := httptest.NewRequest(http.MethodDelete, "/uploads/test_user/1", nil)
+	request.Header.Set(auth.HTTPHeaderName, "Bearer "+user.AuthToken)
+	assertStatusCode(t, serve(request), http.StatusOK)
+	assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/uploads/test_user/2/delete/delete", nil)), http.StatusOK)
+
+	ip := "192.0.2.1"
+	want := []AuditEvent{
+		{Action: AuditUpload, Actor: "test_user", IP: ip, Key: "1"},
+		{Action: AuditUpload, Actor: "admin_user", IP: ip, Key: "2"},
+		{Action: AuditUpload, Actor: "test_user", IP: ip, Key: "3"},
+		{Action: AuditAuthFailure, IP: ip, Detail: auth.InvalidTokenError.Error()},
+		{Action: AuditDelete, Actor: "test_user", IP: ip, Key: "1"},
+		{Action: AuditDeletePublic, IP: ip, Key: "2"},
+	}
+	if diff := cmp.Diff(want, meta.audit, cmpopts.IgnoreFields(AuditEvent{}, "Time")); diff != "" {
+		t.Errorf("unexpected audit log %s", diff)
+	}
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set(auth.HTTPHeaderName, "Bearer "+token)
+		return serve(request)
+	}
+	t.Run("events", func(t *testing.T) {
+		response := get("/admin/audit?action=upload&since=1h", admin.AuthToken)
+		assertStatusCode(t, response, http.StatusOK)
+		var body struct {
+			Results []AuditEvent `json:"results"`
+		}
+		json.NewDecoder(response.Body).Decode(&body)
+		if diff := cmp.Diff(want[:3], body.Results, cmpopts.IgnoreFields(AuditEvent{}, "Time")); diff != "" {
+			t.Errorf("unexpected events %s", diff)
+		}
+
+		response = get("/admin/audit?until=1h", admin.AuthToken)
+		body.Results = nil
+		json.NewDecoder(response.Body).Decode(&body)
+		if len(body.Results) != 0 {
+			t.Errorf("expected no events before the range, got %+v", body.Results)
+		}
+		assertStatusCode(t, get("/admin/audit?since=yesterday", admin.AuthToken), http.StatusBadRequest)
+	})
+	t.Run("downloads", func(t *testing.T) {
+		response := get("/admin/downloads?since=2023-01-01", admin.AuthToken)
+		assertStatusCode(t, response, http.StatusOK)
+		var body struct {
+			Results []DownloadCount `json:"results"`
+		}
+		json.NewDecoder(response.Body).Decode(&body)
+		// The downloads of the deleted upload are removed with it.
+		if diff := cmp.Diff([]DownloadCount{{Key: "3", Downloads: 2}}, body.Results); diff != "" {
+			t.Errorf("unexpected downloads %s", diff)
+		}
+
+		response = get("/admin/downloads?until=2023-01-01", admin.AuthToken)
+		body.Results = nil
+		json.NewDecoder(response.Body).Decode(&body)
+		if len(body.Results) != 0 {
+			t.Errorf("expected no downloads before the range, got %+v", body.Results)
+		}
+	})
+	t.Run("forbidden", func(t *testing.T) {
+		assertStatusCode(t, get("/admin/audit", user.AuthToken), http.StatusForbidden)
+		assertStatusCode(t, get("/admin/downloads", user.AuthToken), http.StatusForbidden)
+	})
+}
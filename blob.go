@@ -0,0 +1,19 @@
+package uploader
+
+// blobKeyPrefix distinguishes blob keys from upload keys within the FileStore.
+const blobKeyPrefix = "blob-"
+
+// Blob is a stored file that may be referenced by several uploads with identical contents.
+type Blob struct {
+	Hash string `json:"hash"`
+	Key  string `json:"key"`
+	Refs int    `json:"refs"`
+}
+
+type BlobMeta interface {
+	// BlobAcquire takes a reference to the blob with the given hash. If no such blob exists, one is created that
+	// is stored under key. The returned blob holds the key the contents are actually stored under.
+	BlobAcquire(hash, key string) (*Blob, error)
+	// BlobRelease drops a reference to the blob. The returned blob has no references if it should be deleted.
+	BlobRelease(hash string) (*Blob, error)
+}
@@ -1,9 +1,57 @@
 package uploader
 
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"uploader/internal/auth"
+	"uploader/internal/oidc"
+)
+
 type Config struct {
 	BaseURL    string   `yaml:"base_url"`
 	BoltConfig *boltCfg `yaml:"bolt"`
 	DirConfig  *dirCfg  `yaml:"dir"`
+	S3Config   *s3Cfg   `yaml:"s3"`
+	// ExpiryConfig sets the default and maximum lifetime of uploads.
+	ExpiryConfig *expiryCfg `yaml:"expiry"`
+	// MaxUploadSize is the largest upload accepted in bytes, zero for no limit.
+	MaxUploadSize int64     `yaml:"max_upload_size"`
+	QuotaConfig   *quotaCfg `yaml:"quota"`
+	// OIDCConfig enables browser login through an OpenID Connect provider.
+	OIDCConfig *oidcCfg `yaml:"oidc"`
+	// ThumbnailConfig sets the sizes of thumbnails made for image uploads.
+	ThumbnailConfig *thumbnailCfg `yaml:"thumbnails"`
+	// PreviewConfig enables preview pages for uploads at /v/{key}.
+	PreviewConfig *previewCfg `yaml:"preview"`
+	// EncryptionConfig encrypts stored files with the configured master key.
+	EncryptionConfig *encryptionCfg `yaml:"encryption"`
+	// SkipFsck disables the check of the stores against each other when the server starts.
+	SkipFsck bool `yaml:"skip_fsck"`
+	// MetricsConfig enables Prometheus metrics.
+	MetricsConfig *metricsCfg `yaml:"metrics"`
+}
+
+// LinkPreviews reports whether client scripts should link to preview pages rather than files.
+func (c *Config) LinkPreviews() bool {
+	return c.PreviewConfig != nil && c.PreviewConfig.Link
+}
+
+// ThumbnailSizes returns the sizes of the thumbnails made for image uploads.
+func (c *Config) ThumbnailSizes() ([]int, error) {
+	return c.ThumbnailConfig.sizes()
+}
+
+// MetricsAddress returns the address metrics are served on, or an empty string if they are disabled or served by the
+// uploader at /metrics.
+func (c *Config) MetricsAddress() string {
+	if c.MetricsConfig == nil {
+		return ""
+	}
+	return c.MetricsConfig.Address
 }
 
 type boltCfg struct {
@@ -13,3 +61,228 @@ type boltCfg struct {
 type dirCfg struct {
 	Path string `yaml:"path"`
 }
+
+type s3Cfg struct {
+	Endpoint  string `yaml:"endpoint"`
+	Bucket    string `yaml:"bucket"`
+	Region    string `yaml:"region"`
+	AccessKey string `yaml:"access_key_id"`
+	SecretKey string `yaml:"secret_access_key"`
+	PathStyle bool   `yaml:"path_style"`
+	// PartSize is the multipart upload part size in bytes, defaulting to and no less than 5MiB.
+	PartSize int `yaml:"part_size"`
+	// PresignExpiry enables redirecting downloads to signed URLs with the given lifetime, such as "5m".
+	PresignExpiry string `yaml:"presign_expiry"`
+	// Timeout limits how long requests to the bucket may take, such as "30s", defaulting to a minute. Downloads are
+	// only limited in how long the bucket takes to respond, as they are streamed for as long as clients take.
+	Timeout string `yaml:"timeout"`
+}
+
+// Durations are given in the format accepted by time.ParseDuration, such as "72h". PartialTTL is how long a resumable
+// upload may go without receiving data before it is removed.
+type expiryCfg struct {
+	Default      string                    `yaml:"default"`
+	Max          string                    `yaml:"max"`
+	ReapInterval string                    `yaml:"reap_interval"`
+	PartialTTL   string                    `yaml:"partial_ttl"`
+	Users        map[string]expiryLimitCfg `yaml:"users"`
+}
+
+type expiryLimitCfg struct {
+	Default string `yaml:"default"`
+	Max     string `yaml:"max"`
+}
+
+func (e expiryLimitCfg) limits() (ExpiryLimits, error) {
+	limits := ExpiryLimits{}
+	var err error
+	if e.Default != "" {
+		if limits.Default, err = time.ParseDuration(e.Default); err != nil {
+			return limits, fmt.Errorf("invalid default expiry: %w", err)
+		}
+	}
+	if e.Max != "" {
+		if limits.Max, err = time.ParseDuration(e.Max); err != nil {
+			return limits, fmt.Errorf("invalid max expiry: %w", err)
+		}
+	}
+	return limits, nil
+}
+
+func (e *expiryCfg) policy() (ExpiryPolicy, error) {
+	policy := ExpiryPolicy{Users: map[string]ExpiryLimits{}}
+	var err error
+	if policy.ExpiryLimits, err = (expiryLimitCfg{e.Default, e.Max}).limits(); err != nil {
+		return policy, err
+	}
+	for name, user := range e.Users {
+		if policy.Users[name], err = user.limits(); err != nil {
+			return policy, fmt.Errorf("user %s: %w", name, err)
+		}
+	}
+	return policy, nil
+}
+
+type quotaLimitCfg struct {
+	MaxBytes int64 `yaml:"max_bytes"`
+	MaxFiles int64 `yaml:"max_files"`
+}
+
+type quotaCfg struct {
+	quotaLimitCfg `yaml:",inline"`
+	Users         map[string]quotaLimitCfg `yaml:"users"`
+}
+
+func (q *quotaCfg) policy(maxUploadSize int64) QuotaPolicy {
+	policy := QuotaPolicy{MaxUploadSize: maxUploadSize, Users: map[string]QuotaLimits{}}
+	if q == nil {
+		return policy
+	}
+	policy.QuotaLimits = QuotaLimits(q.quotaLimitCfg)
+	for name, user := range q.Users {
+		policy.Users[name] = QuotaLimits(user)
+	}
+	return policy
+}
+
+// Thumbnail sizes are limited to keep the cost of making them on request bounded.
+const (
+	minThumbnailSize = 16
+	maxThumbnailSize = 2048
+)
+
+type thumbnailCfg struct {
+	// Sizes are the widths and heights, in pixels, of the squares thumbnails are scaled to fit.
+	// An empty list disables thumbnails.
+	Sizes []int `yaml:"sizes"`
+}
+
+func (t *thumbnailCfg) sizes() ([]int, error) {
+	if t == nil {
+		return DefaultThumbnailSizes, nil
+	}
+	for _, size := range t.Sizes {
+		if size < minThumbnailSize || size > maxThumbnailSize {
+			return nil, fmt.Errorf("thumbnail size %d must be between %d and %d", size, minThumbnailSize, maxThumbnailSize)
+		}
+	}
+	return t.Sizes, nil
+}
+
+type previewCfg struct {
+	// Template is the path of an html/template executed with a PreviewPage, replacing the built in page.
+	Template string `yaml:"template"`
+	// Users maps user names to the path of their own template.
+	Users map[string]string `yaml:"users"`
+	// Link makes generated client scripts copy the link to the preview page rather than the file.
+	Link bool `yaml:"link"`
+}
+
+func (p *previewCfg) previews() (*previews, error) {
+	return newPreviews(p.Template, p.Users, p.Link)
+}
+
+// Master keys are 32 bytes, given in base64 or as the path of a file holding the key. Rotating the master key is done
+// by moving the current key to the previous keys, configuring a new key and running the keys rotate command, after
+// which the previous keys may be removed.
+type encryptionCfg struct {
+	Key     string `yaml:"key"`
+	KeyFile string `yaml:"key_file"`
+	// PreviousKeys and PreviousKeyFiles are earlier master keys, still used to read files until their keys are rotated.
+	PreviousKeys     []string `yaml:"previous_keys"`
+	PreviousKeyFiles []string `yaml:"previous_key_files"`
+}
+
+// masterKeyFrom returns the key given in base64 or the key read from the file at path, whichever is set.
+func masterKeyFrom(encoded, path string) ([]byte, error) {
+	if path != "" {
+		return ReadMasterKey(path)
+	}
+	return ParseMasterKey(encoded)
+}
+
+func (e *encryptionCfg) keyring() (*Keyring, error) {
+	if (e.Key == "") == (e.KeyFile == "") {
+		return nil, errors.New("exactly one of the encryption key and key_file must be configured")
+	}
+	current, err := masterKeyFrom(e.Key, e.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	var previous [][]byte
+	for _, encoded := range e.PreviousKeys {
+		key, err := ParseMasterKey(encoded)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	for _, path := range e.PreviousKeyFiles {
+		key, err := ReadMasterKey(path)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+	return NewKeyring(current, previous...)
+}
+
+type oidcCfg struct {
+	Issuer       string   `yaml:"issuer"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Scopes       []string `yaml:"scopes"`
+	// UsernameClaim is the ID token claim used as the username, defaulting to sub. Users are bound to the subject
+	// they registered with either way, so a claim such as preferred_username only changes how they are named.
+	UsernameClaim string `yaml:"username_claim"`
+	// SessionSecret signs session cookies. If empty a random secret is used, so sessions end when the server restarts.
+	SessionSecret string `yaml:"session_secret"`
+	// SessionLifetime is how long a login lasts, such as "24h", defaulting to a week.
+	SessionLifetime string `yaml:"session_lifetime"`
+}
+
+func (o *oidcCfg) loginHandler(base *url.URL, users auth.Store) (*loginHandler, error) {
+	var lifetime time.Duration
+	if o.SessionLifetime != "" {
+		var err error
+		if lifetime, err = time.ParseDuration(o.SessionLifetime); err != nil {
+			return nil, fmt.Errorf("invalid oidc session lifetime: %w", err)
+		}
+	}
+	provider, err := oidc.Discover(oidc.Config{
+		Issuer:       o.Issuer,
+		ClientID:     o.ClientID,
+		ClientSecret: o.ClientSecret,
+		RedirectURL:  base.JoinPath(loginCallbackPath).String(),
+		Scopes:       o.Scopes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := auth.NewSessions([]byte(o.SessionSecret), lifetime)
+	if err != nil {
+		return nil, err
+	}
+	sessions.Secure = base.Scheme == "https"
+	return newLoginHandler(provider, sessions, users, o.UsernameClaim, base.JoinPath("/").String()), nil
+}
+
+// Metrics require a bearer token when token is set, basic auth when username is set, and are public otherwise.
+type metricsCfg struct {
+	// Address is where metrics are served, such as "localhost:9090". If empty they are served at /metrics of the
+	// uploader.
+	Address  string `yaml:"address"`
+	Token    string `yaml:"token"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+func (m *metricsCfg) handler(metrics *Metrics) (http.Handler, error) {
+	if m.Token != "" && m.Username != "" {
+		return nil, errors.New("only one of the metrics token and username may be configured")
+	}
+	if m.Username != "" && m.Password == "" {
+		return nil, errors.New("metrics basic auth requires a password")
+	}
+	return requireMetricsAuth(metrics.Handler(), m.Token, m.Username, m.Password), nil
+}
@@ -0,0 +1,160 @@
+// Package client uploads files to an uploader server, and encrypts uploads end to end so that the server never sees
+// their contents.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type Client struct {
+	// BaseURL is the public URL of the uploader.
+	BaseURL    *url.URL
+	User       string
+	Token      string
+	HTTPClient *http.Client
+}
+
+func New(baseURL, user, token string) (*Client, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{BaseURL: base, User: user, Token: token, HTTPClient: http.DefaultClient}, nil
+}
+
+type Upload struct {
+	// URL is the link to the upload. For encrypted uploads it carries the key in its fragment.
+	URL       string `json:"url"`
+	DeleteURL string `json:"delete_url"`
+}
+
+// Error is an error response from the server.
+type Error struct {
+	Status  int    `json:"-"`
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("uploader: %d %s (%d)", e.Status, e.Message, e.Code)
+}
+
+func responseError(response *http.Response) error {
+	apiError := &Error{Status: response.StatusCode}
+	if err := json.NewDecoder(response.Body).Decode(apiError); err != nil || apiError.Message == "" {
+		apiError.Message = http.StatusText(response.StatusCode)
+	}
+	return apiError
+}
+
+// Upload uploads the file unencrypted.
+func (c *Client) Upload(ctx context.Context, name string, r io.Reader) (*Upload, error) {
+	return c.upload(ctx, name, r, nil)
+}
+
+// UploadEncrypted encrypts the file with a new key before uploading it.
+func (c *Client) UploadEncrypted(ctx context.Context, name, contentType string, r io.Reader) (*Upload, error) {
+	contents, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	key, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := Seal(key, File{Name: name, ContentType: contentType, Contents: contents})
+	if err != nil {
+		return nil, err
+	}
+	upload, err := c.upload(ctx, EncryptedFilename, bytes.NewReader(sealed), map[string]string{"encrypted": "true"})
+	if err != nil {
+		return nil, err
+	}
+	if upload.URL, err = FragmentURL(upload.URL, key); err != nil {
+		return nil, err
+	}
+	return upload, nil
+}
+
+func (c *Client) upload(ctx context.Context, name string, r io.Reader, fields map[string]string) (*Upload, error) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	for field, value := range fields {
+		if err := form.WriteField(field, value); err != nil {
+			return nil, err
+		}
+	}
+	part, err := form.CreateFormFile("file", name)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(part, r); err != nil {
+		return nil, err
+	}
+	if err := form.Close(); err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL.JoinPath("/uploads/", c.User).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+	request.Header.Set("Authorization", "Bearer "+c.Token)
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return nil, responseError(response)
+	}
+	decoded := struct {
+		Results Upload `json:"results"`
+	}{}
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return &decoded.Results, nil
+}
+
+// Download fetches and decrypts an encrypted upload from its URL, which must carry the key in its fragment.
+func (c *Client) Download(ctx context.Context, link string) (*File, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return nil, err
+	}
+	key := parsed.Fragment
+	if key == "" {
+		return nil, errors.New("url has no key")
+	}
+	// The upload URL serves the viewer page, while any name after it serves the file itself.
+	parsed.Fragment = ""
+	parsed.Path = strings.TrimSuffix(parsed.Path, "/") + "/" + EncryptedFilename
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.HTTPClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, responseError(response)
+	}
+	sealed, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	return Open(key, sealed)
+}
@@ -0,0 +1,119 @@
+package client
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Encrypted uploads are sealed with a random 256 bit key, given to recipients as the URL fragment encoded in unpadded
+// base64url. A sealed file is a version byte, a 12 byte nonce and the AES-GCM encryption of:
+//
+//	a 4 byte big endian header length
+//	the JSON header, holding the name and content type of the file
+//	the file contents
+//
+// The uploaded file itself is named EncryptedFilename, so that the server learns nothing of the original file but
+// its approximate size. The web interface implements the same format in static/e2e.js.
+
+const (
+	sealVersion = 1
+	keySize     = 32
+	nonceSize   = 12
+	// EncryptedFilename is the name encrypted files are uploaded with.
+	EncryptedFilename = "encrypted"
+)
+
+var ErrInvalidKey = errors.New("invalid encryption key")
+
+// File is a decrypted file.
+type File struct {
+	Name        string `json:"name"`
+	ContentType string `json:"type"`
+	Contents    []byte `json:"-"`
+}
+
+// NewKey returns a random key, encoded as it appears in the URL fragment.
+func NewKey() (string, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
+
+func newGCM(encoded string) (cipher.AEAD, error) {
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(key) != keySize {
+		return nil, ErrInvalidKey
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal encrypts the file with the key.
+func Seal(key string, file File) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	header, err := json.Marshal(file)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, 4, 4+len(header)+len(file.Contents))
+	binary.BigEndian.PutUint32(plain, uint32(len(header)))
+	plain = append(append(plain, header...), file.Contents...)
+
+	sealed := make([]byte, 1+nonceSize, 1+nonceSize+len(plain)+aead.Overhead())
+	sealed[0] = sealVersion
+	if _, err := rand.Read(sealed[1:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(sealed, sealed[1:], plain, nil), nil
+}
+
+// Open decrypts a file sealed with the key.
+func Open(key string, sealed []byte) (*File, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < 1+nonceSize || sealed[0] != sealVersion {
+		return nil, errors.New("unsupported encrypted file")
+	}
+	plain, err := aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+	if len(plain) < 4 || uint64(binary.BigEndian.Uint32(plain)) > uint64(len(plain)-4) {
+		return nil, errors.New("invalid encrypted file header")
+	}
+	length := binary.BigEndian.Uint32(plain)
+	file := &File{}
+	if err := json.NewDecoder(bytes.NewReader(plain[4 : 4+length])).Decode(file); err != nil {
+		return nil, fmt.Errorf("invalid encrypted file header: %w", err)
+	}
+	file.Contents = plain[4+length:]
+	return file, nil
+}
+
+// FragmentURL returns the URL of an encrypted upload carrying its key in the fragment.
+func FragmentURL(link, key string) (string, error) {
+	parsed, err := url.Parse(link)
+	if err != nil {
+		return "", err
+	}
+	parsed.Fragment = key
+	return parsed.String(), nil
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key, err := NewKey()
+	if err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	file := File{Name: "secret.txt", ContentType: "text/plain", Contents: []byte("Hello, World!")}
+	sealed, err := Seal(key, file)
+	if err != nil {
+		t.Fatalf("failed to seal file: %s", err)
+	}
+	if bytes.Contains(sealed, file.Contents) || bytes.Contains(sealed, []byte(file.Name)) {
+		t.Error("expected sealed file not to contain the plaintext")
+	}
+	opened, err := Open(key, sealed)
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err)
+	}
+	if opened.Name != file.Name || opened.ContentType != file.ContentType || !bytes.Equal(opened.Contents, file.Contents) {
+		t.Errorf("file did not round trip, got %+v", opened)
+	}
+
+	other, _ := NewKey()
+	if _, err := Open(other, sealed); err == nil {
+		t.Error("expected opening with another key to fail")
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := Open(key, sealed); err == nil {
+		t.Error("expected opening a modified file to fail")
+	}
+	if _, err := Seal("short", file); !errors.Is(err, ErrInvalidKey) {
+		t.Errorf("expected invalid key error, got %v", err)
+	}
+}
+
+func TestFragmentURL(t *testing.T) {
+	link, err := FragmentURL("https://example.com/files/abc", "key")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if link != "https://example.com/files/abc#key" {
+		t.Errorf("unexpected url %s", link)
+	}
+}
@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"uploader"
+	"uploader/internal/auth"
+)
+
+// stores are the stores opened from the config for the duration of a subcommand.
+type stores struct {
+	cfg   *uploader.Config
+	meta  uploader.MetaStore
+	files uploader.FileStore
+}
+
+type subcommand func(s *stores, args []string) error
+
+var subcommands = map[string]subcommand{
+	"users list":         usersList,
+	"users disable":      usersSetDisabled(true),
+	"users enable":       usersSetDisabled(false),
+	"users set-quota":    usersSetQuota,
+	"users revoke":       usersRevoke,
+	"users rotate-token": usersRotateToken,
+	"tokens list":        tokensList,
+	"tokens create":      tokensCreate,
+	"uploads list":       uploadsList,
+	"uploads info":       uploadsInfo,
+	"uploads delete":     uploadsDelete,
+	"script":             script,
+	"stats":              stats,
+	"keys rotate":        keysRotate,
+	"fsck":               fsck,
+	"audit events":       auditEvents,
+	"audit downloads":    auditDownloads,
+}
+
+// runSubcommand runs the subcommand named by the leading arguments against the configured stores.
+func runSubcommand(cfg *uploader.Config, args []string) error {
+	name := args[0]
+	cmd, found := subcommands[name]
+	if !found && len(args) > 1 {
+		name = args[0] + " " + args[1]
+		cmd, found = subcommands[name]
+	}
+	if !found {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	meta, files, err := uploader.OpenStores(cfg)
+	if err != nil {
+		return err
+	}
+	defer files.Close()
+	defer meta.Close()
+	return cmd(&stores{cfg: cfg, meta: meta, files: files}, args[len(strings.Fields(name)):])
+}
+
+// output writes v as JSON if requested, or otherwise as a table written by table.
+func output(asJSON bool, v interface{}, table func(w io.Writer)) error {
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	table(w)
+	return w.Flush()
+}
+
+// parseFlags parses the flags of a subcommand, returning its positional arguments.
+func parseFlags(fs *flag.FlagSet, args []string, positional ...string) ([]string, error) {
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != len(positional) {
+		return nil, fmt.Errorf("usage: %s [flags] %v", fs.Name(), positional)
+	}
+	return fs.Args(), nil
+}
+
+func usersList(s *stores, args []string) error {
+	fs := flag.NewFlagSet("users list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	users, err := s.meta.UserList()
+	if err != nil {
+		return err
+	}
+	return output(*asJSON, users, func(w io.Writer) {
+		fmt.Fprintln(w, "NAME\tROLE\tCREATED\tDISABLED\tTOKENS\tQUOTA")
+		for _, user := range users {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%t\t%d\t%s\n", user.Name, user.Role, formatTime(&user.Created), user.Disabled,
+				len(user.Tokens), formatQuota(user.Quota))
+		}
+	})
+}
+
+func formatQuota(quota *auth.Quota) string {
+	if quota == nil {
+		return "-"
+	}
+	return fmt.Sprintf("%d bytes, %d files", quota.MaxBytes, quota.MaxFiles)
+}
+
+func usersSetDisabled(disabled bool) subcommand {
+	return func(s *stores, args []string) error {
+		name := "users enable"
+		if disabled {
+			name = "users disable"
+		}
+		fs := flag.NewFlagSet(name, flag.ContinueOnError)
+		args, err := parseFlags(fs, args, "NAME")
+		if err != nil {
+			return err
+		}
+		if err := s.meta.UserSetDisabled(args[0], disabled); err != nil {
+			return err
+		}
+		fmt.Printf("%sd %s\n", strings.TrimPrefix(name, "users "), args[0])
+		return nil
+	}
+}
+
+func usersSetQuota(s *stores, args []string) error {
+	fs := flag.NewFlagSet("users set-quota", flag.ContinueOnError)
+	maxBytes := fs.Int64("max-bytes", 0, "Maximum total size of uploads, or zero for no limit.")
+	maxFiles := fs.Int64("max-files", 0, "Maximum number of uploads, or zero for no limit.")
+	clear := fs.Bool("clear", false, "Remove the override, restoring the configured quota.")
+	args, err := parseFlags(fs, args, "NAME")
+	if err != nil {
+		return err
+	}
+	var quota *auth.Quota
+	if !*clear {
+		quota = &auth.Quota{MaxBytes: *maxBytes, MaxFiles: *maxFiles}
+	}
+	if err := s.meta.UserSetQuota(args[0], quota); err != nil {
+		return err
+	}
+	fmt.Printf("quota of %s set to %s\n", args[0], formatQuota(quota))
+	return nil
+}
+
+func usersRevoke(s *stores, args []string) error {
+	fs := flag.NewFlagSet("users revoke", flag.ContinueOnError)
+	token := fs.String("token", "", "Name of the token to revoke, or every token of the user if empty.")
+	args, err := parseFlags(fs, args, "NAME")
+	if err != nil {
+		return err
+	}
+	if *token == "" {
+		if err := s.meta.UserRevoke(args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("revoked tokens of %s\n", args[0])
+		return nil
+	}
+	if err := s.meta.TokenRevoke(args[0], *token); err != nil {
+		return err
+	}
+	fmt.Printf("revoked token %s of %s\n", *token, args[0])
+	return nil
+}
+
+func usersRotateToken(s *stores, args []string) error {
+	fs := flag.NewFlagSet("users rotate-token", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	name := fs.String("token", auth.DefaultTokenName, "Name of the token to rotate.")
+	args, err := parseFlags(fs, args, "NAME")
+	if err != nil {
+		return err
+	}
+	token, err := s.meta.TokenRotate(args[0], *name)
+	if err != nil {
+		return err
+	}
+	return outputTokens(*asJSON, []*auth.Token{token})
+}
+
+func tokensList(s *stores, args []string) error {
+	fs := flag.NewFlagSet("tokens list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	args, err := parseFlags(fs, args, "USER")
+	if err != nil {
+		return err
+	}
+	tokens, err := s.meta.TokenList(args[0])
+	if err != nil {
+		return err
+	}
+	return outputTokens(*asJSON, tokens)
+}
+
+func tokensCreate(s *stores, args []string) error {
+	fs := flag.NewFlagSet("tokens create", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	name := fs.String("name", "", "Name of the new token.")
+	expiresIn := fs.Duration("expires-in", 0, "Lifetime of the token, or zero for no expiry.")
+	scopes := fs.String("scopes", auth.AllScopes, "Comma separated scopes of the token, from upload, read and delete, or all.")
+	args, err := parseFlags(fs, args, "USER")
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("-name is required")
+	}
+	opts := auth.TokenOptions{}
+	if opts.Scopes, err = auth.ParseScopes(*scopes); err != nil {
+		return err
+	}
+	if *expiresIn > 0 {
+		at := time.Now().Add(*expiresIn).UTC()
+		opts.Expires = &at
+	}
+	token, err := s.meta.TokenCreate(args[0], *name, opts)
+	if err != nil {
+		return err
+	}
+	return outputTokens(*asJSON, []*auth.Token{token})
+}
+
+// outputTokens writes tokens, including the secret of any that have just been issued.
+func outputTokens(asJSON bool, tokens []*auth.Token) error {
+	return output(asJSON, tokens, func(w io.Writer) {
+		fmt.Fprintln(w, "USER\tNAME\tSCOPES\tCREATED\tLAST USED\tEXPIRES\tSECRET")
+		for _, token := range tokens {
+			secret := token.Secret
+			if secret == "" {
+				secret = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", token.User, token.Name, auth.FormatScopes(token.Scopes), formatTime(&token.Created),
+				formatTime(token.LastUsed), formatTime(token.Expires), secret)
+		}
+	})
+}
+
+func uploadsList(s *stores, args []string) error {
+	fs := flag.NewFlagSet("uploads list", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	user := fs.String("user", "", "User whose uploads are listed, or all users if empty.")
+	sort := fs.String("sort", uploader.SortTime, "Sort by time or size.")
+	asc := fs.Bool("asc", false, "Sort in ascending order.")
+	contentType := fs.String("type", "", "Only list uploads whose content type starts with the value.")
+	filename := fs.String("name", "", "Only list uploads whose filename contains the value.")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	names := []string{*user}
+	if *user == "" {
+		users, err := s.meta.UserList()
+		if err != nil {
+			return err
+		}
+		names = names[:0]
+		for _, u := range users {
+			names = append(names, u.Name)
+		}
+	}
+	base, err := url.Parse(s.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+	us := uploader.NewUploadService(s.meta, s.files)
+	uploads := []uploader.UploadInfo{}
+	for _, name := range names {
+		opts := uploader.ListOptions{Sort: *sort, Ascending: *asc, ContentType: *contentType, Filename: *filename}
+		for {
+			list, err := us.List(name, opts)
+			if err != nil {
+				return err
+			}
+			for _, details := range list.Uploads {
+				uploads = append(uploads, uploader.NewUploadInfo(details, base))
+			}
+			if list.NextCursor == "" {
+				break
+			}
+			opts.Cursor = list.NextCursor
+		}
+	}
+	return output(*asJSON, uploads, func(w io.Writer) {
+		fmt.Fprintln(w, "KEY\tUSER\tSIZE\tTYPE\tUPLOADED\tEXPIRES\tFILENAME")
+		for _, upload := range uploads {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", upload.Key, upload.User, upload.Size, upload.ContentType,
+				formatTime(&upload.Uploaded), formatTime(upload.Expires), upload.Filename)
+		}
+	})
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
+func uploadsInfo(s *stores, args []string) error {
+	fs := flag.NewFlagSet("uploads info", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	args, err := parseFlags(fs, args, "KEY")
+	if err != nil {
+		return err
+	}
+	details, err := uploader.NewUploadService(s.meta, s.files).Info(args[0])
+	if err != nil {
+		return err
+	}
+	base, err := url.Parse(s.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+	info := uploader.NewUploadInfo(*details, base)
+	return output(*asJSON, info, func(w io.Writer) {
+		fmt.Fprintf(w, "Key\t%s\n", info.Key)
+		fmt.Fprintf(w, "User\t%s\n", info.User)
+		fmt.Fprintf(w, "Filename\t%s\n", info.Filename)
+		fmt.Fprintf(w, "Size\t%d\n", info.Size)
+		fmt.Fprintf(w, "Content type\t%s\n", info.ContentType)
+		fmt.Fprintf(w, "Hash\t%s\n", info.Hash)
+		fmt.Fprintf(w, "Uploaded\t%s\n", formatTime(&info.Uploaded))
+		fmt.Fprintf(w, "Expires\t%s\n", formatTime(info.Expires))
+		fmt.Fprintf(w, "URL\t%s\n", info.URL)
+		fmt.Fprintf(w, "Delete URL\t%s\n", info.DeleteURL)
+	})
+}
+
+func uploadsDelete(s *stores, args []string) error {
+	fs := flag.NewFlagSet("uploads delete", flag.ContinueOnError)
+	args, err := parseFlags(fs, args, "KEY")
+	if err != nil {
+		return err
+	}
+	sizes, err := s.cfg.ThumbnailSizes()
+	if err != nil {
+		return err
+	}
+	us := uploader.NewUploadService(s.meta, s.files)
+	us.SetThumbnailSizes(sizes)
+	// The CLI has direct access to the stores, so acts with admin rights.
+	admin := &auth.User{Role: auth.RoleAdmin}
+	if err := us.Delete(args[0], admin); err != nil {
+		return err
+	}
+	// Deletes from the command line are not made through a request, so are recorded here.
+	event := uploader.AuditEvent{
+		Time:   time.Now().UTC(),
+		Action: uploader.AuditDelete,
+		Actor:  uploader.AuditActorAdmin,
+		Key:    args[0],
+		Detail: "command line",
+	}
+	if err := s.meta.AuditAppend(event); err != nil {
+		return fmt.Errorf("deleted %s but failed to record it: %w", args[0], err)
+	}
+	fmt.Printf("deleted %s\n", args[0])
+	return nil
+}
+
+func script(s *stores, args []string) error {
+	fs := flag.NewFlagSet("script", flag.ContinueOnError)
+	name := fs.String("user", "", "User to upload as.")
+	format := fs.String("format", uploader.ScriptShareX, "Script format, one of sharex, sharex-shortener, flameshot or curl.")
+	tokenName := fs.String("token", "", "Name of the token issued for the script, replacing any existing token of that name. Defaults to the format.")
+	scopeList := fs.String("scopes", string(auth.ScopeUpload), "Comma separated scopes of the issued token, from upload, read and delete, or all.")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	scopes, err := auth.ParseScopes(*scopeList)
+	if err != nil {
+		return err
+	}
+	if *name == "" {
+		return errors.New("-user is required")
+	}
+	switch *format {
+	case uploader.ScriptShareX, uploader.ScriptShareXShortener, uploader.ScriptFlameshot, uploader.ScriptCurl:
+	default:
+		return fmt.Errorf("unknown script format %q", *format)
+	}
+	if *tokenName == "" {
+		*tokenName = *format
+	}
+	base, err := url.Parse(s.cfg.BaseURL)
+	if err != nil {
+		return err
+	}
+	token, err := uploader.ReplaceToken(s.meta, *name, *tokenName, auth.TokenOptions{Scopes: scopes})
+	if err != nil {
+		return err
+	}
+	user := &auth.User{Name: *name, AuthToken: token.Secret, Scopes: token.Scopes}
+	out, err := uploader.Script(base, user, *format, uploader.ScriptOptions{Preview: s.cfg.LinkPreviews()})
+	if err != nil {
+		return err
+	}
+	fmt.Println(strings.TrimSuffix(out, "\n"))
+	return nil
+}
+
+func stats(s *stores, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	users, err := s.meta.UserList()
+	if err != nil {
+		return err
+	}
+	type userStats struct {
+		Name string `json:"name"`
+		uploader.Usage
+	}
+	result := struct {
+		Users []userStats    `json:"users"`
+		Total uploader.Usage `json:"total"`
+	}{Users: []userStats{}}
+	for _, user := range users {
+		usage, err := s.meta.UserUsage(user.Name)
+		if err != nil {
+			return err
+		}
+		result.Users = append(result.Users, userStats{Name: user.Name, Usage: *usage})
+		result.Total.Bytes += usage.Bytes
+		result.Total.Files += usage.Files
+	}
+	return output(*asJSON, result, func(w io.Writer) {
+		fmt.Fprintln(w, "USER\tFILES\tBYTES")
+		for _, user := range result.Users {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", user.Name, user.Files, user.Bytes)
+		}
+		fmt.Fprintf(w, "TOTAL\t%d\t%d\n", result.Total.Files, result.Total.Bytes)
+	})
+}
+
+// keysRotate rewraps the data keys of encrypted files with the current master key.
+func keysRotate(s *stores, args []string) error {
+	fs := flag.NewFlagSet("keys rotate", flag.ContinueOnError)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	encrypted, ok := s.files.(*uploader.EncryptedFileStore)
+	if !ok {
+		return errors.New("encryption is not configured")
+	}
+	rotated, err := encrypted.Rotate()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("rewrapped %d data keys\n", rotated)
+	return nil
+}
+
+// fsck checks the stores against each other, repairing the problems found unless it is a dry run.
+func fsck(s *stores, args []string) error {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Report problems without repairing them.")
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	meta, ok := s.meta.(uploader.FsckMeta)
+	if !ok {
+		return errors.New("the meta store can not be checked")
+	}
+	report, err := uploader.Fsck(meta, s.files, *dryRun)
+	if err != nil {
+		return err
+	}
+	return output(*asJSON, report, func(w io.Writer) {
+		fmt.Fprintln(w, "PENDING\tDELETING\tORPHANED UPLOADS\tPLACEHOLDERS\tBLOB REFS\tORPHANED FILES\tDATA KEYS")
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%d\t%d\t%d\n", report.PendingUploads, report.DeletingUploads,
+			report.OrphanedUploads, report.Placeholders, report.BlobRefs, report.OrphanedFiles, report.DataKeys)
+	})
+}
+
+// timeRangeFlags adds the -since and -until flags, returning a function parsing them once the flags are parsed.
+func timeRangeFlags(fs *flag.FlagSet) func() (since, until time.Time, err error) {
+	since := fs.String("since", "", "Only include activity at or after the time, given as RFC 3339, a date or a duration ago.")
+	until := fs.String("until", "", "Only include activity before the time, given as RFC 3339, a date or a duration ago.")
+	return func() (time.Time, time.Time, error) {
+		now := time.Now()
+		from, err := uploader.ParseAuditTime(*since, now)
+		if err != nil {
+			return time.Time{}, time.Time{}, err
+		}
+		to, err := uploader.ParseAuditTime(*until, now)
+		return from, to, err
+	}
+}
+
+func auditEvents(s *stores, args []string) error {
+	fs := flag.NewFlagSet("audit events", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	timeRange := timeRangeFlags(fs)
+	action := fs.String("action", "", "Only list events of the action, one of upload, delete, delete_public or auth_failure.")
+	actor := fs.String("actor", "", "Only list events caused by the user.")
+	key := fs.String("key", "", "Only list events of the upload.")
+	limit := fs.Int("limit", 0, "Largest number of events listed, or zero for no limit.")
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	filter := uploader.AuditFilter{Action: uploader.AuditAction(*action), Actor: *actor, Key: *key, Limit: *limit}
+	var err error
+	if filter.Since, filter.Until, err = timeRange(); err != nil {
+		return err
+	}
+	events, err := s.meta.AuditEvents(filter)
+	if err != nil {
+		return err
+	}
+	if events == nil {
+		events = []uploader.AuditEvent{}
+	}
+	return output(*asJSON, events, func(w io.Writer) {
+		fmt.Fprintln(w, "TIME\tACTION\tACTOR\tIP\tKEY\tDETAIL")
+		for _, event := range events {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", formatTime(&event.Time), event.Action, orDash(event.Actor),
+				orDash(event.IP), orDash(event.Key), orDash(event.Detail))
+		}
+	})
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func auditDownloads(s *stores, args []string) error {
+	fs := flag.NewFlagSet("audit downloads", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "Output as JSON.")
+	timeRange := timeRangeFlags(fs)
+	if _, err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	since, until, err := timeRange()
+	if err != nil {
+		return err
+	}
+	counts, err := s.meta.Downloads(since, until)
+	if err != nil {
+		return err
+	}
+	if counts == nil {
+		counts = []uploader.DownloadCount{}
+	}
+	return output(*asJSON, counts, func(w io.Writer) {
+		fmt.Fprintln(w, "KEY\tDOWNLOADS")
+		for _, count := range counts {
+			fmt.Fprintf(w, "%s\t%d\n", count.Key, count.Downloads)
+		}
+	})
+}
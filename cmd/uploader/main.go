@@ -5,10 +5,12 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
 	"uploader"
+	"uploader/internal/auth"
 
 	"gopkg.in/yaml.v3"
 )
@@ -16,14 +18,49 @@ import (
 var (
 	configPath   = flag.String("cfg", "./uploader.yaml", "Uploader config path.")
 	registerName = flag.String("register", "", "Username to register.")
+	registerRole = flag.String("role", "user", "Role of the registered user, either user or admin.")
 	host         = flag.String("addr", "[::1]", "Address to listen on.")
 	port         = flag.Int("port", 8080, "Port to listen on.")
 )
 
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [flags] [command]
+
+Without a command the server is run. Commands act directly on the configured stores:
+  users list [-json]
+  users disable NAME
+  users enable NAME
+  users set-quota [-max-bytes BYTES] [-max-files FILES] [-clear] NAME
+  users revoke [-token TOKEN] NAME
+  users rotate-token [-json] [-token TOKEN] NAME
+  tokens list [-json] USER
+  tokens create [-json] -name TOKEN [-expires-in DURATION] [-scopes upload,read,delete|all] USER
+  uploads list [-json] [-user NAME] [-sort time|size] [-asc] [-type TYPE] [-name NAME]
+  uploads info [-json] KEY
+  uploads delete KEY
+  script -user NAME [-format sharex|sharex-shortener|flameshot|curl] [-token TOKEN] [-scopes upload,read,delete|all]
+  stats [-json]
+  keys rotate
+  fsck [-json] [-dry-run]
+  audit events [-json] [-since TIME] [-until TIME] [-action ACTION] [-actor NAME] [-key KEY] [-limit N]
+  audit downloads [-json] [-since TIME] [-until TIME]
+
+Flags:
+`, os.Args[0])
+	flag.PrintDefaults()
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
 	cfg := loadConfig(*configPath)
+	if flag.NArg() > 0 {
+		if err := runSubcommand(cfg, flag.Args()); err != nil {
+			log.Fatalf("%s", err)
+		}
+		return
+	}
 	if *registerName != "" {
 		registerUser(cfg, *registerName)
 		return
@@ -40,6 +77,19 @@ func runServer(cfg *uploader.Config) {
 		Handler:           up,
 		Addr:              fmt.Sprintf("%s:%d", *host, *port),
 	}
+	if addr := cfg.MetricsAddress(); addr != "" {
+		metrics := &http.Server{
+			ReadHeaderTimeout: 30 * time.Second,
+			IdleTimeout:       30 * time.Second,
+			Handler:           up.MetricsHandler(),
+			Addr:              addr,
+		}
+		go func() {
+			if err := metrics.ListenAndServe(); err != nil {
+				log.Fatalf("error during runtime of metrics server: %q", err)
+			}
+		}()
+	}
 	if err := server.ListenAndServe(); err != nil {
 		log.Fatalf("error during runtime of server: %q", err)
 	}
@@ -66,11 +116,32 @@ func uploaderFromCfg(cfg *uploader.Config) *uploader.Uploader {
 }
 
 func registerUser(cfg *uploader.Config, name string) {
-	up := uploaderFromCfg(cfg)
-	defer up.Close()
-	user, err := up.Auth.UserRegister(name)
+	role, err := auth.ParseRole(*registerRole)
 	if err != nil {
 		log.Fatalf("Failed to register user: %s", err)
 	}
-	log.Println(up.UploadScript(user))
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		log.Fatalf("Failed to parse base URL: %s", err)
+	}
+	meta, files, err := uploader.OpenStores(cfg)
+	if err != nil {
+		log.Fatalf("Failed to open stores: %s", err)
+	}
+	defer files.Close()
+	defer meta.Close()
+	user, err := meta.UserRegister(name)
+	if err != nil {
+		log.Fatalf("Failed to register user: %s", err)
+	}
+	if role != user.Role {
+		if err = meta.UserSetRole(name, role); err != nil {
+			log.Fatalf("Failed to set user role: %s", err)
+		}
+	}
+	script, err := uploader.Script(base, user, uploader.ScriptShareX, uploader.ScriptOptions{Preview: cfg.LinkPreviews()})
+	if err != nil {
+		log.Fatalf("Failed to create upload script: %s", err)
+	}
+	fmt.Println(script)
 }
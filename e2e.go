@@ -0,0 +1,60 @@
+package uploader
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+
+	"uploader/internal/responses"
+)
+
+// End-to-end encrypted uploads are encrypted by the client before they are sent, and are stored and served as opaque
+// bytes. Their URL, /files/{key}, serves a viewer page that fetches the upload from /files/{key}/{name} and decrypts it
+// in the browser with the key held in the URL fragment. The format of encrypted uploads is defined by the client
+// package, and implemented for browsers by static/e2e.js.
+
+const (
+	// encryptedField is the form field marking an upload as encrypted, falling back to encryptedHeader.
+	encryptedField       = "encrypted"
+	encryptedHeader      = "X-Encrypted"
+	encryptedContentType = "application/octet-stream"
+)
+
+type encryptedPage struct {
+	Size int64
+	User string
+	// DataURL is the encrypted upload, fetched by the viewer.
+	DataURL string
+}
+
+// encryptedView serves the viewer of an encrypted upload, returning false without writing a response if the upload
+// is not encrypted.
+func (u *Uploader) encryptedView(w http.ResponseWriter, key string) bool {
+	details, err := u.us.Info(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return false
+	} else if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return true
+	}
+	if !details.Encrypted {
+		return false
+	}
+	if details.Expired(time.Now()) {
+		responses.Error(w, &responses.BaseResponse{}, http.StatusGone, -1006, "file has expired")
+		return true
+	}
+	// The key never leaves the fragment, but the viewer is kept from sending the page URL anywhere regardless.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	name := details.Filename
+	if name == "" {
+		name = "encrypted"
+	}
+	renderPage(w, http.StatusOK, "encrypted.html", encryptedPage{
+		Size:    details.Size,
+		User:    details.User,
+		DataURL: u.baseURL.JoinPath("/files/", details.Key, name).String(),
+	})
+	return true
+}
@@ -0,0 +1,71 @@
+package uploader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"uploader/client"
+)
+
+func TestEncryptedUploadHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	server := httptest.NewServer(nil)
+	defer server.Close()
+	base, _ := url.Parse(server.URL)
+	server.Config.Handler = NewUploaderHTTP(base, meta, store)
+
+	c, err := client.New(server.URL, "test_user", user.AuthToken)
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	upload, err := c.UploadEncrypted(context.Background(), "secret.txt", "text/plain", strings.NewReader("Hello, World!"))
+	if err != nil {
+		t.Fatalf("failed to upload: %s", err)
+	}
+	link, key, _ := strings.Cut(upload.URL, "#")
+	if link != server.URL+"/files/1" || key == "" {
+		t.Errorf("expected viewer url with the key in the fragment, got %s", upload.URL)
+	}
+	details := meta.files["1"]
+	if !details.Encrypted || details.ContentType != encryptedContentType || details.Filename != client.EncryptedFilename {
+		t.Errorf("unexpected details of encrypted upload %+v", details)
+	}
+	if strings.Contains(string(store.files[details.StorageKey()]), "Hello") {
+		t.Error("expected server to store only the encrypted file")
+	}
+
+	t.Run("viewer", func(t *testing.T) {
+		response, err := http.Get(link)
+		if err != nil {
+			t.Fatalf("failed to get viewer: %s", err)
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK || !strings.HasPrefix(response.Header.Get("Content-Type"), "text/html") {
+			t.Errorf("expected viewer page, got %d %s", response.StatusCode, response.Header.Get("Content-Type"))
+		}
+	})
+	t.Run("download", func(t *testing.T) {
+		file, err := c.Download(context.Background(), upload.URL)
+		if err != nil {
+			t.Fatalf("failed to download: %s", err)
+		}
+		if file.Name != "secret.txt" || string(file.Contents) != "Hello, World!" {
+			t.Errorf("unexpected decrypted file %+v", file)
+		}
+	})
+	t.Run("unencrypted", func(t *testing.T) {
+		meta.addFile("plain", "text/plain")
+		store.Put("plain", strings.NewReader("Hello, World!"))
+		response := httptest.NewRecorder()
+		server.Config.Handler.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/files/plain", nil))
+		if response.Body.String() != "Hello, World!" {
+			t.Errorf("expected unencrypted uploads to be served directly, got %q", response.Body.String())
+		}
+	})
+}
@@ -0,0 +1,411 @@
+package uploader
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Files in an EncryptedFileStore are sealed with a random data key per file, which is itself wrapped by a master key
+// and kept in the metadata store. Rotating the master key rewraps the data keys without touching the stored files.
+//
+// A sealed file is a sequence of AES-GCM chunks, each holding encryptedChunkSize bytes of plaintext except the last,
+// which may be shorter. The nonce of a chunk is its index followed by a flag marking the last chunk, so chunks can not
+// be reordered and the file can not be truncated without detection. As every chunk but the last has the same size,
+// readers can seek to any chunk without decrypting those before it.
+
+const (
+	encryptedChunkSize = 64 << 10
+	// masterKeySize is the size of master and data keys, selecting AES-256.
+	masterKeySize = 32
+)
+
+var ErrUnknownMasterKey = errors.New("data key is wrapped with an unknown master key")
+
+// WrappedKey is a data key encrypted with a master key.
+type WrappedKey struct {
+	// MasterKey is the ID of the master key the data key is wrapped with.
+	MasterKey string `json:"master_key"`
+	Key       []byte `json:"key"`
+}
+
+type DataKeyMeta interface {
+	DataKeyPut(key string, wrapped WrappedKey) error
+	DataKeyGet(key string) (*WrappedKey, error)
+	DataKeyDelete(key string) error
+	// DataKeys returns the file keys of every data key.
+	DataKeys() ([]string, error)
+	// DataKeyRewrap replaces every data key with the result of rewrap, leaving those it returns nil for unchanged.
+	// It returns the number of keys replaced.
+	DataKeyRewrap(rewrap func(key string, wrapped WrappedKey) (*WrappedKey, error)) (int, error)
+}
+
+type masterKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring holds the master key new data keys are wrapped with, and any previous master keys that may still be needed
+// to unwrap existing data keys.
+type Keyring struct {
+	current *masterKey
+	keys    map[string]*masterKey
+}
+
+// NewKeyring creates a keyring wrapping data keys with the current master key. Each key must be 32 bytes.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k := &Keyring{keys: map[string]*masterKey{}}
+	for i, key := range append([][]byte{current}, previous...) {
+		if len(key) != masterKeySize {
+			return nil, fmt.Errorf("master key must be %d bytes, got %d", masterKeySize, len(key))
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(key)
+		master := &masterKey{id: hex.EncodeToString(sum[:8]), aead: aead}
+		k.keys[master.id] = master
+		if i == 0 {
+			k.current = master
+		}
+	}
+	return k, nil
+}
+
+// ParseMasterKey decodes a base64 encoded master key.
+func ParseMasterKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid master key: %w", err)
+	}
+	return key, nil
+}
+
+// ReadMasterKey reads a master key file, holding either the raw key or the key encoded in base64.
+func ReadMasterKey(path string) ([]byte, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(contents) == masterKeySize {
+		return contents, nil
+	}
+	return ParseMasterKey(string(contents))
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrap encrypts the data key of the file with the current master key. The file key is authenticated with it, so a
+// wrapped key can only be used for the file it was made for.
+func (k *Keyring) wrap(key string, dataKey []byte) (*WrappedKey, error) {
+	nonce := make([]byte, k.current.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return &WrappedKey{MasterKey: k.current.id, Key: k.current.aead.Seal(nonce, nonce, dataKey, []byte(key))}, nil
+}
+
+func (k *Keyring) unwrap(key string, wrapped WrappedKey) ([]byte, error) {
+	master, found := k.keys[wrapped.MasterKey]
+	if !found {
+		return nil, fmt.Errorf("%w %s", ErrUnknownMasterKey, wrapped.MasterKey)
+	}
+	size := master.aead.NonceSize()
+	if len(wrapped.Key) < size {
+		return nil, errors.New("wrapped data key is too short")
+	}
+	return master.aead.Open(nil, wrapped.Key[:size], wrapped.Key[size:], []byte(key))
+}
+
+// EncryptedFileStore encrypts the files of another FileStore. Files stored before encryption was enabled have no data
+// key, and are read unchanged.
+type EncryptedFileStore struct {
+	store FileStore
+	meta  DataKeyMeta
+	keys  *Keyring
+}
+
+func NewEncryptedFileStore(store FileStore, meta DataKeyMeta, keys *Keyring) *EncryptedFileStore {
+	return &EncryptedFileStore{store: store, meta: meta, keys: keys}
+}
+
+func (e *EncryptedFileStore) Put(key string, r io.Reader) error {
+	dataKey := make([]byte, masterKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	wrapped, err := e.keys.wrap(key, dataKey)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return err
+	}
+	// The data key is stored first, so that a failure can never leave a sealed file that would be read as plaintext.
+	if err := e.meta.DataKeyPut(key, *wrapped); err != nil {
+		return err
+	}
+	err = e.store.Put(key, &sealReader{r: r, aead: aead, buf: make([]byte, encryptedChunkSize+1)})
+	if err != nil {
+		// For the same reason the data key is only removed once any partly written file is.
+		if deleteErr := e.store.Delete(key); deleteErr == nil || errors.Is(deleteErr, os.ErrNotExist) {
+			e.meta.DataKeyDelete(key)
+		}
+	}
+	return err
+}
+
+// Get decrypts the file as it is read. The reader supports seeking if the underlying store's reader does.
+func (e *EncryptedFileStore) Get(key string) (io.ReadCloser, error) {
+	wrapped, err := e.meta.DataKeyGet(key)
+	if errors.Is(err, ErrNotFound) {
+		return e.store.Get(key)
+	} else if err != nil {
+		return nil, err
+	}
+	dataKey, err := e.keys.unwrap(key, *wrapped)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	file, err := e.store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	reader := &openReader{file: file, aead: aead, buf: make([]byte, encryptedChunkSize+aead.Overhead()+1)}
+	if seeker, ok := file.(io.Seeker); ok {
+		return &seekableOpenReader{reader, seeker, -1}, nil
+	}
+	return reader, nil
+}
+
+// Delete removes the file and its data key. A file that is already missing still has its data key removed.
+func (e *EncryptedFileStore) Delete(key string) error {
+	if err := e.store.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if err := e.meta.DataKeyDelete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Keys lists the files of the underlying store, whose keys are not encrypted.
+func (e *EncryptedFileStore) Keys(prefix string) ([]string, error) {
+	lister, ok := e.store.(FileLister)
+	if !ok {
+		return nil, ErrListUnsupported
+	}
+	return lister.Keys(prefix)
+}
+
+func (e *EncryptedFileStore) Close() error {
+	return e.store.Close()
+}
+
+// Rotate rewraps every data key that is not wrapped with the current master key, returning the number rewrapped.
+// Previous master keys are no longer needed once it has succeeded.
+func (e *EncryptedFileStore) Rotate() (int, error) {
+	return e.meta.DataKeyRewrap(func(key string, wrapped WrappedKey) (*WrappedKey, error) {
+		if wrapped.MasterKey == e.keys.current.id {
+			return nil, nil
+		}
+		dataKey, err := e.keys.unwrap(key, wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		return e.keys.wrap(key, dataKey)
+	})
+}
+
+func chunkNonce(nonce []byte, index uint64, last bool) []byte {
+	for i := range nonce {
+		nonce[i] = 0
+	}
+	binary.BigEndian.PutUint64(nonce[len(nonce)-9:], index)
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
+
+// sealReader encrypts the plaintext read from r. It reads a byte past each chunk to find whether the chunk is last.
+type sealReader struct {
+	r     io.Reader
+	aead  cipher.AEAD
+	index uint64
+	// buf holds a chunk of plaintext and the byte following it, carried over to the next chunk.
+	buf    []byte
+	carry  int
+	nonce  [12]byte
+	sealed []byte
+	out    []byte
+	done   bool
+}
+
+func (s *sealReader) Read(p []byte) (int, error) {
+	for len(s.out) == 0 {
+		if s.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(s.r, s.buf[s.carry:])
+		n += s.carry
+		s.done = errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !s.done {
+			return 0, err
+		}
+		size := n
+		if !s.done {
+			size = encryptedChunkSize
+		}
+		s.sealed = s.aead.Seal(s.sealed[:0], chunkNonce(s.nonce[:], s.index, s.done), s.buf[:size], nil)
+		s.out = s.sealed
+		if !s.done {
+			s.buf[0], s.carry = s.buf[encryptedChunkSize], 1
+		}
+		s.index++
+	}
+	n := copy(p, s.out)
+	s.out = s.out[n:]
+	return n, nil
+}
+
+// openReader decrypts a sealed file read from file.
+type openReader struct {
+	file  io.ReadCloser
+	aead  cipher.AEAD
+	index uint64
+	// buf holds a sealed chunk and the byte following it, carried over to the next chunk.
+	buf   []byte
+	carry int
+	nonce [12]byte
+	plain []byte
+	out   []byte
+	done  bool
+	// offset is the position of the next byte read in the plaintext.
+	offset int64
+}
+
+func (o *openReader) Read(p []byte) (int, error) {
+	for len(o.out) == 0 {
+		if o.done {
+			return 0, io.EOF
+		}
+		if err := o.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, o.out)
+	o.out = o.out[n:]
+	o.offset += int64(n)
+	return n, nil
+}
+
+// next decrypts the following chunk.
+func (o *openReader) next() error {
+	sealedSize := encryptedChunkSize + o.aead.Overhead()
+	n, err := io.ReadFull(o.file, o.buf[o.carry:])
+	n += o.carry
+	o.done = errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !o.done {
+		return err
+	}
+	size := n
+	if !o.done {
+		size = sealedSize
+	}
+	o.plain, err = o.aead.Open(o.plain[:0], chunkNonce(o.nonce[:], o.index, o.done), o.buf[:size], nil)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", o.index, err)
+	}
+	o.out = o.plain
+	o.carry = 0
+	if !o.done {
+		o.buf[0], o.carry = o.buf[sealedSize], 1
+	}
+	o.index++
+	return nil
+}
+
+func (o *openReader) Close() error {
+	return o.file.Close()
+}
+
+// seekableOpenReader seeks by moving the underlying file to the start of the chunk holding the offset. The plaintext
+// size is found from the size of the sealed file when it is first needed.
+type seekableOpenReader struct {
+	*openReader
+	seeker io.Seeker
+	size   int64
+}
+
+func (s *seekableOpenReader) plainSize() (int64, error) {
+	if s.size >= 0 {
+		return s.size, nil
+	}
+	sealed, err := s.seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	sealedChunk := int64(encryptedChunkSize + s.aead.Overhead())
+	chunks := (sealed + sealedChunk - 1) / sealedChunk
+	if chunks == 0 || sealed-(chunks-1)*sealedChunk < int64(s.aead.Overhead()) {
+		return 0, errors.New("sealed file is truncated")
+	}
+	s.size = sealed - chunks*int64(s.aead.Overhead())
+	return s.size, nil
+}
+
+func (s *seekableOpenReader) Seek(offset int64, whence int) (int64, error) {
+	size, err := s.plainSize()
+	if err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek to negative offset")
+	}
+	s.out, s.carry, s.done, s.offset = nil, 0, false, offset
+	if offset >= size {
+		// Reads past the end return io.EOF without touching the file.
+		s.done = true
+		return offset, nil
+	}
+	index := offset / encryptedChunkSize
+	if _, err := s.seeker.Seek(index*int64(encryptedChunkSize+s.aead.Overhead()), io.SeekStart); err != nil {
+		return 0, err
+	}
+	s.index = uint64(index)
+	if skip := offset % encryptedChunkSize; skip > 0 {
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+		s.out = s.out[skip:]
+	}
+	return offset, nil
+}
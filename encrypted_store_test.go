@@ -0,0 +1,264 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testMasterKey(t testing.TB) []byte {
+	key := make([]byte, masterKeySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatalf("failed to generate key: %s", err)
+	}
+	return key
+}
+
+func newTestEncryptedStore(t testing.TB, keys ...[]byte) (*EncryptedFileStore, *memoryFileStore, *BoltStore) {
+	keyring, err := NewKeyring(keys[0], keys[1:]...)
+	if err != nil {
+		t.Fatalf("failed to create keyring: %s", err)
+	}
+	files, meta := newMemoryFileStore(), newTestBolt(t)
+	t.Cleanup(func() { meta.Close() })
+	return NewEncryptedFileStore(files, meta, keyring), files, meta
+}
+
+func readEncrypted(t *testing.T, store FileStore, key string) []byte {
+	reader, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", key, err)
+	}
+	defer reader.Close()
+	contents, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", key, err)
+	}
+	return contents
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	store, files, _ := newTestEncryptedStore(t, testMasterKey(t))
+	for _, size := range []int{0, 1, encryptedChunkSize - 1, encryptedChunkSize, encryptedChunkSize + 1, 3*encryptedChunkSize + 5} {
+		plaintext := make([]byte, size)
+		rand.Read(plaintext)
+		if err := store.Put("file", bytes.NewReader(plaintext)); err != nil {
+			t.Fatalf("failed to store %d bytes: %s", size, err)
+		}
+		// Short plaintexts may appear in the ciphertext by chance.
+		if size >= 16 && bytes.Contains(files.files["file"], plaintext) {
+			t.Errorf("expected %d bytes to be stored encrypted", size)
+		}
+		if got := readEncrypted(t, store, "file"); !bytes.Equal(got, plaintext) {
+			t.Errorf("%d bytes did not round trip, got %d bytes", size, len(got))
+		}
+	}
+}
+
+func TestEncryptedFileStore_Seek(t *testing.T) {
+	store, _, _ := newTestEncryptedStore(t, testMasterKey(t))
+	plaintext := make([]byte, 2*encryptedChunkSize+100)
+	rand.Read(plaintext)
+	store.Put("file", bytes.NewReader(plaintext))
+
+	reader, err := store.Get("file")
+	if err != nil {
+		t.Fatalf("failed to open file: %s", err)
+	}
+	defer reader.Close()
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("expected reader of a seekable store to seek")
+	}
+	if size, err := seeker.Seek(0, io.SeekEnd); err != nil || size != int64(len(plaintext)) {
+		t.Fatalf("expected plaintext size %d, got %d %v", len(plaintext), size, err)
+	}
+	for _, offset := range []int64{0, 10, encryptedChunkSize, encryptedChunkSize + 7, 2*encryptedChunkSize + 99} {
+		if _, err := seeker.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("failed to seek to %d: %s", offset, err)
+		}
+		got := make([]byte, 50)
+		n, _ := io.ReadFull(seeker, got)
+		if want := plaintext[offset:minInt64(offset+50, int64(len(plaintext)))]; !bytes.Equal(got[:n], want) {
+			t.Errorf("unexpected contents at offset %d", offset)
+		}
+		if position, _ := seeker.Seek(0, io.SeekCurrent); position != offset+int64(n) {
+			t.Errorf("expected position %d after reading, got %d", offset+int64(n), position)
+		}
+	}
+	seeker.Seek(int64(len(plaintext)), io.SeekStart)
+	if n, err := seeker.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+		t.Errorf("expected EOF reading at the end, got %d %v", n, err)
+	}
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestEncryptedFileStore_Tampering(t *testing.T) {
+	store, files, _ := newTestEncryptedStore(t, testMasterKey(t))
+	plaintext := bytes.Repeat([]byte("a"), 2*encryptedChunkSize)
+	store.Put("file", bytes.NewReader(plaintext))
+	sealed := files.files["file"]
+
+	tests := map[string][]byte{
+		"modified":  append(append([]byte{}, sealed[:10]...), append([]byte{sealed[10] ^ 1}, sealed[11:]...)...),
+		"truncated": sealed[:encryptedChunkSize+16],
+		"reordered": append(append([]byte{}, sealed[encryptedChunkSize+16:]...), sealed[:encryptedChunkSize+16]...),
+	}
+	for name, contents := range tests {
+		files.files["file"] = contents
+		reader, err := store.Get("file")
+		if err != nil {
+			t.Fatalf("%s: failed to open file: %s", name, err)
+		}
+		if _, err := io.ReadAll(reader); err == nil {
+			t.Errorf("%s: expected decryption to fail", name)
+		}
+	}
+}
+
+func TestEncryptedFileStore_Plaintext(t *testing.T) {
+	store, files, _ := newTestEncryptedStore(t, testMasterKey(t))
+	files.files["legacy"] = []byte("Hello, World!")
+	if got := readEncrypted(t, store, "legacy"); string(got) != "Hello, World!" {
+		t.Errorf("expected files stored before encryption to be read unchanged, got %q", got)
+	}
+	if _, err := store.Get("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected missing file to not exist, got %v", err)
+	}
+}
+
+func TestEncryptedFileStore_Delete(t *testing.T) {
+	store, files, meta := newTestEncryptedStore(t, testMasterKey(t))
+	store.Put("file", strings.NewReader("Hello, World!"))
+	if err := store.Delete("file"); err != nil {
+		t.Fatalf("unexpected error deleting file: %s", err)
+	}
+	if _, found := files.files["file"]; found {
+		t.Error("expected file to be deleted")
+	}
+	if _, err := meta.DataKeyGet("file"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected data key to be deleted, got %v", err)
+	}
+
+	meta.DataKeyPut("missing", WrappedKey{MasterKey: "old", Key: []byte("key")})
+	if err := store.Delete("missing"); err != nil {
+		t.Fatalf("unexpected error deleting missing file: %s", err)
+	}
+	if _, err := meta.DataKeyGet("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected data key of missing file to be deleted, got %v", err)
+	}
+}
+
+func TestEncryptedFileStore_PutFailure(t *testing.T) {
+	_, files, meta := newTestEncryptedStore(t, testMasterKey(t))
+	keyring, _ := NewKeyring(testMasterKey(t))
+	store := NewEncryptedFileStore(failingFileStore{files}, meta, keyring)
+	if err := store.Put("file", strings.NewReader("Hello, World!")); err == nil {
+		t.Fatal("expected put to fail")
+	}
+	if _, found := files.files["file"]; found {
+		t.Error("expected partly written file to be deleted")
+	}
+	if _, err := meta.DataKeyGet("file"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected data key to be deleted, got %v", err)
+	}
+}
+
+func TestEncryptedFileStore_Rotate(t *testing.T) {
+	oldKey, newKey := testMasterKey(t), testMasterKey(t)
+	store, files, meta := newTestEncryptedStore(t, oldKey)
+	for _, key := range []string{"a", "b"} {
+		store.Put(key, strings.NewReader("Hello, "+key))
+	}
+	sealed := append([]byte{}, files.files["a"]...)
+
+	keyring, _ := NewKeyring(newKey, oldKey)
+	rotating := NewEncryptedFileStore(files, meta, keyring)
+	rotating.Put("c", strings.NewReader("Hello, c"))
+	rotated, err := rotating.Rotate()
+	if err != nil {
+		t.Fatalf("unexpected error rotating keys: %s", err)
+	}
+	if rotated != 2 {
+		t.Errorf("expected the two old data keys to be rewrapped, got %d", rotated)
+	}
+	if !bytes.Equal(files.files["a"], sealed) {
+		t.Error("expected rotation not to rewrite files")
+	}
+
+	keyring, _ = NewKeyring(newKey)
+	rotated, _ = NewEncryptedFileStore(files, meta, keyring).Rotate()
+	if rotated != 0 {
+		t.Errorf("expected no keys to need rewrapping, got %d", rotated)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if got := readEncrypted(t, NewEncryptedFileStore(files, meta, keyring), key); string(got) != "Hello, "+key {
+			t.Errorf("unexpected contents of %s after rotation %q", key, got)
+		}
+	}
+
+	keyring, _ = NewKeyring(oldKey)
+	if _, err := NewEncryptedFileStore(files, meta, keyring).Get("a"); !errors.Is(err, ErrUnknownMasterKey) {
+		t.Errorf("expected old master key to no longer unwrap data keys, got %v", err)
+	}
+}
+
+func TestEncryptionConfig(t *testing.T) {
+	key, previous := testMasterKey(t), testMasterKey(t)
+	keyFile := filepath.Join(t.TempDir(), "master.key")
+	os.WriteFile(keyFile, key, 0o600)
+
+	cfg := &encryptionCfg{KeyFile: keyFile, PreviousKeys: []string{base64.StdEncoding.EncodeToString(previous)}}
+	keyring, err := cfg.keyring()
+	if err != nil {
+		t.Fatalf("unexpected error loading keys: %s", err)
+	}
+	expected, _ := NewKeyring(key, previous)
+	if keyring.current.id != expected.current.id || len(keyring.keys) != 2 {
+		t.Errorf("expected key file to be the current key, got %s of %d keys", keyring.current.id, len(keyring.keys))
+	}
+
+	for name, invalid := range map[string]*encryptionCfg{
+		"none":  {},
+		"both":  {Key: base64.StdEncoding.EncodeToString(key), KeyFile: keyFile},
+		"short": {Key: base64.StdEncoding.EncodeToString(key[:16])},
+	} {
+		if _, err := invalid.keyring(); err == nil {
+			t.Errorf("%s: expected invalid config to fail", name)
+		}
+	}
+}
+
+func TestEncryptedFileStore_RangeHTTP(t *testing.T) {
+	store, _, _ := newTestEncryptedStore(t, testMasterKey(t))
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, uploadRequest(t, user.AuthToken))
+	assertStatusCode(t, response, http.StatusAccepted)
+
+	request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+	request.Header.Set("Range", "bytes=7-11")
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusPartialContent)
+	if response.Body.String() != "World" {
+		t.Errorf("unexpected range of encrypted file %q", response.Body.String())
+	}
+}
@@ -0,0 +1,153 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultReapInterval = time.Minute
+
+var (
+	// ErrExpired is returned when an upload has passed its expiry but has not yet been removed by the reaper.
+	ErrExpired = errors.New("upload expired")
+	// ErrExpiryTooLong is returned when the requested lifetime exceeds the maximum permitted for the user.
+	ErrExpiryTooLong = errors.New("requested expiry exceeds maximum")
+	// ErrInvalidExpiry is returned when the requested lifetime is negative or can not be represented.
+	ErrInvalidExpiry = errors.New("invalid expiry")
+)
+
+// ExpiryLimits are the default and maximum lifetime of uploads. Zero values mean no default expiry, and no maximum.
+type ExpiryLimits struct {
+	Default time.Duration
+	Max     time.Duration
+}
+
+// ExpiryPolicy determines the lifetime of new uploads, with limits optionally overridden per user.
+type ExpiryPolicy struct {
+	ExpiryLimits
+	Users map[string]ExpiryLimits
+}
+
+func (p ExpiryPolicy) limits(user string) ExpiryLimits {
+	if limits, found := p.Users[user]; found {
+		return limits
+	}
+	return p.ExpiryLimits
+}
+
+// Lifetime resolves the requested lifetime of an upload, where zero requests the default.
+// A zero result means the upload does not expire.
+func (p ExpiryPolicy) Lifetime(user string, requested time.Duration) (time.Duration, error) {
+	limits := p.limits(user)
+	if requested < 0 {
+		return 0, fmt.Errorf("%w %s", ErrInvalidExpiry, requested)
+	}
+	if requested == 0 {
+		requested = limits.Default
+	}
+	if limits.Max > 0 {
+		if requested == 0 {
+			return limits.Max, nil
+		}
+		if requested > limits.Max {
+			return 0, ErrExpiryTooLong
+		}
+	}
+	return requested, nil
+}
+
+// ParseExpiresIn parses a lifetime given as either a Go duration such as "36h" or a number of seconds.
+// An empty value returns zero, requesting the default. Negative and overflowing values return ErrInvalidExpiry.
+func ParseExpiresIn(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 || seconds > math.MaxInt64/int64(time.Second) {
+			return 0, fmt.Errorf("%w %s", ErrInvalidExpiry, value)
+		}
+		return time.Duration(seconds) * time.Second, nil
+	}
+	lifetime, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s", ErrInvalidExpiry, err)
+	} else if lifetime < 0 {
+		return 0, fmt.Errorf("%w %s", ErrInvalidExpiry, value)
+	}
+	return lifetime, nil
+}
+
+// Expired reports whether the upload has an expiry at or before now.
+func (u *UploadDetails) Expired(now time.Time) bool {
+	return u.Expires != nil && !u.Expires.After(now)
+}
+
+// ReapExpired removes every upload and link that expired at or before now, returning the number removed.
+func (u *uploadService) ReapExpired(now time.Time) (int, error) {
+	keys, err := u.meta.ExpiredFiles(now)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, key := range keys {
+		if err := u.reapKey(key); err != nil {
+			// One failure must not stop the expiry of the remaining keys, which are retried on the next pass.
+			log.Printf("failed to remove expired %s: %s", key, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// reapKey removes the expired upload or link with the key. Keys removed concurrently, such as by their owner, are not
+// an error.
+func (u *uploadService) reapKey(key string) error {
+	details, err := u.Info(key)
+	if errors.Is(err, os.ErrNotExist) {
+		// Links share the expiry index with uploads.
+		if err := u.meta.LinkDelete(key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := u.remove(details); err != nil {
+		return err
+	}
+	u.recordSystem(AuditDelete, key, "expired")
+	return nil
+}
+
+// reaper periodically removes expired uploads and abandoned partial uploads until stop is closed.
+func reaper(us UploadService, tus *tusHandler, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			removed, err := us.ReapExpired(now)
+			if err != nil {
+				log.Printf("error removing expired uploads: %s", err)
+			}
+			if removed > 0 {
+				log.Printf("removed %d expired uploads", removed)
+			}
+			removed, err = tus.reapStale(now)
+			if err != nil {
+				log.Printf("error removing abandoned partial uploads: %s", err)
+			}
+			if removed > 0 {
+				log.Printf("removed %d abandoned partial uploads", removed)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package uploader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestExpiryPolicy_Lifetime(t *testing.T) {
+	policy := ExpiryPolicy{
+		ExpiryLimits: ExpiryLimits{Default: time.Hour, Max: 24 * time.Hour},
+		Users: map[string]ExpiryLimits{
+			"forever_user": {},
+			"capped_user":  {Max: 2 * time.Hour},
+		},
+	}
+	tests := map[string]struct {
+		user      string
+		requested time.Duration
+		want      time.Duration
+		err       error
+	}{
+		"default":            {"test_user", 0, time.Hour, nil},
+		"requested":          {"test_user", 3 * time.Hour, 3 * time.Hour, nil},
+		"over maximum":       {"test_user", 48 * time.Hour, 0, ErrExpiryTooLong},
+		"user without limit": {"forever_user", 0, 0, nil},
+		"user maximum":       {"capped_user", 0, 2 * time.Hour, nil},
+		"user over maximum":  {"capped_user", 3 * time.Hour, 0, ErrExpiryTooLong},
+		"negative":           {"test_user", -time.Hour, 0, ErrInvalidExpiry},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := policy.Lifetime(test.user, test.requested)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("unexpected error, want %v got %v", test.err, err)
+			}
+			if got != test.want {
+				t.Errorf("wrong lifetime, want %s got %s", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseExpiresIn(t *testing.T) {
+	tests := map[string]time.Duration{
+		"":      0,
+		"3600":  time.Hour,
+		"90m":   90 * time.Minute,
+		"1h30m": 90 * time.Minute,
+	}
+	for value, want := range tests {
+		got, err := ParseExpiresIn(value)
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", value, err)
+		}
+		if got != want {
+			t.Errorf("parsing %q, want %s got %s", value, want, got)
+		}
+	}
+	for _, value := range []string{"soon", "-60", "-1h", "9223372036854775807", "9999999999h"} {
+		if _, err := ParseExpiresIn(value); !errors.Is(err, ErrInvalidExpiry) {
+			t.Errorf("expected invalid expiry error parsing %q, got %v", value, err)
+		}
+	}
+}
+
+func TestUploadService_ReapExpired(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	service := NewUploadService(meta, store)
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Minute)
+	for key, expires := range map[string]*time.Time{"expired": &past, "future": &future, "forever": nil} {
+		meta.addFile(key, "text/plain")
+		meta.files[key].Expires = expires
+		store.Put(key, strings.NewReader("Hello, World!"))
+	}
+
+	if _, _, err := service.Get("expired", ""); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected expired error before reaping, got %v", err)
+	}
+	removed, err := service.ReapExpired(now)
+	if err != nil {
+		t.Fatalf("unexpected error reaping uploads %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected one upload to be removed, got %d", removed)
+	}
+	if _, err := store.Get("expired"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected expired file to be removed from the store")
+	}
+	want := []AuditEvent{{Action: AuditDelete, Actor: AuditActorSystem, Key: "expired", Detail: "expired"}}
+	if diff := cmp.Diff(want, meta.audit, cmpopts.IgnoreFields(AuditEvent{}, "Time")); diff != "" {
+		t.Errorf("unexpected audit log %s", diff)
+	}
+	for _, key := range []string{"future", "forever"} {
+		if _, found := meta.files[key]; !found {
+			t.Errorf("expected %s to remain", key)
+		}
+	}
+}
+
+// reapMeta lists keys as expired that have since been removed, or that fail to load.
+type reapMeta struct {
+	*testMeta
+}
+
+func (r reapMeta) ExpiredFiles(before time.Time) ([]string, error) {
+	keys, err := r.testMeta.ExpiredFiles(before)
+	return append([]string{"broken", "gone"}, keys...), err
+}
+
+func (r reapMeta) FileGet(key string) (*UploadDetails, error) {
+	if key == "broken" {
+		return nil, errors.New("corrupt record")
+	}
+	return r.testMeta.FileGet(key)
+}
+
+func TestUploadService_ReapExpiredFailures(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	service := NewUploadService(reapMeta{meta}, store)
+	now := time.Now()
+	past := now.Add(-time.Minute)
+	meta.addFile("expired", "text/plain")
+	meta.files["expired"].Expires = &past
+	store.Put("expired", strings.NewReader("Hello, World!"))
+
+	removed, err := service.ReapExpired(now)
+	if err != nil {
+		t.Fatalf("unexpected error reaping uploads %s", err)
+	}
+	// The key removed concurrently is gone either way, while the broken key is left for the next pass.
+	if removed != 2 {
+		t.Errorf("expected two keys to be removed, got %d", removed)
+	}
+	if _, found := meta.files["expired"]; found {
+		t.Error("expected upload after a failing key to be removed")
+	}
+}
+
+func TestExpiryHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	us := NewUploadService(meta, store)
+	us.expiry = ExpiryPolicy{ExpiryLimits: ExpiryLimits{Max: 24 * time.Hour}}
+	uploader := newUploaderHTTP(baseURL, meta, store, us, nil)
+
+	t.Run("upload with header", func(t *testing.T) {
+		request := uploadRequest(t, user.AuthToken)
+		request.Header.Set(expiresInHeader, "1h")
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusAccepted)
+		expires := meta.files["1"].Expires
+		if expires == nil || expires.Sub(meta.files["1"].Uploaded) != time.Hour {
+			t.Errorf("expected upload to expire after an hour, got %v", expires)
+		}
+	})
+	t.Run("upload over maximum", func(t *testing.T) {
+		request := uploadRequest(t, user.AuthToken)
+		request.Header.Set(expiresInHeader, "48h")
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusBadRequest)
+	})
+	t.Run("negative expiry", func(t *testing.T) {
+		request := uploadRequest(t, user.AuthToken)
+		request.Header.Set(expiresInHeader, "-1h")
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusBadRequest)
+	})
+	t.Run("expired download", func(t *testing.T) {
+		past := time.Now().Add(-time.Second)
+		meta.files["1"].Expires = &past
+		request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusGone)
+	})
+}
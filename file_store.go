@@ -1,18 +1,42 @@
 package uploader
 
 import (
+	"errors"
 	"io"
 	"os"
 	"path"
+	"strings"
 )
 
 type FileStore interface {
 	Close() error
 	Put(key string, file io.Reader) error
+	// Get opens the stored file for reading. Implementations should return a reader that also implements io.Seeker
+	// where possible, as this allows range requests to be served without reading the entire file.
 	Get(key string) (io.ReadCloser, error)
 	Delete(key string) error
 }
 
+// URLSigner is an optional FileStore capability for backends that can serve files directly to clients.
+// When the store implements it, downloads are redirected to the signed URL instead of being streamed by the uploader.
+type URLSigner interface {
+	// SignURL returns a short-lived URL for the file. The content type and disposition are applied to the response
+	// served from that URL. ErrSignedURLUnsupported is returned if signing is disabled for the store.
+	SignURL(key, contentType, disposition string) (string, error)
+}
+
+var ErrSignedURLUnsupported = errors.New("signed urls are not supported")
+
+// FileLister is an optional FileStore capability for backends that can list the files they hold, which allows fsck to
+// find stored files that nothing refers to, and deletes to find thumbnails of sizes that are no longer configured.
+type FileLister interface {
+	// Keys returns the keys of every stored file starting with prefix. ErrListUnsupported is returned if the store
+	// can not list them.
+	Keys(prefix string) ([]string, error)
+}
+
+var ErrListUnsupported = errors.New("listing files is not supported")
+
 type DirectoryFileStore struct {
 	prefix string
 }
@@ -33,6 +57,7 @@ func (d *DirectoryFileStore) Put(key string, r io.Reader) error {
 	return err
 }
 
+// Get returns the underlying *os.File, which supports seeking for range reads.
 func (d *DirectoryFileStore) Get(key string) (io.ReadCloser, error) {
 	return os.Open(path.Join(d.prefix, key))
 }
@@ -41,6 +66,20 @@ func (d *DirectoryFileStore) Delete(key string) error {
 	return os.Remove(path.Join(d.prefix, key))
 }
 
+func (d *DirectoryFileStore) Keys(prefix string) ([]string, error) {
+	entries, err := os.ReadDir(d.prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && strings.HasPrefix(entry.Name(), prefix) {
+			keys = append(keys, entry.Name())
+		}
+	}
+	return keys, nil
+}
+
 func (d *DirectoryFileStore) Close() error {
 	return nil
 }
@@ -0,0 +1,362 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Uploads and deletes change the MetaStore and the FileStore in several steps, and are undone as they fail. An
+// interrupted server can not undo them, so fsck reconciles the stores when the server starts: pending uploads are
+// rolled back, deleting uploads are finished, and the references held to blobs are counted again. Files, details and
+// keys that nothing refers to any more are removed.
+
+// FsckMeta is the access to the MetaStore needed to check it against the FileStore.
+type FsckMeta interface {
+	UploadMeta
+	PartialMeta
+	// AllFiles returns the details of every upload in any state.
+	AllFiles() ([]UploadDetails, error)
+	// Placeholders returns the keys reserved by FileKey that hold neither an upload nor a link.
+	Placeholders() ([]string, error)
+	Blobs() ([]Blob, error)
+	// BlobSet replaces the blob with the given hash, removing it if it has no references.
+	BlobSet(blob Blob) error
+}
+
+// FsckReport counts the problems found by Fsck, each of which has been repaired unless it was a dry run.
+type FsckReport struct {
+	// PendingUploads were interrupted while their file was stored, and are rolled back.
+	PendingUploads int `json:"pending_uploads"`
+	// DeletingUploads were interrupted while their file was removed, and are removed.
+	DeletingUploads int `json:"deleting_uploads"`
+	// OrphanedUploads have no stored file, and are removed.
+	OrphanedUploads int `json:"orphaned_uploads"`
+	// Placeholders are keys reserved for uploads or links that were never stored, and are released.
+	Placeholders int `json:"placeholders"`
+	// BlobRefs are blobs with the wrong number of references, which are corrected. Blobs left without references
+	// are removed.
+	BlobRefs int `json:"blob_refs"`
+	// OrphanedFiles are stored blobs, thumbnails and chunks that nothing refers to, and are removed. They are only
+	// found in stores implementing FileLister.
+	OrphanedFiles int `json:"orphaned_files"`
+	// DataKeys are the keys of encrypted files that are not stored, left when storing or deleting a file failed, and
+	// are removed.
+	DataKeys int `json:"data_keys"`
+}
+
+// Problems returns the total number of problems found.
+func (r *FsckReport) Problems() int {
+	return r.PendingUploads + r.DeletingUploads + r.OrphanedUploads + r.Placeholders + r.BlobRefs + r.OrphanedFiles +
+		r.DataKeys
+}
+
+func (r *FsckReport) String() string {
+	return fmt.Sprintf("%d pending uploads, %d deleting uploads, %d orphaned uploads, %d placeholders, %d blob references, %d orphaned files, %d data keys",
+		r.PendingUploads, r.DeletingUploads, r.OrphanedUploads, r.Placeholders, r.BlobRefs, r.OrphanedFiles, r.DataKeys)
+}
+
+// errNoStoredFiles stops fsck from removing every upload when the FileStore holds none of their files, which is far
+// more likely to be a misconfigured store than lost files.
+var errNoStoredFiles = errors.New("no upload has a stored file, check the file store configuration")
+
+type fsck struct {
+	meta   FsckMeta
+	store  FileStore
+	dryRun bool
+	report FsckReport
+	// stored holds the keys of every stored file, or is nil if the store can not list them.
+	stored map[string]bool
+	// live holds the storage keys of committed uploads and blobs.
+	live map[string]bool
+	// removed holds the keys of the files removed, whose data keys are removed along with them.
+	removed map[string]bool
+}
+
+// Fsck checks the MetaStore against the FileStore and repairs the problems found. A dry run only reports them. The
+// stores must not be in use while they are checked.
+func Fsck(meta FsckMeta, store FileStore, dryRun bool) (*FsckReport, error) {
+	f := &fsck{meta: meta, store: store, dryRun: dryRun, live: map[string]bool{}, removed: map[string]bool{}}
+	if lister, ok := store.(FileLister); ok {
+		keys, err := lister.Keys("")
+		if err != nil && !errors.Is(err, ErrListUnsupported) {
+			return nil, err
+		}
+		if err == nil {
+			f.stored = make(map[string]bool, len(keys))
+			for _, key := range keys {
+				f.stored[key] = true
+			}
+		}
+	}
+	files, err := meta.AllFiles()
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := meta.Blobs()
+	if err != nil {
+		return nil, err
+	}
+	refs, err := f.uploads(files, blobs)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.blobs(blobs, refs); err != nil {
+		return nil, err
+	}
+	if err := f.placeholders(); err != nil {
+		return nil, err
+	}
+	if err := f.files(); err != nil {
+		return nil, err
+	}
+	if encrypted, ok := store.(*EncryptedFileStore); ok {
+		if err := f.dataKeys(encrypted); err != nil {
+			return nil, err
+		}
+	}
+	return &f.report, nil
+}
+
+// uploads finishes interrupted uploads and deletes and removes uploads without a file, returning the references held
+// to each blob by the remaining uploads.
+func (f *fsck) uploads(files []UploadDetails, blobs []Blob) (map[string]*Blob, error) {
+	blobKeys := map[string]bool{}
+	for _, blob := range blobs {
+		blobKeys[blob.Key] = true
+	}
+	refs := map[string]*Blob{}
+	var orphaned []string
+	found := 0
+	for _, upload := range files {
+		switch upload.State {
+		case UploadPending:
+			f.report.PendingUploads++
+			// Files that became blobs are removed with the blob once its references are counted.
+			if !blobKeys[upload.Blob] {
+				if err := f.deleteFile(upload.Blob); err != nil {
+					return nil, err
+				}
+			}
+			if err := f.deleteUpload(upload.Key); err != nil {
+				return nil, err
+			}
+		case UploadDeleting:
+			f.report.DeletingUploads++
+			if upload.Blob == "" {
+				if err := f.deleteFile(upload.Key); err != nil {
+					return nil, err
+				}
+			}
+			if err := f.deleteUpload(upload.Key); err != nil {
+				return nil, err
+			}
+		case UploadCommitted:
+			exists, err := f.exists(upload.StorageKey())
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				orphaned = append(orphaned, upload.Key)
+				continue
+			}
+			found++
+			f.live[upload.StorageKey()] = true
+			if upload.Blob == "" {
+				continue
+			}
+			if refs[upload.Hash] == nil {
+				refs[upload.Hash] = &Blob{Hash: upload.Hash, Key: upload.Blob}
+			}
+			refs[upload.Hash].Refs++
+		default:
+			return nil, fmt.Errorf("upload %s has unknown state %q", upload.Key, upload.State)
+		}
+	}
+	if found == 0 && len(orphaned) > 0 {
+		return nil, errNoStoredFiles
+	}
+	for _, key := range orphaned {
+		f.report.OrphanedUploads++
+		if err := f.deleteUpload(key); err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// blobs sets the references of every blob to those counted, removing blobs left without references.
+func (f *fsck) blobs(blobs []Blob, refs map[string]*Blob) error {
+	for _, blob := range blobs {
+		counted := 0
+		if ref, found := refs[blob.Hash]; found {
+			counted = ref.Refs
+			delete(refs, blob.Hash)
+		}
+		if blob.Refs == counted {
+			continue
+		}
+		f.report.BlobRefs++
+		blob.Refs = counted
+		if err := f.setBlob(blob); err != nil {
+			return err
+		}
+		if counted == 0 {
+			if err := f.deleteFile(blob.Key); err != nil {
+				return err
+			}
+		}
+	}
+	// Uploads may refer to blobs that were removed before their references were counted.
+	for _, blob := range refs {
+		f.report.BlobRefs++
+		if err := f.setBlob(*blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsck) placeholders() error {
+	keys, err := f.meta.Placeholders()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		f.report.Placeholders++
+		if err := f.deleteUpload(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// files removes the stored blobs, thumbnails and chunks that nothing refers to. Other files are left alone, as uploads
+// stored before blobs were introduced are stored under keys that are not distinguishable from unrelated files.
+func (f *fsck) files() error {
+	for key := range f.stored {
+		orphaned, err := f.orphaned(key)
+		if err != nil {
+			return err
+		}
+		if !orphaned {
+			continue
+		}
+		f.report.OrphanedFiles++
+		if err := f.deleteFile(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fsck) orphaned(key string) (bool, error) {
+	switch {
+	case strings.HasPrefix(key, blobKeyPrefix):
+		return !f.live[key], nil
+	case strings.HasPrefix(key, thumbKeyPrefix):
+		storageKey := strings.TrimPrefix(key, thumbKeyPrefix)
+		if i := strings.LastIndex(storageKey, "-"); i >= 0 {
+			return !f.live[storageKey[:i]], nil
+		}
+	case strings.HasPrefix(key, partialKeyPrefix):
+		chunk := strings.TrimPrefix(key, partialKeyPrefix)
+		i := strings.LastIndex(chunk, "-")
+		if i < 0 {
+			return false, nil
+		}
+		index, err := strconv.Atoi(chunk[i+1:])
+		if err != nil {
+			return false, nil
+		}
+		upload, err := f.meta.PartialGet(chunk[:i])
+		if errors.Is(err, ErrNotFound) {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		return index >= len(upload.Chunks), nil
+	}
+	return false, nil
+}
+
+// dataKeys removes the data keys of encrypted files that are not stored. It runs once the uploads have been checked,
+// which fail if the FileStore looks misconfigured, as removed data keys can not be recovered. Files are looked for in
+// the underlying store, as opening them through the EncryptedFileStore needs their master key.
+func (f *fsck) dataKeys(store *EncryptedFileStore) error {
+	keys, err := store.meta.DataKeys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if f.removed[key] {
+			continue
+		}
+		exists, err := f.existsIn(store.store, key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		f.report.DataKeys++
+		if f.dryRun {
+			continue
+		}
+		if err := store.meta.DataKeyDelete(key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// exists reports whether the file is stored, opening it if the store can not list its files.
+func (f *fsck) exists(key string) (bool, error) {
+	return f.existsIn(f.store, key)
+}
+
+func (f *fsck) existsIn(store FileStore, key string) (bool, error) {
+	if f.stored != nil {
+		return f.stored[key], nil
+	}
+	file, err := store.Get(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, file.Close()
+}
+
+func (f *fsck) deleteFile(key string) error {
+	if f.stored != nil {
+		delete(f.stored, key)
+	}
+	f.removed[key] = true
+	if f.dryRun {
+		return nil
+	}
+	if err := f.store.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (f *fsck) deleteUpload(key string) error {
+	if f.dryRun {
+		return nil
+	}
+	if err := f.meta.FileDelete(key); err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	return nil
+}
+
+func (f *fsck) setBlob(blob Blob) error {
+	if f.dryRun {
+		return nil
+	}
+	return f.meta.BlobSet(blob)
+}
@@ -0,0 +1,203 @@
+package uploader
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFsck(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	store := newMemoryFileStore()
+	uploaded := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	put := func(key, blob, hash string, state UploadState) {
+		err := meta.FilePut(UploadDetails{Key: key, User: "test_user", Size: 13, Hash: hash, Blob: blob, State: state, Uploaded: uploaded})
+		if err != nil {
+			t.Fatalf("failed to put upload %s: %s", key, err)
+		}
+	}
+	store.files["legacy"] = []byte("legacy")
+	store.files["thumb-legacy-64"] = []byte("thumb")
+	put("legacy", "", "", UploadCommitted)
+	store.files["blob-x"] = []byte("Hello, World!")
+	meta.BlobSet(Blob{Hash: "x", Key: "blob-x", Refs: 3})
+	put("committed", "blob-x", "x", UploadCommitted)
+	store.files["blob-y"] = []byte("interrupted")
+	put("pending", "blob-y", "y", UploadPending)
+	store.files["deleting"] = []byte("deleting")
+	put("deleting", "", "", UploadDeleting)
+	store.files["thumb-lost-64"] = []byte("thumb")
+	put("lost", "", "", UploadCommitted)
+	placeholder, _ := meta.FileKey()
+	store.files["blob-w"] = []byte("unreferenced")
+	meta.BlobSet(Blob{Hash: "w", Key: "blob-w", Refs: 1})
+	store.files["thumb-blob-w-64"] = []byte("thumb")
+	meta.PartialPut(PartialUpload{ID: "p", Chunks: []int64{5}})
+	store.files[".partial-p-0"] = []byte("chunk")
+	store.files[".partial-p-1"] = []byte("chunk")
+	store.files[".partial-gone-0"] = []byte("chunk")
+	store.files["blob-z"] = []byte("orphan")
+	store.files["thumb-gone-64"] = []byte("thumb")
+	store.files["unrelated"] = []byte("unrelated")
+
+	want := &FsckReport{
+		PendingUploads:  1,
+		DeletingUploads: 1,
+		OrphanedUploads: 1,
+		Placeholders:    1,
+		BlobRefs:        2,
+		OrphanedFiles:   6,
+	}
+	before := len(store.files)
+	report, err := Fsck(meta, store, true)
+	if err != nil {
+		t.Fatalf("unexpected error on dry run: %s", err)
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected dry run report %s", diff)
+	}
+	if files, _ := meta.AllFiles(); len(files) != 5 || len(store.files) != before {
+		t.Fatalf("expected dry run to leave the stores alone, got %d uploads and %d files", len(files), len(store.files))
+	}
+
+	report, err = Fsck(meta, store, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if diff := cmp.Diff(want, report); diff != "" {
+		t.Errorf("unexpected report %s", diff)
+	}
+	keys, _ := store.Keys("")
+	sort.Strings(keys)
+	if diff := cmp.Diff([]string{".partial-p-0", "blob-x", "legacy", "thumb-legacy-64", "unrelated"}, keys); diff != "" {
+		t.Errorf("unexpected stored files %s", diff)
+	}
+	files, _ := meta.AllFiles()
+	var uploads []string
+	for _, file := range files {
+		uploads = append(uploads, file.Key)
+	}
+	sort.Strings(uploads)
+	if diff := cmp.Diff([]string{"committed", "legacy"}, uploads); diff != "" {
+		t.Errorf("unexpected uploads %s", diff)
+	}
+	if _, err := meta.FileGet(placeholder); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected placeholder to be released, got %v", err)
+	}
+	blobs, _ := meta.Blobs()
+	if diff := cmp.Diff([]Blob{{Hash: "x", Key: "blob-x", Refs: 1}}, blobs); diff != "" {
+		t.Errorf("unexpected blobs %s", diff)
+	}
+
+	if report, err := Fsck(meta, store, false); err != nil || report.Problems() != 0 {
+		t.Errorf("expected repaired stores to have no problems, got %v %v", report, err)
+	}
+}
+
+func TestFsck_MissingBlob(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	store := newMemoryFileStore()
+	store.files["blob-x"] = []byte("Hello, World!")
+	for _, key := range []string{"a", "b"} {
+		meta.FilePut(UploadDetails{Key: key, User: "test_user", Hash: "x", Blob: "blob-x", Uploaded: time.Now()})
+	}
+
+	report, err := Fsck(meta, store, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report.BlobRefs != 1 {
+		t.Errorf("expected missing blob to be counted, got %+v", report)
+	}
+	if blobs, _ := meta.Blobs(); len(blobs) != 1 || blobs[0].Refs != 2 {
+		t.Errorf("expected blob to be recreated with 2 references, got %+v", blobs)
+	}
+}
+
+// unlistedFileStore hides the Keys method of the store it wraps.
+type unlistedFileStore struct {
+	FileStore
+}
+
+func TestFsck_NoStoredFiles(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	store := newMemoryFileStore()
+	for _, key := range []string{"a", "b"} {
+		meta.FilePut(UploadDetails{Key: key, User: "test_user", Uploaded: time.Now()})
+	}
+
+	if _, err := Fsck(meta, unlistedFileStore{store}, false); !errors.Is(err, errNoStoredFiles) {
+		t.Errorf("expected every upload missing to be refused, got %v", err)
+	}
+	if files, _ := meta.AllFiles(); len(files) != 2 {
+		t.Errorf("expected uploads to be kept, got %d", len(files))
+	}
+
+	store.files["a"] = []byte("a")
+	store.files["blob-orphan"] = []byte("orphan")
+	report, err := Fsck(meta, unlistedFileStore{store}, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report.OrphanedUploads != 1 || report.OrphanedFiles != 0 {
+		t.Errorf("expected only the missing upload to be found without listing, got %+v", report)
+	}
+	if _, err := meta.FileGet("b"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected orphaned upload to be removed, got %v", err)
+	}
+}
+
+func TestFsck_DataKeys(t *testing.T) {
+	store, files, meta := newTestEncryptedStore(t, testMasterKey(t))
+	store.Put("kept", strings.NewReader("kept"))
+	store.Put("lost", strings.NewReader("lost"))
+	delete(files.files, "lost")
+
+	for _, dryRun := range []bool{true, false} {
+		report, err := Fsck(meta, store, dryRun)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if diff := cmp.Diff(&FsckReport{DataKeys: 1}, report); diff != "" {
+			t.Errorf("unexpected report %s", diff)
+		}
+	}
+	if keys, _ := meta.DataKeys(); len(keys) != 1 || keys[0] != "kept" {
+		t.Errorf("expected only the data key of the stored file to be kept, got %v", keys)
+	}
+}
+
+func TestFsck_Upload(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	store := newMemoryFileStore()
+	service := NewUploadService(meta, store)
+	file, _ := os.Open("./test/data/test_file.txt")
+	defer file.Close()
+	details, err := service.Upload(file, "test_file.txt", 13, "test_user", UploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error uploading: %s", err)
+	}
+	if _, err := service.Shorten("https://example.com", "test_user", UploadOptions{}); err != nil {
+		t.Fatalf("unexpected error shortening: %s", err)
+	}
+
+	report, err := Fsck(meta, store, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if report.Problems() != 0 {
+		t.Errorf("expected uploads and links to have no problems, got %+v", report)
+	}
+	if _, err := meta.FileGet(details.Key); err != nil {
+		t.Errorf("expected upload to be kept, got %v", err)
+	}
+}
@@ -1,16 +1,20 @@
 package uploader
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"uploader/internal/auth"
 	"uploader/internal/responses"
+	"uploader/internal/s3"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -21,46 +25,259 @@ type Uploader struct {
 	baseURL *url.URL
 	Auth    auth.Store
 	us      UploadService
+	tus     *tusHandler
+	// stop is closed to end background tasks when the uploader is closed.
+	stop chan struct{}
+	// signer is set when the file store can redirect downloads to signed URLs.
+	signer URLSigner
+	// login is set when browser login and the web interface are enabled.
+	login *loginHandler
+	// previews is set when preview pages are enabled.
+	previews *previews
+	// audit records uploads, deletes and authentication failures, and counts downloads.
+	audit AuditMeta
+	// metrics is set when metrics are enabled, and metricsHandler serves them with the configured auth.
+	metrics        *Metrics
+	metricsHandler http.Handler
+	// metricsRoute is set when metrics are served at metricsPath rather than their own address.
+	metricsRoute http.Handler
 }
 
 const fileFieldName = "file"
 
+// multipartMemory is the amount of a multipart upload held in memory, the remainder is written to temporary files.
+const multipartMemory = 8 << 20
+
+// multipartOverhead allows for the size of the multipart encoding and other form fields when limiting upload size.
+const multipartOverhead = 1 << 20
+
+// pathUser returns the user named in the request path. Routes using it must be guarded by auth.RequireUser.
+func pathUser(r *http.Request) string {
+	return chi.URLParam(r, "user")
+}
+
+// deleteError writes the response for an error returned when deleting an upload.
+func deleteError(w http.ResponseWriter, response responses.ErrorHolder, err error) {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		responses.Error(w, response, http.StatusNotFound, -1004, "file not found")
+	case errors.Is(err, ErrForbidden):
+		responses.Error(w, response, http.StatusForbidden, auth.CodeForbidden, "not permitted to delete this file")
+	default:
+		responses.Error(w, response, 500, -5000, "unknown error")
+	}
+}
+
+const expiresInField = "expires_in"
+const expiresInHeader = "X-Expires-In"
+
+// uploadOptions reads the optional upload settings from the form, falling back to request headers.
+func uploadOptions(r *http.Request) (UploadOptions, error) {
+	opts := UploadOptions{}
+	expiresIn := r.FormValue(expiresInField)
+	if expiresIn == "" {
+		expiresIn = r.Header.Get(expiresInHeader)
+	}
+	var err error
+	if opts.ExpiresIn, err = ParseExpiresIn(expiresIn); err != nil {
+		return opts, fmt.Errorf("invalid %s", expiresInField)
+	}
+	encrypted := r.FormValue(encryptedField)
+	if encrypted == "" {
+		encrypted = r.Header.Get(encryptedHeader)
+	}
+	if encrypted != "" {
+		if opts.Encrypted, err = strconv.ParseBool(encrypted); err != nil {
+			return opts, fmt.Errorf("invalid %s", encryptedField)
+		}
+	}
+	if opts.Password = r.FormValue(passwordField); opts.Password == "" {
+		opts.Password = r.Header.Get(passwordHeader)
+	}
+	maxDownloads := r.FormValue(maxDownloadsField)
+	if maxDownloads == "" {
+		maxDownloads = r.Header.Get(maxDownloadsHeader)
+	}
+	if maxDownloads != "" {
+		if opts.MaxDownloads, err = strconv.ParseInt(maxDownloads, 10, 64); err != nil || opts.MaxDownloads < 1 {
+			return opts, fmt.Errorf("invalid %s", maxDownloadsField)
+		}
+	}
+	return opts, nil
+}
+
+// uploadError writes the response for an error returned when creating an upload.
+func uploadError(w http.ResponseWriter, response responses.ErrorHolder, err error) {
+	switch {
+	case errors.Is(err, ErrExpiryTooLong), errors.Is(err, ErrInvalidExpiry):
+		responses.Error(w, response, http.StatusBadRequest, -1005, err.Error())
+	case errors.Is(err, ErrTooLarge):
+		responses.Error(w, response, http.StatusRequestEntityTooLarge, -1007, err.Error())
+	case errors.Is(err, ErrQuotaExceeded):
+		responses.Error(w, response, http.StatusForbidden, -1008, err.Error())
+	default:
+		responses.ErrorFromError(w, response, err)
+	}
+}
+
 func (u *Uploader) uploadHandler(w http.ResponseWriter, r *http.Request) {
-	user := auth.AuthUser(r.Context())
 	response := &UploadResponse{}
+	if max := u.us.MaxUploadSize(); max > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, max+multipartOverhead)
+	}
+	err := r.ParseMultipartForm(multipartMemory)
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		uploadError(w, response, ErrTooLarge)
+		return
+	}
 	file, fileHeader, err := r.FormFile(fileFieldName)
 	if err != nil {
 		responses.Error(w, response, http.StatusBadRequest, -1001, "file not found in request")
 		return
 	}
-	uploadDetails, err := u.us.Upload(file, fileHeader.Filename, fileHeader.Size, user.Name)
+	opts, err := uploadOptions(r)
 	if err != nil {
-		responses.ErrorFromError(w, response, err)
+		responses.Error(w, response, http.StatusBadRequest, -1005, err.Error())
+		return
 	}
+	uploadDetails, err := u.us.Upload(file, fileHeader.Filename, fileHeader.Size, pathUser(r), opts)
+	if err != nil {
+		uploadError(w, response, err)
+		return
+	}
+	u.uploaded(r, uploadDetails)
 	uploadDetails.BuildUrl(u.baseURL)
 	response.FromDetails(uploadDetails)
+	u.linkViews(response, uploadDetails)
 	responses.Json(w, response, http.StatusAccepted)
 }
 
+// linkViews adds the links to the enabled views of an upload to the response.
+func (u *Uploader) linkViews(response *UploadResponse, details *UploadDetails) {
+	if u.previews != nil {
+		response.Results.PreviewURL = previewURL(u.baseURL, details.Key)
+	}
+	if pasteable(details.ContentType) && !details.Encrypted {
+		response.Results.PasteURL = u.baseURL.JoinPath(pastePath, details.Key).String()
+	}
+}
+
+func (u *Uploader) uploadList(w http.ResponseWriter, r *http.Request) {
+	response := &UploadListResponse{}
+	query := r.URL.Query()
+	opts := ListOptions{
+		Sort:        query.Get("sort"),
+		Ascending:   query.Get("order") == "asc",
+		Cursor:      query.Get("cursor"),
+		ContentType: query.Get("type"),
+		Filename:    query.Get("name"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		var err error
+		if opts.Limit, err = strconv.Atoi(limit); err != nil {
+			responses.Error(w, response, http.StatusBadRequest, -1002, "invalid limit")
+			return
+		}
+	}
+	list, err := u.us.List(pathUser(r), opts)
+	if errors.Is(err, ErrInvalidCursor) || errors.Is(err, ErrInvalidSort) {
+		responses.Error(w, response, http.StatusBadRequest, -1003, err.Error())
+		return
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	response.FromList(list, u.baseURL)
+	responses.Json(w, response, http.StatusOK)
+}
+
+func (u *Uploader) uploadQuota(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	status, err := u.us.Quota(pathUser(r))
+	if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	response.Ok = true
+	response.Results = status
+	responses.Json(w, response, http.StatusOK)
+}
+
 func (u *Uploader) fileGet(w http.ResponseWriter, r *http.Request) {
 	response := &responses.BaseResponse{}
 	key := chi.URLParam(r, "key")
 	name := chi.URLParam(r, "name")
 
-	details, reader, err := u.us.Get(key)
-	if errors.Is(err, os.ErrNotExist) {
+	if name == "" && u.encryptedView(w, key) {
+		return
+	}
+	if u.signer != nil && u.redirectFile(w, r, key, name) {
+		return
+	}
+
+	details, reader, err := u.us.Get(key, requestPassword(r))
+	if u.passwordError(w, r, err) {
+		return
+	} else if errors.Is(err, os.ErrNotExist) {
 		responses.Error(w, response, 404, -1004, "file not found")
 		return
+	} else if errors.Is(err, ErrExpired) {
+		responses.Error(w, response, http.StatusGone, -1006, "file has expired")
+		return
 	} else if err != nil {
 		responses.ErrorFromError(w, response, err)
 		return
 	}
 	defer reader.Close()
+	u.countDownload(details.Key)
 
-	if name != "" && details.Filename != "" {
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", details.Filename))
+	if details.DownloadsLeft != nil {
+		r = fullDownload(r)
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	untrustedContent(w)
+	if disposition := contentDisposition(details, name); disposition != "" {
+		w.Header().Set("Content-Disposition", disposition)
 	}
 	w.Header().Set("Content-Type", details.ContentType)
+	if etag := details.ETag(); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	w = u.metrics.servedWriter(w)
+	// Seekable readers get full range and conditional request handling from the standard library.
+	if seeker, ok := reader.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, details.Filename, details.Uploaded, seeker)
+		return
+	}
+
+	if notModified(r, details) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if !details.Uploaded.IsZero() {
+		w.Header().Set("Last-Modified", details.Uploaded.UTC().Format(http.TimeFormat))
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	start, length, err := requestRange(r, details)
+	if errors.Is(err, errUnsatisfiableRange) {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", details.Size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return
+	} else if err == nil {
+		// Without seeking, the bytes before the range are read and thrown away.
+		if _, err := io.CopyN(io.Discard, reader, start); err != nil {
+			responses.ErrorFromError(w, response, err)
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, details.Size))
+		w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		io.CopyN(w, reader, length)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(details.Size, 10))
 
 	// Send file contents
 	if _, err := io.Copy(w, reader); err != nil {
@@ -70,15 +287,189 @@ func (u *Uploader) fileGet(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (u *Uploader) thumbnailGet(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	size, err := strconv.Atoi(chi.URLParam(r, "size"))
+	if err != nil {
+		responses.Error(w, response, 404, -1009, "thumbnail not found")
+		return
+	}
+	thumb, contentType, err := u.us.Thumbnail(chi.URLParam(r, "key"), size)
+	if errors.Is(err, os.ErrNotExist) {
+		responses.Error(w, response, 404, -1004, "file not found")
+		return
+	} else if errors.Is(err, ErrNoThumbnail) {
+		responses.Error(w, response, 404, -1009, "thumbnail not found")
+		return
+	} else if errors.Is(err, ErrExpired) {
+		responses.Error(w, response, http.StatusGone, -1006, "file has expired")
+		return
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	defer thumb.Close()
+	untrustedContent(w)
+	w.Header().Set("Content-Type", contentType)
+	// Thumbnails of an upload never change, as uploads are immutable.
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	io.Copy(w, thumb)
+}
+
+// untrustedContent marks the response as holding uploaded content, which is served on the same origin as the web
+// interface. Browsers are kept from sniffing a type other than the one sent, and from running any script it holds.
+func untrustedContent(w http.ResponseWriter) {
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+}
+
+// contentDisposition returns the Content-Disposition of an upload, naming the file when it is requested by name.
+// Documents browsers would render with their own scripting are always downloaded rather than displayed.
+func contentDisposition(details *UploadDetails, name string) string {
+	if name != "" && details.Filename != "" {
+		return fmt.Sprintf("attachment; filename=\"%s\"", details.Filename)
+	}
+	if activeContent(details.ContentType) {
+		return "attachment"
+	}
+	return ""
+}
+
+// activeContent reports whether content of the type is a document that may run script, such as HTML and SVG.
+func activeContent(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	return mediaType == "text/html" || mediaType == "text/xml" || mediaType == "application/xml" ||
+		strings.HasSuffix(mediaType, "+xml")
+}
+
+// redirectFile redirects the client to a signed URL for the file, returning true if a response has been written.
+// If the store has signing disabled false is returned, and the file should be streamed instead.
+func (u *Uploader) redirectFile(w http.ResponseWriter, r *http.Request, key, name string) bool {
+	response := &responses.BaseResponse{}
+	details, err := u.us.Info(key)
+	if errors.Is(err, os.ErrNotExist) {
+		responses.Error(w, response, 404, -1004, "file not found")
+		return true
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return true
+	}
+	if details.Expired(time.Now()) {
+		responses.Error(w, response, http.StatusGone, -1006, "file has expired")
+		return true
+	}
+	if details.Encrypted {
+		// The viewer fetches encrypted uploads itself, which a redirect to another origin would prevent.
+		return false
+	}
+	if details.Protected() {
+		// Signed URLs would bypass the password and download limit.
+		return false
+	}
+	signed, err := u.signer.SignURL(details.StorageKey(), details.ContentType, contentDisposition(details, name))
+	if errors.Is(err, ErrSignedURLUnsupported) {
+		return false
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return true
+	}
+	u.countDownload(details.Key)
+	http.Redirect(w, r, signed, http.StatusFound)
+	return true
+}
+
+// notModified evaluates the conditional request headers for readers that cannot be handed to http.ServeContent.
+// If-None-Match takes precedence over If-Modified-Since, as described in RFC 9110.
+func notModified(r *http.Request, details *UploadDetails) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		etag := details.ETag()
+		if etag == "" {
+			return false
+		}
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || details.Uploaded.IsZero() {
+		return false
+	}
+	since, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	return !details.Uploaded.Truncate(time.Second).After(since)
+}
+
+var (
+	errUnsatisfiableRange = errors.New("range not satisfiable")
+	errNoRange            = errors.New("no range requested")
+)
+
+// requestRange returns the offset and length of a single byte range requested of the upload, for readers that cannot
+// be handed to http.ServeContent. errNoRange is returned when the file should be served in full, which includes
+// invalid and multiple ranges, and ranges made stale by If-Range.
+func requestRange(r *http.Request, details *UploadDetails) (int64, int64, error) {
+	spec, found := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, errNoRange
+	}
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != details.ETag() &&
+		(details.Uploaded.IsZero() || ifRange != details.Uploaded.UTC().Format(http.TimeFormat)) {
+		return 0, 0, errNoRange
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, errNoRange
+	}
+	size := details.Size
+	if first == "" {
+		// A suffix range requests the last bytes of the file.
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, 0, errNoRange
+		} else if suffix == 0 || size == 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errNoRange
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, errNoRange
+		}
+	}
+	if start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	if end >= size {
+		end = size - 1
+	}
+	return start, end - start + 1, nil
+}
+
 func (u *Uploader) uploadDeletePublic(w http.ResponseWriter, r *http.Request) {
 	response := &responses.BaseResponse{}
 	key := chi.URLParam(r, "key")
 	deleteKey := chi.URLParam(r, "secret")
 	err := u.us.DeletePublic(key, deleteKey)
 	if err != nil {
-		responses.Error(w, response, 500, -5000, "unknown error")
+		deleteError(w, response, err)
 		return
 	}
+	u.record(r, AuditDeletePublic, key, "")
 	response.Results = true
 	responses.Json(w, response, 200)
 }
@@ -86,26 +477,92 @@ func (u *Uploader) uploadDeletePublic(w http.ResponseWriter, r *http.Request) {
 func (u *Uploader) uploadDelete(w http.ResponseWriter, r *http.Request) {
 	response := &responses.BaseResponse{}
 	key := chi.URLParam(r, "key")
-	err := u.us.Delete(key)
+	err := u.us.Delete(key, auth.AuthUser(r.Context()))
 	if err != nil {
-		responses.Error(w, response, 500, -5000, "unknown error")
+		deleteError(w, response, err)
 		return
 	}
+	u.record(r, AuditDelete, key, "")
 	response.Results = true
 	responses.Json(w, response, 200)
 }
 
 func NewUploaderHTTP(base *url.URL, meta MetaStore, store FileStore) *Uploader {
+	return newUploaderHTTP(base, meta, store, NewUploadService(meta, store), newTokenLogin(base, meta))
+}
 
-	u := &Uploader{baseURL: base, us: NewUploadService(meta, store), Auth: meta}
+// newUploaderHTTP creates the uploader routes, including browser login and the web interface unless login is nil.
+func newUploaderHTTP(base *url.URL, meta MetaStore, store FileStore, us UploadService, login *loginHandler) *Uploader {
+	u := &Uploader{baseURL: base, us: us, Auth: meta, login: login, audit: meta}
+	u.tus = newTusHandler(meta, store, u.us, base)
+	u.tus.uploaded = u.uploaded
+	if signer, ok := store.(URLSigner); ok {
+		u.signer = signer
+	}
 	router := chi.NewRouter()
 	router.Use(middleware.Logger)
+	router.Use(u.observeRequests)
 
 	router.Get("/files/{key}", u.fileGet)
 	router.Get("/files/{key}/{name}", u.fileGet)
+	// Protected uploads are also fetched by submitting their password prompt.
+	router.Post("/files/{key}", u.fileGet)
+	router.Post("/files/{key}/{name}", u.fileGet)
+	router.Get("/files/{key}/thumb/{size}", u.thumbnailGet)
+	router.Get(previewPath+"{key}", u.previewGet)
+	router.Get(pastePath+"{key}", u.pasteView)
+	router.Get(pastePath+"{key}/raw", u.pasteRaw)
+	router.Post(pastePath+"{key}", u.pasteView)
+	router.Post(pastePath+"{key}/raw", u.pasteRaw)
+	router.Get(linkPath+"{key}", u.linkFollow)
+	router.Get(metricsPath, u.metricsGet)
+
+	// Browser sessions are accepted wherever bearer tokens are once login is enabled.
+	var sessions *auth.Sessions
+	if login != nil {
+		sessions = login.sessions
+		if login.provider != nil {
+			router.Get("/auth/login", login.login)
+			router.Get(loginCallbackPath, login.callback)
+		}
+		login.failed = u.authFailed
+		router.Post("/auth/token", login.tokenLogin)
+		router.Get("/auth/logout", login.logout)
+		router.Post("/auth/logout", login.logout)
+	}
+	authenticate := auth.AuthenticateReporting(meta, sessions, u.authFailed)
+
+	if login != nil {
+		router.Get("/", u.webIndex)
+		router.Handle("/static/*", webStatic())
+		router.With(authenticate, auth.RequireScope(auth.ScopeUpload)).Post("/sharex", u.webShareX)
+	}
 
-	router.With(auth.BearerAuth(meta)).Post("/uploads/{user}", u.uploadHandler)
-	router.With(auth.BearerAuth(meta)).Delete("/uploads/{user}/{key}", u.uploadDelete)
+	// Authenticated routes act on the user named in the path, which must be the caller unless they are an admin.
+	// Each route also requires its token to have the scope of the operation.
+	userAuth := router.With(authenticate, auth.RequireUser(pathUser))
+	userAuth.With(auth.RequireScope(auth.ScopeRead)).Get("/uploads/{user}", u.uploadList)
+	userAuth.With(auth.RequireScope(auth.ScopeUpload)).Post("/uploads/{user}", u.uploadHandler)
+	userAuth.With(auth.RequireScope(auth.ScopeUpload)).Post("/uploads/{user}/paste", u.pasteHandler)
+	userAuth.With(auth.RequireScope(auth.ScopeRead)).Get("/uploads/{user}/quota", u.uploadQuota)
+	userAuth.With(auth.RequireScope(auth.ScopeRead)).Get("/uploads/{user}/links", u.linkList)
+	userAuth.With(auth.RequireScope(auth.ScopeUpload)).Post("/uploads/{user}/links", u.linkCreate)
+	userAuth.With(auth.RequireScope(auth.ScopeDelete)).Delete("/uploads/{user}/links/{key}", u.linkDelete)
+	userAuth.With(auth.RequireScope(auth.ScopeDelete)).Delete("/uploads/{user}/{key}", u.uploadDelete)
+	router.Route("/uploads/{user}/tus", func(r chi.Router) {
+		r.Use(u.tus.requireResumable)
+		r.Options("/", u.tus.options)
+		r.With(authenticate, auth.RequireUser(pathUser), auth.RequireScope(auth.ScopeUpload)).Group(func(r chi.Router) {
+			r.Post("/", u.tus.create)
+			r.Head("/{id}", u.tus.head)
+			r.Patch("/{id}", u.tus.patch)
+			r.Delete("/{id}", u.tus.terminate)
+		})
+	})
+	// Admin routes report on the activity of every user.
+	adminAuth := router.With(authenticate, auth.RequireAdmin, auth.RequireScope(auth.ScopeRead))
+	adminAuth.Get("/admin/audit", u.auditList)
+	adminAuth.Get("/admin/downloads", u.downloadList)
 	// The below route is required for ShareX, as it does not make explicit DELETE requests.
 	router.Get("/uploads/{user}/{key}/delete/{secret}", u.uploadDeletePublic)
 
@@ -114,73 +571,151 @@ func NewUploaderHTTP(base *url.URL, meta MetaStore, store FileStore) *Uploader {
 	return u
 }
 
-func NewUploaderFromConfig(cfg *Config) (*Uploader, error) {
+// OpenStores opens the metadata and file stores described by the config.
+func OpenStores(cfg *Config) (MetaStore, FileStore, error) {
 	var store FileStore
 	var meta MetaStore
 	var err error
-	if cfg.BaseURL == "" {
-		return nil, errors.New("must have a base public url specified")
-	}
-	if cfg.BoltConfig != nil {
-		bc := cfg.BoltConfig
-		meta, err = NewBoltStore(bc.Path)
-		if err != nil {
-			return nil, err
-		}
+	if cfg.DirConfig != nil && cfg.S3Config != nil {
+		return nil, nil, errors.New("only one of the dir and s3 file stores may be configured")
 	}
 	if cfg.DirConfig != nil {
 		dc := cfg.DirConfig
 		store = NewDirectoryFileStore(dc.Path)
 	}
-	if store == nil || meta == nil {
-		return nil, errors.New("must have a file store and meta storage configured")
+	if cfg.S3Config != nil {
+		sc := cfg.S3Config
+		var timeout time.Duration
+		if sc.Timeout != "" {
+			if timeout, err = time.ParseDuration(sc.Timeout); err != nil {
+				return nil, nil, fmt.Errorf("invalid s3 timeout: %w", err)
+			}
+		}
+		s3Store, err := NewS3FileStore(s3.Config{
+			Endpoint:  sc.Endpoint,
+			Bucket:    sc.Bucket,
+			Region:    sc.Region,
+			AccessKey: sc.AccessKey,
+			SecretKey: sc.SecretKey,
+			PathStyle: sc.PathStyle,
+			Timeout:   timeout,
+		}, sc.PartSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		if sc.PresignExpiry != "" {
+			if s3Store.PresignExpiry, err = time.ParseDuration(sc.PresignExpiry); err != nil {
+				return nil, nil, fmt.Errorf("invalid s3 presign expiry: %w", err)
+			}
+		}
+		store = s3Store
 	}
-	base, err := url.Parse(cfg.BaseURL)
+	if store == nil || cfg.BoltConfig == nil {
+		return nil, nil, errors.New("must have a file store and meta storage configured")
+	}
+	if cfg.EncryptionConfig != nil && cfg.S3Config != nil && cfg.S3Config.PresignExpiry != "" {
+		return nil, nil, errors.New("s3 presigned urls can not be used with encryption")
+	}
+	bc := cfg.BoltConfig
+	bolt, err := NewBoltStore(bc.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	meta = bolt
+	if cfg.EncryptionConfig != nil {
+		keys, err := cfg.EncryptionConfig.keyring()
+		if err != nil {
+			bolt.Close()
+			return nil, nil, err
+		}
+		store = NewEncryptedFileStore(store, bolt, keys)
+	}
+	return meta, store, nil
+}
+
+func NewUploaderFromConfig(cfg *Config) (_ *Uploader, err error) {
+	if cfg.BaseURL == "" {
+		return nil, errors.New("must have a base public url specified")
+	}
+	meta, store, err := OpenStores(cfg)
 	if err != nil {
 		return nil, err
 	}
-	return NewUploaderHTTP(base, meta, store), nil
-}
-
-type UploadScript struct {
-	Version         string `json:"Version"`
-	Name            string `json:"Name"`
-	DestinationType string `json:"DestinationType"`
-	RequestMethod   string `json:"RequestMethod"`
-	RequestURL      string `json:"RequestURL"`
-	Headers         struct {
-		Authorization string `json:"Authorization"`
-	} `json:"Headers"`
-	Body         string `json:"Body"`
-	FileFormName string `json:"FileFormName"`
-	URL          string `json:"URL"`
-	DeletionURL  string `json:"DeletionURL"`
-}
-
-func (u *Uploader) UploadScript(user *auth.User) string {
-	url := u.baseURL.JoinPath("/uploads/", user.Name)
-	script, err := json.Marshal(UploadScript{
-		Version:         "13.2.1",
-		Name:            "Uploader",
-		DestinationType: "ImageUploader, TextUploader, FileUploader",
-		RequestMethod:   "POST",
-		RequestURL:      url.String(),
-		Headers: struct {
-			Authorization string `json:"Authorization"`
-		}{
-			fmt.Sprintf("Bearer %s", user.AuthToken),
-		},
-		Body:         "MultipartFormData",
-		FileFormName: "file",
-		URL:          "$json:results.url$",
-		DeletionURL:  "$json:results.delete_url$",
-	})
+	// The stores are closed if any later step fails, releasing the lock on the metadata database.
+	defer func() {
+		if err != nil {
+			store.Close()
+			meta.Close()
+		}
+	}()
+	base, err := url.Parse(cfg.BaseURL)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+	if fm, ok := meta.(FsckMeta); ok && !cfg.SkipFsck {
+		report, err := Fsck(fm, store, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check stores: %w", err)
+		}
+		if report.Problems() > 0 {
+			log.Printf("repaired stores: %s", report)
+		}
+	}
+	var m *Metrics
+	var metricsHandler http.Handler
+	if cfg.MetricsConfig != nil {
+		m = NewMetrics(meta)
+		if metricsHandler, err = cfg.MetricsConfig.handler(m); err != nil {
+			return nil, err
+		}
+		meta, store = &instrumentedMeta{MetaStore: meta, metrics: m}, instrumentFileStore(store, m)
+	}
+	us := NewUploadService(meta, store)
+	reapInterval, partialTTL := defaultReapInterval, defaultPartialTTL
+	if cfg.ExpiryConfig != nil {
+		if us.expiry, err = cfg.ExpiryConfig.policy(); err != nil {
+			return nil, err
+		}
+		if cfg.ExpiryConfig.ReapInterval != "" {
+			if reapInterval, err = time.ParseDuration(cfg.ExpiryConfig.ReapInterval); err != nil {
+				return nil, fmt.Errorf("invalid expiry reap interval: %w", err)
+			}
+		}
+		if cfg.ExpiryConfig.PartialTTL != "" {
+			if partialTTL, err = time.ParseDuration(cfg.ExpiryConfig.PartialTTL); err != nil {
+				return nil, fmt.Errorf("invalid partial upload ttl: %w", err)
+			}
+		}
+	}
+	us.quota = cfg.QuotaConfig.policy(cfg.MaxUploadSize)
+	if us.thumbnails, err = cfg.ThumbnailConfig.sizes(); err != nil {
+		return nil, err
+	}
+	login := newTokenLogin(base, meta)
+	if cfg.OIDCConfig != nil {
+		if login, err = cfg.OIDCConfig.loginHandler(base, meta); err != nil {
+			return nil, err
+		}
+	}
+	u := newUploaderHTTP(base, meta, store, us, login)
+	u.metrics, u.metricsHandler = m, metricsHandler
+	if cfg.MetricsAddress() == "" {
+		u.metricsRoute = metricsHandler
+	}
+	if cfg.PreviewConfig != nil {
+		if u.previews, err = cfg.PreviewConfig.previews(); err != nil {
+			return nil, err
+		}
 	}
-	return string(script)
+	u.tus.ttl = partialTTL
+	u.stop = make(chan struct{})
+	go reaper(us, u.tus, reapInterval, u.stop)
+	return u, nil
 }
 
 func (u *Uploader) Close() {
+	if u.stop != nil {
+		close(u.stop)
+	}
 	u.us.Close()
 }
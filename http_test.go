@@ -11,13 +11,16 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"uploader/internal/auth"
 	"uploader/internal/responses"
 
 	"github.com/google/go-cmp/cmp"
+	"go.etcd.io/bbolt"
 )
 
 var baseURL = &url.URL{
@@ -135,6 +138,220 @@ func TestFileGetHTTP(t *testing.T) {
 	}
 }
 
+func TestFileGetRangeHTTP(t *testing.T) {
+	fileKey := "1"
+	contents := "Hello, World!"
+
+	for _, noSeek := range []bool{false, true} {
+		meta := newTestMeta()
+		meta.addFile(fileKey, "text/plain")
+		store := newMemoryFileStore()
+		store.noSeek = noSeek
+		store.Put(fileKey, strings.NewReader(contents))
+
+		uploader := NewUploaderHTTP(baseURL, meta, store)
+
+		t.Run(fmt.Sprintf("etag seekable=%t", !noSeek), func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, request)
+
+			assertStatusCode(t, response, http.StatusOK)
+			if etag := response.Header().Get("ETag"); etag != `"abc123"` {
+				t.Errorf("wrong etag, got %s", etag)
+			}
+			if lm := response.Header().Get("Last-Modified"); lm != "Mon, 02 Jan 2023 03:04:05 GMT" {
+				t.Errorf("wrong last modified, got %s", lm)
+			}
+		})
+		t.Run(fmt.Sprintf("if-none-match seekable=%t", !noSeek), func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+			request.Header.Set("If-None-Match", `"abc123"`)
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, request)
+
+			assertStatusCode(t, response, http.StatusNotModified)
+		})
+		t.Run(fmt.Sprintf("if-modified-since seekable=%t", !noSeek), func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+			request.Header.Set("If-Modified-Since", "Tue, 03 Jan 2023 00:00:00 GMT")
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, request)
+
+			assertStatusCode(t, response, http.StatusNotModified)
+		})
+	}
+
+	meta := newTestMeta()
+	meta.addFile(fileKey, "text/plain")
+	store := newMemoryFileStore()
+	store.Put(fileKey, strings.NewReader(contents))
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	t.Run("single range", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+		request.Header.Set("Range", "bytes=7-11")
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusPartialContent)
+		if diff := cmp.Diff(response.Body.String(), "World"); diff != "" {
+			t.Errorf("unexpected range body %s", diff)
+		}
+		if cr := response.Header().Get("Content-Range"); cr != "bytes 7-11/13" {
+			t.Errorf("wrong content range, got %s", cr)
+		}
+	})
+	t.Run("multiple ranges", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+		request.Header.Set("Range", "bytes=0-4,7-11")
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusPartialContent)
+		if ct := response.Header().Get("Content-Type"); !strings.HasPrefix(ct, "multipart/byteranges") {
+			t.Errorf("expected multipart response, got %s", ct)
+		}
+	})
+
+	store.noSeek = true
+	tests := map[string]struct {
+		header       map[string]string
+		status       int
+		body         string
+		contentRange string
+	}{
+		"unseekable single range": {map[string]string{"Range": "bytes=7-11"}, http.StatusPartialContent, "World", "bytes 7-11/13"},
+		"unseekable open range":   {map[string]string{"Range": "bytes=7-"}, http.StatusPartialContent, "World!", "bytes 7-12/13"},
+		"unseekable suffix range": {map[string]string{"Range": "bytes=-6"}, http.StatusPartialContent, "World!", "bytes 7-12/13"},
+		"unseekable past end":     {map[string]string{"Range": "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, "", "bytes */13"},
+		"unseekable multiple":     {map[string]string{"Range": "bytes=0-4,7-11"}, http.StatusOK, contents, ""},
+		"unseekable stale range": {map[string]string{"Range": "bytes=7-11", "If-Range": `"stale"`},
+			http.StatusOK, contents, ""},
+		"unseekable current range": {map[string]string{"Range": "bytes=7-11", "If-Range": `"abc123"`},
+			http.StatusPartialContent, "World", "bytes 7-11/13"},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+			for name, value := range test.header {
+				request.Header.Set(name, value)
+			}
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, request)
+
+			assertStatusCode(t, response, test.status)
+			if diff := cmp.Diff(test.body, response.Body.String()); diff != "" {
+				t.Errorf("unexpected range body %s", diff)
+			}
+			if cr := response.Header().Get("Content-Range"); cr != test.contentRange {
+				t.Errorf("wrong content range, got %s", cr)
+			}
+			if ar := response.Header().Get("Accept-Ranges"); ar != "bytes" {
+				t.Errorf("expected ranges to be accepted, got %q", ar)
+			}
+		})
+	}
+}
+
+func TestFileGetActiveContentHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+	path := filepath.Join(t.TempDir(), "page.html")
+	os.WriteFile(path, []byte("<html><script>fetch('/sharex', {method: 'POST'})</script></html>"), 0o600)
+	contentType, body, err := uploadFile(path, "file")
+	if err != nil {
+		t.Fatalf("failed to create upload body: %s", err)
+	}
+	request := httptest.NewRequest(http.MethodPost, "/uploads/test_user", body)
+	request.Header.Set("Content-Type", contentType)
+	request.Header.Set(auth.HTTPHeaderName, "Bearer "+user.AuthToken)
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusAccepted)
+	meta.addFile("2", "text/plain; charset=utf-8")
+	store.Put("2", strings.NewReader("Hello, World!"))
+
+	tests := map[string]struct {
+		target      string
+		disposition string
+	}{
+		"html":           {"/files/1", "attachment"},
+		"html with name": {"/files/1/page.html", `attachment; filename="page.html"`},
+		"text":           {"/files/2", ""},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, test.target, nil))
+			assertStatusCode(t, response, http.StatusOK)
+			header := response.Header()
+			if nosniff := header.Get("X-Content-Type-Options"); nosniff != "nosniff" {
+				t.Errorf("expected sniffing to be disabled, got %q", nosniff)
+			}
+			if csp := header.Get("Content-Security-Policy"); csp != "sandbox" {
+				t.Errorf("expected content to be sandboxed, got %q", csp)
+			}
+			if disposition := header.Get("Content-Disposition"); disposition != test.disposition {
+				t.Errorf("expected disposition %q, got %q", test.disposition, disposition)
+			}
+		})
+	}
+}
+
+func TestActiveContent(t *testing.T) {
+	tests := map[string]bool{
+		"text/html; charset=utf-8":  true,
+		"TEXT/HTML":                 true,
+		"text/xml; charset=utf-8":   true,
+		"application/xml":           true,
+		"image/svg+xml":             true,
+		"application/xhtml+xml":     true,
+		"text/plain; charset=utf-8": false,
+		"image/png":                 false,
+		"application/octet-stream":  false,
+	}
+	for contentType, want := range tests {
+		if got := activeContent(contentType); got != want {
+			t.Errorf("activeContent(%q) = %t, want %t", contentType, got, want)
+		}
+	}
+}
+
+func TestNewUploaderFromConfig_Error(t *testing.T) {
+	tests := map[string]func(cfg *Config){
+		"reap interval": func(cfg *Config) { cfg.ExpiryConfig = &expiryCfg{ReapInterval: "soon"} },
+		"partial ttl":   func(cfg *Config) { cfg.ExpiryConfig = &expiryCfg{PartialTTL: "soon"} },
+		"metrics":       func(cfg *Config) { cfg.MetricsConfig = &metricsCfg{Username: "prometheus"} },
+		"thumbnails":    func(cfg *Config) { cfg.ThumbnailConfig = &thumbnailCfg{Sizes: []int{-1}} },
+	}
+	for name, configure := range tests {
+		configure := configure
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			cfg := &Config{
+				BaseURL:    "http://localhost/",
+				BoltConfig: &boltCfg{Path: filepath.Join(dir, "uploader.db")},
+				DirConfig:  &dirCfg{Path: dir},
+			}
+			configure(cfg)
+			if _, err := NewUploaderFromConfig(cfg); err == nil {
+				t.Fatal("expected invalid config to fail")
+			}
+			// The database is locked while it is open, so it can only be opened again once it has been closed.
+			db, err := bbolt.Open(cfg.BoltConfig.Path, 0o600, &bbolt.Options{Timeout: time.Second})
+			if err != nil {
+				t.Fatalf("expected database to be closed after failing, got %s", err)
+			}
+			db.Close()
+		})
+	}
+}
+
 func TestFileDeleteHTTP(t *testing.T) {
 	fileKey := "1"
 	contents := "Hello, World!"
@@ -194,6 +411,93 @@ func TestFileDeleteHTTP(t *testing.T) {
 	})
 }
 
+func TestUserOwnershipHTTP(t *testing.T) {
+	meta := newTestMeta()
+	owner, _ := meta.UserRegister("test_user")
+	other, _ := meta.UserRegister("other_user")
+	admin, _ := meta.UserRegister("admin_user")
+	meta.UserSetRole("admin_user", auth.RoleAdmin)
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	// Tests run in order, as the final admin deletion removes the file.
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+	}{
+		{"upload as other user", http.MethodPost, "/uploads/test_user", other.AuthToken, http.StatusForbidden},
+		{"list other user", http.MethodGet, "/uploads/test_user", other.AuthToken, http.StatusForbidden},
+		{"delete through own path", http.MethodDelete, "/uploads/other_user/1", other.AuthToken, http.StatusForbidden},
+		{"delete through owner path", http.MethodDelete, "/uploads/test_user/1", other.AuthToken, http.StatusForbidden},
+		{"delete missing", http.MethodDelete, "/uploads/test_user/2", owner.AuthToken, http.StatusNotFound},
+		{"admin list", http.MethodGet, "/uploads/test_user", admin.AuthToken, http.StatusOK},
+		{"admin delete", http.MethodDelete, "/uploads/test_user/1", admin.AuthToken, http.StatusOK},
+	}
+	meta.addFile("1", "text/plain")
+	store.Put("1", strings.NewReader("Hello, World!"))
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.target, nil)
+			request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", test.token))
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, request)
+			assertStatusCode(t, response, test.status)
+		})
+	}
+	if _, err := store.Get("1"); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected admin deletion to remove the file")
+	}
+}
+
+func TestTokenScopesHTTP(t *testing.T) {
+	meta := newTestMeta()
+	meta.UserRegister("test_user")
+	uploadOnly, _ := meta.TokenCreate("test_user", "upload", auth.TokenOptions{Scopes: []auth.Scope{auth.ScopeUpload}})
+	readOnly, _ := meta.TokenCreate("test_user", "read", auth.TokenOptions{Scopes: []auth.Scope{auth.ScopeRead}})
+	deleter, _ := meta.TokenCreate("test_user", "delete", auth.TokenOptions{Scopes: []auth.Scope{auth.ScopeDelete}})
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+	meta.addFile("1", "text/plain")
+	store.Put("1", strings.NewReader("Hello, World!"))
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		token  string
+		status int
+	}{
+		{"upload only list", http.MethodGet, "/uploads/test_user", uploadOnly.Secret, http.StatusForbidden},
+		{"upload only quota", http.MethodGet, "/uploads/test_user/quota", uploadOnly.Secret, http.StatusForbidden},
+		{"upload only delete", http.MethodDelete, "/uploads/test_user/1", uploadOnly.Secret, http.StatusForbidden},
+		{"read only upload", http.MethodPost, "/uploads/test_user", readOnly.Secret, http.StatusForbidden},
+		{"read only list", http.MethodGet, "/uploads/test_user", readOnly.Secret, http.StatusOK},
+		{"read only delete", http.MethodDelete, "/uploads/test_user/1", readOnly.Secret, http.StatusForbidden},
+		{"delete only list", http.MethodGet, "/uploads/test_user", deleter.Secret, http.StatusForbidden},
+		{"delete only delete", http.MethodDelete, "/uploads/test_user/1", deleter.Secret, http.StatusOK},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(test.method, test.target, nil)
+			request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", test.token))
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, request)
+			assertStatusCode(t, response, test.status)
+		})
+	}
+
+	t.Run("upload only upload", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, uploadRequest(t, uploadOnly.Secret))
+		assertStatusCode(t, response, http.StatusAccepted)
+	})
+}
+
 func decodeUploadResponse(response *httptest.ResponseRecorder) (*UploadResponse, error) {
 	uploadResponse := &UploadResponse{}
 	if err := json.Unmarshal(response.Body.Bytes(), uploadResponse); err != nil {
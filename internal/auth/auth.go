@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -17,35 +18,106 @@ func (a authError) Error() string {
 
 const NotFoundError = authError("user not found")
 const DuplicateError = authError("duplicate username")
+const DisabledError = authError("user disabled")
+const MissingTokenError = authError("access token is missing")
+const InvalidTokenError = authError("access token is invalid")
 const ContextKey authCtxKey = 0
 const CodeAuthFailed = -2000
+const CodeForbidden = -2001
 const HTTPHeaderName = "Authorization"
 
 type Store interface {
+	// UserByAuthToken returns the user owning the token secret, failing with TokenExpiredError if it has expired
+	// and DisabledError if the user is disabled.
 	UserByAuthToken(string) (*User, error)
+	// UserRegister creates a user with a token named DefaultTokenName, returning the user with its secret.
+	// It fails with DuplicateError if the name is taken.
 	UserRegister(string) (*User, error)
+	// UserRegisterIdentity is UserRegister, binding the user to the identity it logs in as at a provider.
+	UserRegisterIdentity(name string, identity Identity) (*User, error)
+	// UserByName returns the record of the named user.
+	UserByName(name string) (*User, error)
+	// UserSetRole changes the role of the named user.
+	UserSetRole(name string, role Role) error
+	// UserSetDisabled disables or re-enables the named user.
+	UserSetDisabled(name string, disabled bool) error
+	// UserSetQuota overrides the configured quota of the named user, or restores it if quota is nil.
+	UserSetQuota(name string, quota *Quota) error
+	// UserList returns every registered user, ordered by name.
+	UserList() ([]*User, error)
+	// UserRevoke revokes every token of the named user.
+	UserRevoke(name string) error
+
+	// TokenCreate issues a new named token for the user. The returned token holds the only copy of its secret.
+	TokenCreate(user, name string, opts TokenOptions) (*Token, error)
+	// TokenList returns the tokens of the user ordered by name, without their secrets.
+	TokenList(user string) ([]*Token, error)
+	// TokenRevoke revokes the named token of the user.
+	TokenRevoke(user, name string) error
+	// TokenRotate replaces the secret of the named token, keeping its name, expiry and scopes.
+	TokenRotate(user, name string) (*Token, error)
 }
 
 func BearerAuth(m Store) func(next http.Handler) http.Handler {
+	return Authenticate(m, nil)
+}
+
+// FailureFunc is called with each request rejected by authentication and the reason it was rejected.
+type FailureFunc func(r *http.Request, reason error)
+
+// Authenticate returns middleware accepting either a bearer token or, unless sessions is nil, a session cookie.
+// A bearer token takes precedence when both are present.
+func Authenticate(m Store, sessions *Sessions) func(next http.Handler) http.Handler {
+	return AuthenticateReporting(m, sessions, nil)
+}
+
+// AuthenticateReporting is Authenticate, additionally calling failed with each rejected request unless it is nil.
+func AuthenticateReporting(m Store, sessions *Sessions, failed FailureFunc) func(next http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		resp := &responses.BaseResponse{Results: nil}
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			authFail := func() {
-				responses.Error(w, resp, http.StatusUnauthorized, CodeAuthFailed, "Access token is missing or invalid")
+			authFail := func(reason error) {
+				if failed != nil {
+					failed(r, reason)
+				}
+				switch {
+				case errors.Is(reason, TokenExpiredError):
+					responses.Error(w, resp, http.StatusUnauthorized, CodeAuthFailed, "Access token has expired")
+				case errors.Is(reason, DisabledError):
+					responses.Error(w, resp, http.StatusForbidden, CodeForbidden, "User is disabled")
+				default:
+					responses.Error(w, resp, http.StatusUnauthorized, CodeAuthFailed, "Access token is missing or invalid")
+				}
 			}
+			var user *User
+			var err error
 			token := r.Header.Get(HTTPHeaderName)
-			if token == "" {
-				authFail()
+			switch {
+			case token == "" && sessions != nil:
+				name, found := sessions.User(r)
+				if !found {
+					authFail(MissingTokenError)
+					return
+				}
+				if user, err = m.UserByName(name); err == nil && user.Disabled {
+					err = DisabledError
+				}
+			case token == "":
+				authFail(MissingTokenError)
 				return
+			default:
+				splitToken := strings.SplitN(token, " ", 2)
+				if len(splitToken) != 2 {
+					authFail(InvalidTokenError)
+					return
+				}
+				user, err = m.UserByAuthToken(splitToken[1])
 			}
-			splitToken := strings.SplitN(token, " ", 2)
-			if len(splitToken) != 2 {
-				authFail()
+			if errors.Is(err, TokenExpiredError) || errors.Is(err, DisabledError) {
+				authFail(err)
 				return
-			}
-			user, err := m.UserByAuthToken(splitToken[1])
-			if err != nil {
-				authFail()
+			} else if err != nil {
+				authFail(InvalidTokenError)
 				return
 			}
 			r = r.WithContext(context.WithValue(r.Context(), ContextKey, user))
@@ -63,3 +135,30 @@ func AuthUser(ctx context.Context) *User {
 	}
 	return nil
 }
+
+// RequireAdmin returns middleware that only allows admins. It must be used after BearerAuth.
+func RequireAdmin(next http.Handler) http.Handler {
+	resp := &responses.BaseResponse{Results: nil}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !AuthUser(r.Context()).IsAdmin() {
+			responses.Error(w, resp, http.StatusForbidden, CodeForbidden, "Access is limited to admins")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireUser returns middleware that only allows the authenticated user to act on the user named by the request,
+// as returned by name. Admins may act on any user. It must be used after BearerAuth.
+func RequireUser(name func(*http.Request) string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		resp := &responses.BaseResponse{Results: nil}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AuthUser(r.Context()).CanActAs(name(r)) {
+				responses.Error(w, resp, http.StatusForbidden, CodeForbidden, "Access to this user is not permitted")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
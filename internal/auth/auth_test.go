@@ -1,10 +1,12 @@
 package auth
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type authSpy struct {
@@ -25,7 +27,14 @@ type authTest struct {
 func TestBearerAuth(t *testing.T) {
 	store := NewMemoryAuthStore()
 	valid, _ := store.UserRegister("test_user")
+	past := time.Now().Add(-time.Minute)
+	expired, _ := store.TokenCreate("test_user", "expired", TokenOptions{Expires: &past})
 	tests := map[string]authTest{
+		"expired token": {
+			auth:     fmt.Sprintf("Bearer %s", expired.Secret),
+			username: "",
+			status:   401,
+		},
 		"valid case": {
 			auth:     fmt.Sprintf("Bearer %s", valid.AuthToken),
 			username: "test_user",
@@ -70,3 +79,259 @@ func TestBearerAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestRequireUser(t *testing.T) {
+	tests := map[string]struct {
+		user   *User
+		path   string
+		status int
+	}{
+		"same user":  {&User{Name: "test_user", Role: RoleUser}, "test_user", 200},
+		"other user": {&User{Name: "other_user", Role: RoleUser}, "test_user", 403},
+		"admin":      {&User{Name: "admin_user", Role: RoleAdmin}, "test_user", 200},
+		"no user":    {nil, "test_user", 403},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			handler := RequireUser(func(*http.Request) string { return test.path })(&authSpy{})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.user != nil {
+				request = request.WithContext(context.WithValue(request.Context(), ContextKey, test.user))
+			}
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("incorrect http status, want %d got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	tests := map[string]struct {
+		user   *User
+		status int
+	}{
+		"admin":   {&User{Name: "admin_user", Role: RoleAdmin}, 200},
+		"user":    {&User{Name: "test_user", Role: RoleUser}, 403},
+		"no user": {nil, 403},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.user != nil {
+				request = request.WithContext(context.WithValue(request.Context(), ContextKey, test.user))
+			}
+			RequireAdmin(&authSpy{}).ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("incorrect http status, want %d got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestAuthenticateReporting(t *testing.T) {
+	store := NewMemoryAuthStore()
+	valid, _ := store.UserRegister("test_user")
+	disabled, _ := store.UserRegister("disabled_user")
+	store.UserSetDisabled("disabled_user", true)
+	tests := map[string]struct {
+		auth   string
+		reason error
+	}{
+		"valid":     {"Bearer " + valid.AuthToken, nil},
+		"missing":   {"", MissingTokenError},
+		"malformed": {"BBBBBBBBBB", InvalidTokenError},
+		"unknown":   {"Bearer abc", InvalidTokenError},
+		"disabled":  {"Bearer " + disabled.AuthToken, DisabledError},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			var reason error
+			failed := func(r *http.Request, err error) {
+				reason = err
+			}
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set(HTTPHeaderName, test.auth)
+			AuthenticateReporting(store, nil, failed)(&authSpy{}).ServeHTTP(httptest.NewRecorder(), request)
+			if reason != test.reason {
+				t.Errorf("expected failure %v, got %v", test.reason, reason)
+			}
+		})
+	}
+}
+
+func TestMemoryAuthStore_Tokens(t *testing.T) {
+	store := NewMemoryAuthStore()
+	user, _ := store.UserRegister("test_user")
+	ci, err := store.TokenCreate("test_user", "ci", TokenOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %s", err)
+	}
+	if _, err := store.TokenCreate("test_user", "ci", TokenOptions{}); err != TokenDuplicateError {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+	rotated, err := store.TokenRotate("test_user", "ci")
+	if err != nil {
+		t.Fatalf("unexpected error rotating token: %s", err)
+	}
+	if _, err := store.UserByAuthToken(ci.Secret); err != NotFoundError {
+		t.Errorf("expected rotated out token to be invalid, got %v", err)
+	}
+	if found, err := store.UserByAuthToken(rotated.Secret); err != nil || found.Name != "test_user" {
+		t.Errorf("expected rotated token to be valid, got %v, %v", found, err)
+	}
+	tokens, _ := store.TokenList("test_user")
+	if len(tokens) != 2 || tokens[0].Name != "ci" || tokens[0].LastUsed == nil || tokens[1].Name != DefaultTokenName {
+		t.Errorf("unexpected tokens %+v", tokens)
+	}
+	if err := store.TokenRevoke("test_user", DefaultTokenName); err != nil {
+		t.Fatalf("unexpected error revoking token: %s", err)
+	}
+	if _, err := store.UserByAuthToken(user.AuthToken); err != NotFoundError {
+		t.Errorf("expected revoked token to be invalid, got %v", err)
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	tests := map[string]struct {
+		user   *User
+		status int
+	}{
+		"unscoped token": {&User{Name: "test_user"}, 200},
+		"matching scope": {&User{Name: "test_user", Scopes: []Scope{ScopeRead, ScopeUpload}}, 200},
+		"missing scope":  {&User{Name: "test_user", Scopes: []Scope{ScopeRead}}, 403},
+		"no user":        {nil, 403},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			handler := RequireScope(ScopeUpload)(&authSpy{})
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/", nil)
+			request = request.WithContext(context.WithValue(request.Context(), ContextKey, test.user))
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("incorrect http status, want %d got %d", test.status, recorder.Code)
+			}
+		})
+	}
+}
+
+func TestParseScopes(t *testing.T) {
+	scopes, err := ParseScopes("upload, delete")
+	if err != nil || len(scopes) != 2 || scopes[0] != ScopeUpload || scopes[1] != ScopeDelete {
+		t.Errorf("unexpected scopes %v, %v", scopes, err)
+	}
+	if scopes, err := ParseScopes(AllScopes); err != nil || scopes != nil {
+		t.Errorf("expected all to parse as no scopes, got %v, %v", scopes, err)
+	}
+	if _, err := ParseScopes("upload,admin"); err == nil {
+		t.Error("expected error for unknown scope")
+	}
+	if formatted := FormatScopes([]Scope{ScopeUpload, ScopeRead}); formatted != "upload,read" {
+		t.Errorf("unexpected formatted scopes %q", formatted)
+	}
+}
+
+func TestMemoryAuthStore_Registry(t *testing.T) {
+	store := NewMemoryAuthStore()
+	user, _ := store.UserRegister("test_user")
+	if _, err := store.UserRegister("test_user"); err != DuplicateError {
+		t.Errorf("expected duplicate error, got %v", err)
+	}
+	if found, err := store.UserByName("test_user"); err != nil || found.Created.IsZero() || len(found.Tokens) != 1 {
+		t.Errorf("unexpected user record %+v, %v", found, err)
+	}
+	store.UserSetDisabled("test_user", true)
+	if _, err := store.UserByAuthToken(user.AuthToken); err != DisabledError {
+		t.Errorf("expected disabled error, got %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	request.Header.Set(HTTPHeaderName, fmt.Sprintf("Bearer %s", user.AuthToken))
+	BearerAuth(store)(&authSpy{}).ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("expected disabled user to be forbidden, got %d", recorder.Code)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	sessions, err := NewSessions([]byte("key"), time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create sessions: %s", err)
+	}
+	recorder := httptest.NewRecorder()
+	if err := sessions.Issue(recorder, "test_user"); err != nil {
+		t.Fatalf("failed to issue session: %s", err)
+	}
+	cookie := recorder.Result().Cookies()[0]
+	if !cookie.HttpOnly || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("expected http only lax cookie, got %+v", cookie)
+	}
+	request := func(value string) *http.Request {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(&http.Cookie{Name: SessionCookieName, Value: value})
+		return request
+	}
+
+	if name, found := sessions.User(request(cookie.Value)); !found || name != "test_user" {
+		t.Errorf("expected session for test_user, got %q %t", name, found)
+	}
+	if _, found := sessions.User(request(cookie.Value + "x")); found {
+		t.Error("expected tampered session to be rejected")
+	}
+	other, _ := NewSessions([]byte("other key"), time.Hour)
+	if _, found := other.User(request(cookie.Value)); found {
+		t.Error("expected session signed with another key to be rejected")
+	}
+	sessions.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, found := sessions.User(request(cookie.Value)); found {
+		t.Error("expected expired session to be rejected")
+	}
+}
+
+func TestAuthenticate_Session(t *testing.T) {
+	store := NewMemoryAuthStore()
+	valid, _ := store.UserRegister("test_user")
+	store.UserRegister("disabled_user")
+	store.UserSetDisabled("disabled_user", true)
+	sessions, _ := NewSessions(nil, 0)
+	cookie := func(user string) *http.Cookie {
+		recorder := httptest.NewRecorder()
+		sessions.Issue(recorder, user)
+		return recorder.Result().Cookies()[0]
+	}
+	tests := map[string]struct {
+		cookie   *http.Cookie
+		auth     string
+		username string
+		status   int
+	}{
+		"session":       {cookie("test_user"), "", "test_user", 200},
+		"no session":    {nil, "", "", 401},
+		"disabled user": {cookie("disabled_user"), "", "", 403},
+		"unknown user":  {cookie("unknown_user"), "", "", 401},
+		"bearer first":  {cookie("disabled_user"), "Bearer " + valid.AuthToken, "test_user", 200},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			authSpy := &authSpy{}
+			recorder := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.cookie != nil {
+				request.AddCookie(test.cookie)
+			}
+			request.Header.Set(HTTPHeaderName, test.auth)
+			Authenticate(store, sessions)(authSpy).ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("incorrect http status, want %d got %d", test.status, recorder.Code)
+			}
+			if test.username != "" && (authSpy.user == nil || authSpy.user.Name != test.username) {
+				t.Errorf("expected user %s, got %+v", test.username, authSpy.user)
+			}
+		})
+	}
+}
@@ -1,31 +1,207 @@
 package auth
 
-import "fmt"
+import (
+	"sort"
+	"time"
+)
 
 type MemoryAuthStore struct {
-	tokens map[string]*User
+	// tokens are keyed by the hash of their secret.
+	tokens map[string]*Token
 	users  map[string]*User
-	key    int
 }
 
 func NewMemoryAuthStore() *MemoryAuthStore {
-	return &MemoryAuthStore{users: map[string]*User{}, tokens: map[string]*User{}}
+	return &MemoryAuthStore{users: map[string]*User{}, tokens: map[string]*Token{}}
 }
 
-func (s *MemoryAuthStore) UserByAuthToken(token string) (*User, error) {
-	if user, found := s.tokens[token]; found {
-		return user, nil
+// copyUser returns a copy of the user that does not share slices with the stored record.
+func copyUser(user *User) *User {
+	copied := *user
+	copied.Tokens = append([]string(nil), user.Tokens...)
+	if user.Identity != nil {
+		identity := *user.Identity
+		copied.Identity = &identity
 	}
-	return nil, NotFoundError
+	return &copied
 }
 
-func (s *MemoryAuthStore) UserRegister(name string) (*User, error) {
-	user := &User{
-		Name:      name,
-		AuthToken: fmt.Sprintf("%d", s.key),
+func (s *MemoryAuthStore) UserByAuthToken(secret string) (*User, error) {
+	token, found := s.tokens[HashToken(secret)]
+	if !found {
+		return nil, NotFoundError
+	}
+	now := time.Now()
+	if token.Expired(now) {
+		return nil, TokenExpiredError
 	}
-	s.key++
-	s.users[name] = user
-	s.tokens[user.AuthToken] = user
+	user := copyUser(s.users[token.User])
+	if user.Disabled {
+		return nil, DisabledError
+	}
+	token.Touch(now)
+	user.AuthToken = secret
+	user.Scopes = token.Scopes
 	return user, nil
 }
+
+func (s *MemoryAuthStore) UserRegister(name string) (*User, error) {
+	return s.register(name, nil)
+}
+
+func (s *MemoryAuthStore) UserRegisterIdentity(name string, identity Identity) (*User, error) {
+	return s.register(name, &identity)
+}
+
+func (s *MemoryAuthStore) register(name string, identity *Identity) (*User, error) {
+	if _, found := s.users[name]; found {
+		return nil, DuplicateError
+	}
+	s.users[name] = &User{Name: name, Role: RoleUser, Created: time.Now().UTC(), Identity: identity}
+	token, err := s.TokenCreate(name, DefaultTokenName, TokenOptions{})
+	if err != nil {
+		delete(s.users, name)
+		return nil, err
+	}
+	registered := copyUser(s.users[name])
+	registered.AuthToken = token.Secret
+	return registered, nil
+}
+
+func (s *MemoryAuthStore) UserByName(name string) (*User, error) {
+	user, found := s.users[name]
+	if !found {
+		return nil, NotFoundError
+	}
+	return copyUser(user), nil
+}
+
+func (s *MemoryAuthStore) UserSetRole(name string, role Role) error {
+	user, found := s.users[name]
+	if !found {
+		return NotFoundError
+	}
+	user.Role = role
+	return nil
+}
+
+func (s *MemoryAuthStore) UserSetDisabled(name string, disabled bool) error {
+	user, found := s.users[name]
+	if !found {
+		return NotFoundError
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+func (s *MemoryAuthStore) UserSetQuota(name string, quota *Quota) error {
+	user, found := s.users[name]
+	if !found {
+		return NotFoundError
+	}
+	user.Quota = quota
+	return nil
+}
+
+func (s *MemoryAuthStore) UserList() ([]*User, error) {
+	users := make([]*User, 0, len(s.users))
+	for _, user := range s.users {
+		users = append(users, copyUser(user))
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users, nil
+}
+
+func (s *MemoryAuthStore) UserRevoke(name string) error {
+	user, found := s.users[name]
+	if !found {
+		return NotFoundError
+	}
+	for _, hash := range user.Tokens {
+		delete(s.tokens, hash)
+	}
+	user.Tokens = nil
+	return nil
+}
+
+func (s *MemoryAuthStore) token(user, name string) *Token {
+	for _, token := range s.tokens {
+		if token.User == user && token.Name == name {
+			return token
+		}
+	}
+	return nil
+}
+
+// putToken stores the token without its secret and references it from the user.
+func (s *MemoryAuthStore) putToken(token *Token) {
+	stored := *token
+	stored.Secret = ""
+	s.tokens[token.Hash] = &stored
+	user := s.users[token.User]
+	user.Tokens = append(user.Tokens, token.Hash)
+}
+
+// deleteToken removes the token and its reference from the user.
+func (s *MemoryAuthStore) deleteToken(token *Token) {
+	delete(s.tokens, token.Hash)
+	user := s.users[token.User]
+	for i, hash := range user.Tokens {
+		if hash == token.Hash {
+			user.Tokens = append(user.Tokens[:i], user.Tokens[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *MemoryAuthStore) TokenCreate(user, name string, opts TokenOptions) (*Token, error) {
+	if _, found := s.users[user]; !found {
+		return nil, NotFoundError
+	}
+	if s.token(user, name) != nil {
+		return nil, TokenDuplicateError
+	}
+	token, err := NewToken(user, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.putToken(token)
+	return token, nil
+}
+
+func (s *MemoryAuthStore) TokenList(user string) ([]*Token, error) {
+	record, found := s.users[user]
+	if !found {
+		return nil, NotFoundError
+	}
+	tokens := []*Token{}
+	for _, hash := range record.Tokens {
+		listed := *s.tokens[hash]
+		tokens = append(tokens, &listed)
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, nil
+}
+
+func (s *MemoryAuthStore) TokenRevoke(user, name string) error {
+	token := s.token(user, name)
+	if token == nil {
+		return NotFoundError
+	}
+	s.deleteToken(token)
+	return nil
+}
+
+func (s *MemoryAuthStore) TokenRotate(user, name string) (*Token, error) {
+	old := s.token(user, name)
+	if old == nil {
+		return nil, NotFoundError
+	}
+	token, err := NewToken(user, name, old.Options())
+	if err != nil {
+		return nil, err
+	}
+	s.deleteToken(old)
+	s.putToken(token)
+	return token, nil
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"uploader/internal/responses"
+)
+
+// Scope is an operation a token permits. A token without scopes permits every operation.
+type Scope string
+
+const (
+	// ScopeUpload permits creating uploads.
+	ScopeUpload Scope = "upload"
+	// ScopeRead permits listing uploads and reading quota usage.
+	ScopeRead Scope = "read"
+	// ScopeDelete permits deleting uploads.
+	ScopeDelete Scope = "delete"
+)
+
+// AllScopes is the name accepted by ParseScopes for a token permitting every operation.
+const AllScopes = "all"
+
+// ParseScopes parses a comma separated list of scopes. An empty list or AllScopes returns no scopes, permitting everything.
+func ParseScopes(list string) ([]Scope, error) {
+	if list == "" || list == AllScopes {
+		return nil, nil
+	}
+	var scopes []Scope
+	for _, name := range strings.Split(list, ",") {
+		scope := Scope(strings.TrimSpace(name))
+		switch scope {
+		case ScopeUpload, ScopeRead, ScopeDelete:
+			scopes = append(scopes, scope)
+		default:
+			return nil, authError("unknown scope " + string(scope))
+		}
+	}
+	return scopes, nil
+}
+
+// FormatScopes returns scopes in the form accepted by ParseScopes.
+func FormatScopes(scopes []Scope) string {
+	if len(scopes) == 0 {
+		return AllScopes
+	}
+	names := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		names = append(names, string(scope))
+	}
+	return strings.Join(names, ",")
+}
+
+// HasScope reports whether the token used to authenticate the user permits the operation.
+func (u *User) HasScope(scope Scope) bool {
+	if u == nil {
+		return false
+	}
+	if len(u.Scopes) == 0 {
+		return true
+	}
+	for _, s := range u.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireScope returns middleware that only allows requests whose token permits the operation. It must be used after BearerAuth.
+func RequireScope(scope Scope) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		resp := &responses.BaseResponse{Results: nil}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !AuthUser(r.Context()).HasScope(scope) {
+				responses.Error(w, resp, http.StatusForbidden, CodeForbidden, "Access token lacks the "+string(scope)+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const SessionCookieName = "uploader_session"
+
+// DefaultSessionLifetime is how long a browser session lasts when no lifetime is configured.
+const DefaultSessionLifetime = 7 * 24 * time.Hour
+
+// Sessions issues and verifies browser session cookies. A session is the name of the user and its expiry, signed
+// with a key held by the server, so sessions need no storage but can only be ended early by disabling the user.
+type Sessions struct {
+	key      []byte
+	lifetime time.Duration
+	// Secure restricts the cookie to HTTPS connections.
+	Secure bool
+	now    func() time.Time
+}
+
+type session struct {
+	User    string `json:"u"`
+	Expires int64  `json:"e"`
+}
+
+// NewSessions returns sessions signed with key. If key is empty a random key is used, so sessions end when the
+// server restarts.
+func NewSessions(key []byte, lifetime time.Duration) (*Sessions, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	if lifetime <= 0 {
+		lifetime = DefaultSessionLifetime
+	}
+	return &Sessions{key: key, lifetime: lifetime, now: time.Now}, nil
+}
+
+func (s *Sessions) sign(value string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue sets a session cookie for the named user.
+func (s *Sessions) Issue(w http.ResponseWriter, user string) error {
+	expires := s.now().Add(s.lifetime)
+	payload, err := json.Marshal(session{User: user, Expires: expires.Unix()})
+	if err != nil {
+		return err
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    value + "." + s.sign(value),
+		Path:     "/",
+		Expires:  expires,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		// Lax cookies are not sent with cross-site POST and DELETE requests, protecting the API from CSRF.
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+// Clear removes the session cookie.
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// User returns the name of the user with a valid session cookie on the request.
+func (s *Sessions) User(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(SessionCookieName)
+	if err != nil {
+		return "", false
+	}
+	value, signature, found := strings.Cut(cookie.Value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.sign(value))) {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return "", false
+	}
+	decoded := session{}
+	if err := json.Unmarshal(payload, &decoded); err != nil || decoded.User == "" {
+		return "", false
+	}
+	if s.now().Unix() >= decoded.Expires {
+		return "", false
+	}
+	return decoded.User, true
+}
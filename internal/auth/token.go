@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+const TokenExpiredError = authError("token expired")
+const TokenDuplicateError = authError("duplicate token name")
+
+// DefaultTokenName is the name of the token issued when a user is registered.
+const DefaultTokenName = "default"
+
+// LastUsedResolution is how stale the last used time of a token may become before it is updated,
+// so that stores need not write on every authenticated request.
+const LastUsedResolution = time.Minute
+
+// Token is a named credential of a user. Only the hash of the secret is stored.
+type Token struct {
+	Name     string     `json:"name"`
+	User     string     `json:"user"`
+	Hash     string     `json:"hash"`
+	Created  time.Time  `json:"created"`
+	LastUsed *time.Time `json:"last_used,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Scopes   []Scope    `json:"scopes,omitempty"`
+	// Secret is the plaintext token, only set on a token that has just been issued.
+	Secret string `json:"secret,omitempty"`
+}
+
+// TokenOptions are the optional restrictions of a new token.
+type TokenOptions struct {
+	// Expires is when the token stops being accepted, or nil if it does not expire.
+	Expires *time.Time
+	// Scopes are the operations permitted by the token, or nil for every operation.
+	Scopes []Scope
+}
+
+// NewToken issues a token with a random secret.
+func NewToken(user, name string, opts TokenOptions) (*Token, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	token := &Token{
+		Name:    name,
+		User:    user,
+		Created: time.Now().UTC(),
+		Expires: opts.Expires,
+		Scopes:  opts.Scopes,
+		Secret:  base64.RawURLEncoding.EncodeToString(secret),
+	}
+	token.Hash = HashToken(token.Secret)
+	return token, nil
+}
+
+// HashToken returns the hash a token secret is stored under. Secrets are random, so need no salt or stretching.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Options returns the restrictions of the token, for issuing a replacement.
+func (t *Token) Options() TokenOptions {
+	return TokenOptions{Expires: t.Expires, Scopes: t.Scopes}
+}
+
+// Expired reports whether the token has an expiry at or before now.
+func (t *Token) Expired(now time.Time) bool {
+	return t.Expires != nil && !t.Expires.After(now)
+}
+
+// Touch records that the token was used at now, returning true if the stored token needs updating.
+func (t *Token) Touch(now time.Time) bool {
+	if t.LastUsed != nil && now.Sub(*t.LastUsed) < LastUsedResolution {
+		return false
+	}
+	now = now.UTC()
+	t.LastUsed = &now
+	return true
+}
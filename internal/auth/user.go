@@ -1,6 +1,61 @@
 package auth
 
+import "time"
+
+type Role string
+
+const (
+	RoleUser  Role = "user"
+	RoleAdmin Role = "admin"
+)
+
+// ParseRole validates a role name, an empty name is the default user role.
+func ParseRole(name string) (Role, error) {
+	switch Role(name) {
+	case "", RoleUser:
+		return RoleUser, nil
+	case RoleAdmin:
+		return RoleAdmin, nil
+	}
+	return "", authError("unknown role " + name)
+}
+
+// Quota overrides the configured storage limits of a user, where zero values are unlimited.
+type Quota struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+// Identity is the account at an OpenID Connect provider that a user was registered for.
+type Identity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+}
+
+// User is the registered record of a user. When returned for an authenticated request it also holds the token
+// that was presented, along with its scopes.
 type User struct {
-	Name      string `json:"name"`
-	AuthToken string `json:"token"`
+	Name      string    `json:"name"`
+	AuthToken string    `json:"token,omitempty"`
+	Role      Role      `json:"role,omitempty"`
+	Created   time.Time `json:"created"`
+	// Disabled users are refused authentication, but keep their tokens and uploads.
+	Disabled bool   `json:"disabled,omitempty"`
+	Quota    *Quota `json:"quota,omitempty"`
+	// Identity is set on users registered by logging in through a provider, who may only log in as that identity.
+	Identity *Identity `json:"identity,omitempty"`
+	// Tokens are the hashes of the tokens of the user.
+	Tokens []string `json:"tokens,omitempty"`
+	// Scopes are those of the token the user authenticated with.
+	Scopes []Scope `json:"scopes,omitempty"`
+}
+
+// IsAdmin reports whether the user may act on uploads belonging to other users.
+func (u *User) IsAdmin() bool {
+	return u != nil && u.Role == RoleAdmin
+}
+
+// CanActAs reports whether the user may act on the uploads of the named user.
+func (u *User) CanActAs(name string) bool {
+	return u != nil && (u.Name == name || u.IsAdmin())
 }
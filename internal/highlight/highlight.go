@@ -0,0 +1,304 @@
+// Package highlight is a small syntax highlighter for pastes. It recognises the comments, strings, numbers and
+// keywords of common languages, which is enough for readable pastes without a full lexer for each language.
+package highlight
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kind is the class of a token, used as its CSS class. Plain text has an empty kind.
+type Kind string
+
+const (
+	Plain   Kind = ""
+	Keyword Kind = "kw"
+	String  Kind = "str"
+	Comment Kind = "com"
+	Number  Kind = "num"
+)
+
+// PlainText is the language of text that is not highlighted.
+const PlainText = "text"
+
+type Token struct {
+	Kind Kind
+	Text string
+}
+
+type language struct {
+	keywords      map[string]bool
+	lineComments  []string
+	blockComments [][2]string
+	// quotes are the string delimiters, multiline quotes may span lines.
+	quotes          string
+	multilineQuotes string
+	// caseInsensitive keywords, such as those of SQL.
+	caseInsensitive bool
+}
+
+func keywords(list string) map[string]bool {
+	set := map[string]bool{}
+	for _, word := range strings.Fields(list) {
+		set[word] = true
+	}
+	return set
+}
+
+var cKeywords = "auto break case char const continue default do double else enum extern float for goto if inline int long register restrict return short signed sizeof static struct switch typedef union unsigned void volatile while NULL true false bool"
+
+var languages = map[string]*language{
+	"go": {
+		keywords:        keywords("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota any error string bool byte rune int int8 int16 int32 int64 uint uint8 uint16 uint32 uint64 uintptr float32 float64 complex64 complex128"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          `"'`,
+		multilineQuotes: "`",
+	},
+	"python": {
+		keywords:     keywords("False None True and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield self"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	},
+	"javascript": {
+		keywords:        keywords("async await break case catch class const continue debugger default delete do else export extends finally for function if import in instanceof let new null of return static super switch this throw try typeof undefined var void while with yield true false"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          `"'`,
+		multilineQuotes: "`",
+	},
+	"typescript": {
+		keywords:        keywords("abstract any as async await boolean break case catch class const constructor continue declare default delete do else enum export extends finally for from function if implements import in instanceof interface keyof let namespace never new null number of private protected public readonly return static string super switch this throw try type typeof undefined unknown var void while yield true false"),
+		lineComments:    []string{"//"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          `"'`,
+		multilineQuotes: "`",
+	},
+	"c": {
+		keywords:      keywords(cKeywords),
+		lineComments:  []string{"//", "#"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+	},
+	"cpp": {
+		keywords:      keywords(cKeywords + " class namespace template typename public private protected virtual override new delete this throw try catch using nullptr constexpr auto std"),
+		lineComments:  []string{"//", "#"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+	},
+	"java": {
+		keywords:      keywords("abstract assert boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long native new null package private protected public return short static super switch synchronized this throw throws try var void volatile while true false"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"'`,
+	},
+	"rust": {
+		keywords:      keywords("as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while Some None Ok Err"),
+		lineComments:  []string{"//"},
+		blockComments: [][2]string{{"/*", "*/"}},
+		quotes:        `"`,
+	},
+	"shell": {
+		keywords:     keywords("if then else elif fi case esac for while until do done in function return local export readonly set unset shift exit echo"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	},
+	"sql": {
+		keywords:        keywords("select from where insert into values update set delete create table drop alter add index primary key foreign references not null and or in is like join left right inner outer on as group by order having limit offset distinct union all case when then else end begin commit rollback"),
+		lineComments:    []string{"--"},
+		blockComments:   [][2]string{{"/*", "*/"}},
+		quotes:          `'"`,
+		caseInsensitive: true,
+	},
+	"yaml": {
+		keywords:     keywords("true false null yes no on off"),
+		lineComments: []string{"#"},
+		quotes:       `"'`,
+	},
+	"json": {
+		keywords: keywords("true false null"),
+		quotes:   `"`,
+	},
+}
+
+// aliases map common names and file extensions to languages.
+var aliases = map[string]string{
+	"golang": "go",
+	"py":     "python",
+	"js":     "javascript",
+	"mjs":    "javascript",
+	"ts":     "typescript",
+	"h":      "c",
+	"c++":    "cpp",
+	"cc":     "cpp",
+	"hpp":    "cpp",
+	"rs":     "rust",
+	"sh":     "shell",
+	"bash":   "shell",
+	"zsh":    "shell",
+	"yml":    "yaml",
+	"txt":    PlainText,
+}
+
+// Language returns the canonical name of a language or alias, and whether it is supported. Plain text is supported.
+func Language(name string) (string, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, found := aliases[name]; found {
+		name = alias
+	}
+	if _, found := languages[name]; found || name == PlainText {
+		return name, true
+	}
+	return "", false
+}
+
+// FromFilename returns the language of a file from its extension, or PlainText if it is not recognised.
+func FromFilename(filename string) string {
+	if language, found := Language(strings.TrimPrefix(path.Ext(filename), ".")); found {
+		return language
+	}
+	return PlainText
+}
+
+// Languages returns the supported languages, excluding aliases.
+func Languages() []string {
+	names := []string{PlainText}
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lines splits the source into lines of highlighted tokens. Tokens spanning several lines, such as block comments,
+// are split so that each line can be rendered on its own.
+func Lines(source, lang string) [][]Token {
+	var tokens []Token
+	if l, found := languages[lang]; found {
+		tokens = l.tokenize(source)
+	} else {
+		tokens = []Token{{Plain, source}}
+	}
+	lines := [][]Token{nil}
+	for _, token := range tokens {
+		parts := strings.Split(token.Text, "\n")
+		for i, part := range parts {
+			if i > 0 {
+				lines = append(lines, nil)
+			}
+			if part != "" {
+				lines[len(lines)-1] = append(lines[len(lines)-1], Token{token.Kind, part})
+			}
+		}
+	}
+	// A trailing newline ends the last line rather than starting another.
+	if len(lines) > 1 && len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+func isWordStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isWord(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func (l *language) tokenize(source string) []Token {
+	var tokens []Token
+	plainStart := 0
+	emit := func(start, end int, kind Kind) {
+		if plainStart < start {
+			tokens = append(tokens, Token{Plain, source[plainStart:start]})
+		}
+		tokens = append(tokens, Token{kind, source[start:end]})
+		plainStart = end
+	}
+	for i := 0; i < len(source); {
+		rest := source[i:]
+		if end := l.comment(rest); end > 0 {
+			emit(i, i+end, Comment)
+			i += end
+			continue
+		}
+		r, width := utf8.DecodeRuneInString(rest)
+		switch {
+		case strings.ContainsRune(l.quotes, r) || strings.ContainsRune(l.multilineQuotes, r):
+			end := stringEnd(rest, r, strings.ContainsRune(l.multilineQuotes, r))
+			emit(i, i+end, String)
+			i += end
+		case unicode.IsDigit(r):
+			end := strings.IndexFunc(rest, func(r rune) bool { return !isWord(r) && r != '.' })
+			if end < 0 {
+				end = len(rest)
+			}
+			emit(i, i+end, Number)
+			i += end
+		case isWordStart(r):
+			end := strings.IndexFunc(rest, func(r rune) bool { return !isWord(r) })
+			if end < 0 {
+				end = len(rest)
+			}
+			word := rest[:end]
+			if l.caseInsensitive {
+				word = strings.ToLower(word)
+			}
+			if l.keywords[word] {
+				emit(i, i+end, Keyword)
+			}
+			i += end
+		default:
+			i += width
+		}
+	}
+	if plainStart < len(source) {
+		tokens = append(tokens, Token{Plain, source[plainStart:]})
+	}
+	return tokens
+}
+
+// comment returns the length of the comment at the start of source, or zero if there is none.
+func (l *language) comment(source string) int {
+	for _, prefix := range l.lineComments {
+		if strings.HasPrefix(source, prefix) {
+			if end := strings.IndexByte(source, '\n'); end >= 0 {
+				return end
+			}
+			return len(source)
+		}
+	}
+	for _, delims := range l.blockComments {
+		if strings.HasPrefix(source, delims[0]) {
+			if end := strings.Index(source[len(delims[0]):], delims[1]); end >= 0 {
+				return len(delims[0]) + end + len(delims[1])
+			}
+			return len(source)
+		}
+	}
+	return 0
+}
+
+// stringEnd returns the length of the string literal at the start of source, delimited by quote. Unterminated strings
+// end at the end of the line, unless multiline.
+func stringEnd(source string, quote rune, multiline bool) int {
+	escaped := false
+	for i, r := range source[utf8.RuneLen(quote):] {
+		i += utf8.RuneLen(quote)
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && !multiline:
+			escaped = true
+		case r == quote:
+			return i + utf8.RuneLen(r)
+		case r == '\n' && !multiline:
+			return i
+		}
+	}
+	return len(source)
+}
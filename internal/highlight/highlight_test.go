@@ -0,0 +1,89 @@
+package highlight
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLines(t *testing.T) {
+	tests := map[string]struct {
+		source string
+		lang   string
+		want   [][]Token
+	}{
+		"go": {
+			source: "func main() {\n\treturn \"a // b\" // done\n}\n",
+			lang:   "go",
+			want: [][]Token{
+				{{Keyword, "func"}, {Plain, " main() {"}},
+				{{Plain, "\t"}, {Keyword, "return"}, {Plain, " "}, {String, `"a // b"`}, {Plain, " "}, {Comment, "// done"}},
+				{{Plain, "}"}},
+			},
+		},
+		"block comment across lines": {
+			source: "x = 1 /* a\nb */ y",
+			lang:   "c",
+			want: [][]Token{
+				{{Plain, "x = "}, {Number, "1"}, {Plain, " "}, {Comment, "/* a"}},
+				{{Comment, "b */"}, {Plain, " y"}},
+			},
+		},
+		"escaped quote": {
+			source: `s = 'it\'s' # c`,
+			lang:   "python",
+			want:   [][]Token{{{Plain, "s = "}, {String, `'it\'s'`}, {Plain, " "}, {Comment, "# c"}}},
+		},
+		"unterminated string": {
+			source: "a = \"open\nb",
+			lang:   "javascript",
+			want:   [][]Token{{{Plain, "a = "}, {String, `"open`}}, {{Plain, "b"}}},
+		},
+		"case insensitive keywords": {
+			source: "SELECT id FROM t",
+			lang:   "sql",
+			want:   [][]Token{{{Keyword, "SELECT"}, {Plain, " id "}, {Keyword, "FROM"}, {Plain, " t"}}},
+		},
+		"plain text": {
+			source: "func\n\nreturn",
+			lang:   PlainText,
+			want:   [][]Token{{{Plain, "func"}}, nil, {{Plain, "return"}}},
+		},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			if diff := cmp.Diff(test.want, Lines(test.source, test.lang)); diff != "" {
+				t.Errorf("unexpected tokens (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLanguage(t *testing.T) {
+	tests := map[string]struct {
+		name      string
+		want      string
+		supported bool
+	}{
+		"canonical": {"go", "go", true},
+		"alias":     {"JS", "javascript", true},
+		"plain":     {"text", PlainText, true},
+		"unknown":   {"cobol", "", false},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, supported := Language(test.name)
+			if got != test.want || supported != test.supported {
+				t.Errorf("Language(%q) = %q, %t, want %q, %t", test.name, got, supported, test.want, test.supported)
+			}
+		})
+	}
+	if got := FromFilename("main.rs"); got != "rust" {
+		t.Errorf("expected rust from extension, got %s", got)
+	}
+	if got := FromFilename("notes"); got != PlainText {
+		t.Errorf("expected plain text without extension, got %s", got)
+	}
+}
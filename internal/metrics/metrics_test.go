@@ -0,0 +1,94 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRegistry_Write(t *testing.T) {
+	r := NewRegistry()
+	requests := r.Counter("requests_total", "Requests served.", "route", "status")
+	r.Counter("bytes_total", "Bytes\nwritten.")
+	latency := r.Histogram("latency_seconds", "Request latency.", []float64{0.1, 1}, "route")
+	r.GaugeFunc("stored_bytes", "Stored bytes.", []string{"user"}, func() ([]Sample, error) {
+		return []Sample{{Labels: []string{`a "quoted" user`}, Value: 42}}, nil
+	})
+
+	requests.Inc("/b", "200")
+	requests.Inc("/a", "404")
+	requests.Add(2, "/b", "200")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.1, "/a")
+	latency.Observe(5, "/a")
+
+	var out strings.Builder
+	if err := r.Write(&out); err != nil {
+		t.Fatalf("unexpected error writing metrics: %s", err)
+	}
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/a",status="404"} 1
+requests_total{route="/b",status="200"} 3
+# HELP bytes_total Bytes\nwritten.
+# TYPE bytes_total counter
+bytes_total 0
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/a",le="0.1"} 2
+latency_seconds_bucket{route="/a",le="1"} 2
+latency_seconds_bucket{route="/a",le="+Inf"} 3
+latency_seconds_sum{route="/a"} 5.15
+latency_seconds_count{route="/a"} 3
+# HELP stored_bytes Stored bytes.
+# TYPE stored_bytes gauge
+stored_bytes{user="a \"quoted\" user"} 42
+`
+	if diff := cmp.Diff(want, out.String()); diff != "" {
+		t.Errorf("unexpected metrics %s", diff)
+	}
+	if requests.Value("/b", "200") != 3 || latency.Count("/a") != 3 {
+		t.Error("unexpected values read back")
+	}
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("requests_total", "Requests served.").Inc()
+	response := httptest.NewRecorder()
+	r.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if response.Code != http.StatusOK || response.Header().Get("Content-Type") != ContentType {
+		t.Errorf("unexpected response %d %s", response.Code, response.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(response.Body.String(), "requests_total 1\n") {
+		t.Errorf("unexpected body %s", response.Body.String())
+	}
+
+	r.GaugeFunc("broken", "Fails to collect.", nil, func() ([]Sample, error) {
+		return nil, errors.New("store closed")
+	})
+	response = httptest.NewRecorder()
+	r.Handler().ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if response.Code != http.StatusInternalServerError {
+		t.Errorf("expected failure to collect to be an error, got %d", response.Code)
+	}
+}
+
+func TestCounter_LabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected wrong number of label values to panic")
+		}
+	}()
+	NewRegistry().Counter("requests_total", "Requests served.", "route").Inc()
+}
+
+func TestExponentialBuckets(t *testing.T) {
+	if diff := cmp.Diff([]float64{1, 4, 16}, ExponentialBuckets(1, 4, 3)); diff != "" {
+		t.Errorf("unexpected buckets %s", diff)
+	}
+}
@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// clockSkew is the allowance for differences between the clocks of the provider and the server.
+const clockSkew = time.Minute
+
+var (
+	// ErrInvalidToken is returned when an ID token is malformed, or its signature does not verify.
+	ErrInvalidToken = errors.New("oidc: invalid id token")
+	// ErrUnknownKey is returned when an ID token is signed by a key the provider does not publish.
+	ErrUnknownKey = errors.New("oidc: id token signed by unknown key")
+)
+
+// Claims are the claims of a verified ID token.
+type Claims map[string]any
+
+// String returns the named claim if it is a string, or an empty string.
+func (c Claims) String(name string) string {
+	value, _ := c[name].(string)
+	return value
+}
+
+// audience reports whether the aud claim, either a string or an array, contains the client.
+func (c Claims) audience(client string) bool {
+	switch aud := c["aud"].(type) {
+	case string:
+		return aud == client
+	case []any:
+		for _, value := range aud {
+			if value == client {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	value, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(value), 0), true
+}
+
+// JSONWebKey is an RSA public key in the JSON Web Key format.
+type JSONWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use,omitempty"`
+	Alg     string `json:"alg,omitempty"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+// NewJSONWebKey encodes an RSA public key for signing ID tokens with RS256.
+func NewJSONWebKey(id string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		KeyType: "RSA",
+		KeyID:   id,
+		Use:     "sig",
+		Alg:     "RS256",
+		N:       base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:       base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func (k JSONWebKey) publicKey() (*rsa.PublicKey, error) {
+	if k.KeyType != "RSA" {
+		return nil, fmt.Errorf("oidc: unsupported key type %q", k.KeyType)
+	}
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// keySet holds the signing keys of the provider by key ID.
+type keySet map[string]*rsa.PublicKey
+
+// key returns the signing key with the given ID, fetching the published keys again if it is not known,
+// as providers rotate their keys.
+func (p *Provider) key(id string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, found := p.keys[id]; found {
+		return key, nil
+	}
+	published := struct {
+		Keys []JSONWebKey `json:"keys"`
+	}{}
+	if err := p.getJSON(p.metadata.JWKSURI, &published); err != nil {
+		return nil, err
+	}
+	p.keys = keySet{}
+	for _, jwk := range published.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue
+		}
+		p.keys[jwk.KeyID] = key
+	}
+	if key, found := p.keys[id]; found {
+		return key, nil
+	}
+	return nil, ErrUnknownKey
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID token, returning its claims.
+func (p *Provider) Verify(token, nonce string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// Only RS256 is accepted, which every provider must support. Accepting the algorithm named by the token
+	// would allow it to choose a weaker one.
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("oidc: unsupported signing algorithm %q", header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := Claims{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := p.now()
+	switch {
+	case claims.String("iss") != p.metadata.Issuer:
+		return nil, fmt.Errorf("oidc: id token issued by %q", claims.String("iss"))
+	case !claims.audience(p.cfg.ClientID):
+		return nil, errors.New("oidc: id token not issued for this client")
+	case claims.String("nonce") != nonce:
+		return nil, errors.New("oidc: id token nonce does not match")
+	}
+	expires, ok := claims.time("exp")
+	if !ok || !now.Before(expires.Add(clockSkew)) {
+		return nil, errors.New("oidc: id token has expired")
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	decoded, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return ErrInvalidToken
+	}
+	if err := json.Unmarshal(decoded, v); err != nil {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Sign encodes and signs claims as an RS256 JSON Web Token. It is used by providers, such as the test provider.
+func Sign(key *rsa.PrivateKey, keyID string, claims Claims) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": keyID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
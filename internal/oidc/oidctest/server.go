@@ -0,0 +1,168 @@
+// Package oidctest provides an in-process OpenID Connect provider for tests.
+// Every authorization request is approved immediately, issuing an ID token with the configured claims.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"uploader/internal/oidc"
+)
+
+const (
+	ClientID     = "test-client"
+	ClientSecret = "test-secret"
+	keyID        = "test-key"
+)
+
+type Server struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// claims are added to the ID tokens issued, alongside the standard claims.
+	claims oidc.Claims
+	// codes holds the nonce and redirect URL of each unredeemed authorization code.
+	codes map[string]authorization
+}
+
+type authorization struct {
+	nonce       string
+	redirectURL string
+}
+
+func NewServer() *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{key: key, claims: oidc.Claims{}, codes: map[string]authorization{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.configuration)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	mux.HandleFunc("/jwks", s.jwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config returns a client configuration for the server.
+func (s *Server) Config(redirectURL string) oidc.Config {
+	return oidc.Config{Issuer: s.URL, ClientID: ClientID, ClientSecret: ClientSecret, RedirectURL: redirectURL}
+}
+
+// SetClaims replaces the claims added to subsequently issued ID tokens.
+func (s *Server) SetClaims(claims oidc.Claims) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.claims = claims
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	writeJSON(w, status, map[string]string{"error": code})
+}
+
+func (s *Server) configuration(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || query.Get("client_id") != ClientID || query.Get("response_type") != "code" {
+		writeError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+	code := encodedRandom()
+	s.mu.Lock()
+	s.codes[code] = authorization{nonce: query.Get("nonce"), redirectURL: redirect.String()}
+	s.mu.Unlock()
+
+	values := redirect.Query()
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	redirect.RawQuery = values.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != ClientID || secret != ClientSecret {
+		writeError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		writeError(w, http.StatusBadRequest, "unsupported_grant_type")
+		return
+	}
+	s.mu.Lock()
+	code := r.PostFormValue("code")
+	auth, found := s.codes[code]
+	delete(s.codes, code)
+	claims := oidc.Claims{}
+	for name, value := range s.claims {
+		claims[name] = value
+	}
+	s.mu.Unlock()
+	if !found || auth.redirectURL != r.PostFormValue("redirect_uri") {
+		writeError(w, http.StatusBadRequest, "invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	claims["iss"] = s.URL
+	claims["aud"] = ClientID
+	claims["iat"] = now.Unix()
+	claims["exp"] = now.Add(time.Hour).Unix()
+	claims["nonce"] = auth.nonce
+	if _, found := claims["sub"]; !found {
+		claims["sub"] = "test-subject"
+	}
+	idToken, err := oidc.Sign(s.key, keyID, claims)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": encodedRandom(),
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"keys": []oidc.JSONWebKey{oidc.NewJSONWebKey(keyID, &s.key.PublicKey)}})
+}
+
+// SignToken signs claims with the key of the server, for testing verification of tokens it did not issue itself.
+func (s *Server) SignToken(claims oidc.Claims) (string, error) {
+	return oidc.Sign(s.key, keyID, claims)
+}
+
+func encodedRandom() string {
+	value := make([]byte, 16)
+	rand.Read(value)
+	return base64.RawURLEncoding.EncodeToString(value)
+}
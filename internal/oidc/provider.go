@@ -0,0 +1,144 @@
+// Package oidc is a minimal OpenID Connect relying party, covering the authorization code flow used for browser login.
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Config struct {
+	// Issuer is the URL of the provider, from which its configuration is discovered.
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback the provider returns the user to after login.
+	RedirectURL string
+	// Scopes requested in addition to openid, defaulting to profile and email.
+	Scopes []string
+}
+
+// Error is an error response returned by the provider.
+type Error struct {
+	Status      int    `json:"-"`
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("oidc: %d %s: %s", e.Status, e.Code, e.Description)
+}
+
+// metadata is the subset of the provider configuration document used by the client.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	cfg      Config
+	metadata metadata
+	http     *http.Client
+	now      func() time.Time
+
+	mu   sync.Mutex
+	keys keySet
+}
+
+// Discover fetches the configuration of the provider at the configured issuer.
+func Discover(cfg Config) (*Provider, error) {
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		return nil, errors.New("oidc issuer and client id are required")
+	}
+	p := &Provider{cfg: cfg, http: http.DefaultClient, now: time.Now}
+	if len(p.cfg.Scopes) == 0 {
+		p.cfg.Scopes = []string{"profile", "email"}
+	}
+	if err := p.getJSON(strings.TrimSuffix(cfg.Issuer, "/")+"/.well-known/openid-configuration", &p.metadata); err != nil {
+		return nil, err
+	}
+	// The issuer must match exactly, as it is compared with the iss claim of every ID token.
+	if p.metadata.Issuer != cfg.Issuer {
+		return nil, fmt.Errorf("oidc: issuer %q does not match configured issuer %q", p.metadata.Issuer, cfg.Issuer)
+	}
+	if p.metadata.AuthorizationEndpoint == "" || p.metadata.TokenEndpoint == "" || p.metadata.JWKSURI == "" {
+		return nil, errors.New("oidc: provider configuration is missing required endpoints")
+	}
+	return p, nil
+}
+
+func (p *Provider) getJSON(target string, v any) error {
+	response, err := p.http.Get(target)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return &Error{Status: response.StatusCode, Code: "request_failed", Description: target}
+	}
+	return json.NewDecoder(response.Body).Decode(v)
+}
+
+// AuthCodeURL returns the URL the user is sent to for login. The state and nonce must be checked on the callback.
+func (p *Provider) AuthCodeURL(state, nonce string) string {
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {p.cfg.ClientID},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"scope":         {strings.Join(append([]string{"openid"}, p.cfg.Scopes...), " ")},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	separator := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + separator + query.Encode()
+}
+
+// Exchange redeems the authorization code returned to the callback, returning the claims of the verified ID token.
+func (p *Provider) Exchange(code, nonce string) (Claims, error) {
+	form := url.Values{
+		"grant_type":   {"authorization_code"},
+		"code":         {code},
+		"redirect_uri": {p.cfg.RedirectURL},
+	}
+	request, err := http.NewRequest(http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	response, err := p.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		providerErr := &Error{Status: response.StatusCode}
+		json.Unmarshal(body, providerErr)
+		return nil, providerErr
+	}
+	tokens := struct {
+		IDToken string `json:"id_token"`
+	}{}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: token response has no id token")
+	}
+	return p.Verify(tokens.IDToken, nonce)
+}
@@ -0,0 +1,113 @@
+package oidc_test
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"uploader/internal/oidc"
+	"uploader/internal/oidc/oidctest"
+)
+
+const redirectURL = "http://uploader.test/auth/callback"
+
+// authorize follows the login redirect to the test provider, returning the code it issues.
+func authorize(t *testing.T, provider *oidc.Provider, nonce string) string {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	response, err := client.Get(provider.AuthCodeURL("state", nonce))
+	if err != nil {
+		t.Fatalf("authorization request failed: %s", err)
+	}
+	response.Body.Close()
+	location, err := url.Parse(response.Header.Get("Location"))
+	if err != nil || response.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect to callback, got %d %q", response.StatusCode, response.Header.Get("Location"))
+	}
+	if location.Query().Get("state") != "state" {
+		t.Errorf("expected state to be returned, got %q", location.Query().Get("state"))
+	}
+	return location.Query().Get("code")
+}
+
+func TestProvider_Exchange(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	server.SetClaims(oidc.Claims{"preferred_username": "test_user"})
+	provider, err := oidc.Discover(server.Config(redirectURL))
+	if err != nil {
+		t.Fatalf("discovery failed: %s", err)
+	}
+
+	claims, err := provider.Exchange(authorize(t, provider, "nonce"), "nonce")
+	if err != nil {
+		t.Fatalf("exchange failed: %s", err)
+	}
+	if claims.String("preferred_username") != "test_user" {
+		t.Errorf("unexpected claims %v", claims)
+	}
+
+	if _, err := provider.Exchange(authorize(t, provider, "nonce"), "other"); err == nil {
+		t.Error("expected nonce mismatch to be rejected")
+	}
+	if _, err := provider.Exchange("unknown", "nonce"); err == nil {
+		t.Error("expected unknown code to be rejected")
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	provider, err := oidc.Discover(server.Config(redirectURL))
+	if err != nil {
+		t.Fatalf("discovery failed: %s", err)
+	}
+	valid := func() oidc.Claims {
+		return oidc.Claims{
+			"iss":   server.URL,
+			"aud":   oidctest.ClientID,
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"nonce": "nonce",
+		}
+	}
+	tests := map[string]struct {
+		modify func(claims oidc.Claims)
+		valid  bool
+	}{
+		"valid":          {func(oidc.Claims) {}, true},
+		"audience array": {func(c oidc.Claims) { c["aud"] = []string{"other", oidctest.ClientID} }, true},
+		"wrong issuer":   {func(c oidc.Claims) { c["iss"] = "https://attacker.test" }, false},
+		"wrong audience": {func(c oidc.Claims) { c["aud"] = "other" }, false},
+		"expired":        {func(c oidc.Claims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, false},
+		"missing expiry": {func(c oidc.Claims) { delete(c, "exp") }, false},
+		"nonce mismatch": {func(c oidc.Claims) { c["nonce"] = "other" }, false},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			claims := valid()
+			test.modify(claims)
+			token, err := server.SignToken(claims)
+			if err != nil {
+				t.Fatalf("failed to sign token: %s", err)
+			}
+			if _, err := provider.Verify(token, "nonce"); (err == nil) != test.valid {
+				t.Errorf("unexpected verification result, valid %t got %v", test.valid, err)
+			}
+		})
+	}
+
+	t.Run("tampered signature", func(t *testing.T) {
+		token, _ := server.SignToken(valid())
+		tampered := token[:len(token)-4] + "AAAA"
+		if _, err := provider.Verify(tampered, "nonce"); err == nil {
+			t.Error("expected tampered token to be rejected")
+		}
+	})
+	t.Run("unsigned token", func(t *testing.T) {
+		if _, err := provider.Verify("eyJhbGciOiJub25lIn0.e30.", "nonce"); err == nil {
+			t.Error("expected unsigned token to be rejected")
+		}
+	})
+}
@@ -0,0 +1,318 @@
+// Package s3 is a minimal client for S3 compatible object storage, covering the operations needed to store uploads.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Config struct {
+	Endpoint  string
+	Bucket    string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// PathStyle addresses the bucket as the first path segment instead of as a subdomain of the endpoint.
+	PathStyle bool
+	// Timeout limits connecting and waiting for a response, and the whole of every request other than a download,
+	// whose body is streamed for as long as it takes. DefaultTimeout is used if it is zero.
+	Timeout time.Duration
+}
+
+// DefaultTimeout limits requests when no timeout is configured.
+const DefaultTimeout = time.Minute
+
+type Client struct {
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	creds     Credentials
+	http      *http.Client
+	timeout   time.Duration
+	now       func() time.Time
+}
+
+// ErrNotFound is returned when the requested object or multipart upload does not exist.
+var ErrNotFound = errors.New("object not found")
+
+// Error is an error response returned by the object store.
+type Error struct {
+	Status  int    `xml:"-"`
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.Status, e.Code, e.Message)
+}
+
+func (e *Error) Is(target error) bool {
+	return target == ErrNotFound && (e.Code == "NoSuchKey" || e.Code == "NoSuchUpload" || e.Status == http.StatusNotFound)
+}
+
+type Part struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+func New(cfg Config) (*Client, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 endpoint and bucket are required")
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	region := cfg.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	timeout := cfg.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return &Client{
+		endpoint:  endpoint,
+		bucket:    cfg.Bucket,
+		pathStyle: cfg.PathStyle,
+		creds:     Credentials{AccessKey: cfg.AccessKey, SecretKey: cfg.SecretKey, Region: region},
+		http:      &http.Client{Transport: transport},
+		timeout:   timeout,
+		now:       time.Now,
+	}, nil
+}
+
+// objectURL returns the URL of the object, with the path encoded as it will be signed.
+func (c *Client) objectURL(key string, query url.Values) *url.URL {
+	target := *c.endpoint
+	path := "/" + key
+	if c.pathStyle {
+		path = "/" + c.bucket + path
+	} else {
+		target.Host = c.bucket + "." + target.Host
+	}
+	target.Path = strings.TrimSuffix(target.Path, "/") + path
+	target.RawPath = EscapePath(target.Path)
+	if query != nil {
+		target.RawQuery = canonicalQuery(query)
+	}
+	return &target
+}
+
+// do sends a signed request. Requests other than GET are cancelled once the timeout passes, GET responses are only
+// limited in how long their headers take, as their bodies are streamed to clients.
+func (c *Client) do(method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if method != http.MethodGet {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+	response, err := c.send(ctx, method, key, query, header, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	response.Body = &cancelBody{ReadCloser: response.Body, cancel: cancel}
+	return response, nil
+}
+
+// cancelBody releases the context of a request once its response has been read.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+func (c *Client) send(ctx context.Context, method, key string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	request, err := http.NewRequestWithContext(ctx, method, c.objectURL(key, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	request.ContentLength = int64(len(body))
+	c.creds.Sign(request, HashPayload(body), c.now())
+	response, err := c.http.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode >= 300 {
+		defer response.Body.Close()
+		return nil, readError(response)
+	}
+	return response, nil
+}
+
+func readError(response *http.Response) error {
+	s3Err := &Error{Status: response.StatusCode}
+	body, _ := io.ReadAll(io.LimitReader(response.Body, 64*1024))
+	if len(body) > 0 {
+		xml.Unmarshal(body, s3Err)
+	}
+	return s3Err
+}
+
+func (c *Client) PutObject(key string, body []byte) error {
+	response, err := c.do(http.MethodPut, key, nil, nil, body)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// GetObject returns the object contents starting at offset, along with the total size of the object.
+func (c *Client) GetObject(key string, offset int64) (io.ReadCloser, int64, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	response, err := c.do(http.MethodGet, key, nil, header, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := response.ContentLength
+	if contentRange := response.Header.Get("Content-Range"); contentRange != "" {
+		if i := strings.LastIndex(contentRange, "/"); i >= 0 {
+			size, err = strconv.ParseInt(contentRange[i+1:], 10, 64)
+			if err != nil {
+				response.Body.Close()
+				return nil, 0, fmt.Errorf("invalid content range %q", contentRange)
+			}
+		}
+	}
+	return response.Body, size, nil
+}
+
+// HeadObject returns the size of the object without fetching its contents.
+func (c *Client) HeadObject(key string) (int64, error) {
+	response, err := c.do(http.MethodHead, key, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+	return response.ContentLength, nil
+}
+
+// PresignGetObject returns a URL that allows the object to be downloaded without credentials until it expires.
+// The response header overrides, such as response-content-type, are included in the signature.
+func (c *Client) PresignGetObject(key string, expires time.Duration, overrides url.Values) string {
+	return c.creds.Presign(http.MethodGet, c.objectURL(key, overrides), expires, c.now()).String()
+}
+
+func (c *Client) DeleteObject(key string) error {
+	response, err := c.do(http.MethodDelete, key, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
+
+// ListObjects returns the keys of every object starting with prefix, following continuation tokens until the listing
+// is complete.
+func (c *Client) ListObjects(prefix string) ([]string, error) {
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		response, err := c.do(http.MethodGet, "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		result := struct {
+			Contents []struct {
+				Key string `xml:"Key"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}{}
+		err = xml.NewDecoder(response.Body).Decode(&result)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (c *Client) CreateMultipartUpload(key string) (string, error) {
+	response, err := c.do(http.MethodPost, key, url.Values{"uploads": {""}}, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	result := struct {
+		UploadID string `xml:"UploadId"`
+	}{}
+	if err := xml.NewDecoder(response.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	return result.UploadID, nil
+}
+
+func (c *Client) UploadPart(key, uploadID string, partNumber int, body []byte) (Part, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {uploadID}}
+	response, err := c.do(http.MethodPut, key, query, nil, body)
+	if err != nil {
+		return Part{}, err
+	}
+	response.Body.Close()
+	return Part{PartNumber: partNumber, ETag: response.Header.Get("ETag")}, nil
+}
+
+func (c *Client) CompleteMultipartUpload(key, uploadID string, parts []Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []Part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	response, err := c.do(http.MethodPost, key, url.Values{"uploadId": {uploadID}}, nil, body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	// A complete request may fail after the 200 status has been sent, in which case the body holds an error.
+	contents, err := io.ReadAll(response.Body)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(contents, []byte("<Error>")) {
+		s3Err := &Error{Status: response.StatusCode}
+		xml.Unmarshal(contents, s3Err)
+		return s3Err
+	}
+	return nil
+}
+
+func (c *Client) AbortMultipartUpload(key, uploadID string) error {
+	response, err := c.do(http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil, nil)
+	if err != nil {
+		return err
+	}
+	return response.Body.Close()
+}
@@ -0,0 +1,31 @@
+package s3
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClient_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	client, err := New(Config{Endpoint: server.URL, Bucket: "bucket", PathStyle: true, Timeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := client.PutObject("key", []byte("test")); err == nil {
+		t.Error("expected put to time out")
+	}
+	if _, _, err := client.GetObject("key", 0); err == nil {
+		t.Error("expected get to time out")
+	}
+}
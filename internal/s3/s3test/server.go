@@ -0,0 +1,248 @@
+// Package s3test provides an in-process S3 compatible server for tests.
+// It supports path style addressing of a single bucket and verifies request signatures.
+package s3test
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"uploader/internal/s3"
+)
+
+const (
+	AccessKey = "test-access-key"
+	SecretKey = "test-secret-key"
+	Region    = "us-east-1"
+)
+
+type Server struct {
+	*httptest.Server
+	Bucket string
+
+	mu        sync.Mutex
+	objects   map[string][]byte
+	multipart map[string]map[int][]byte
+	uploadID  int
+	// Requests counts the requests received by method.
+	Requests map[string]int
+	// ListPageSize is the number of keys listed in each response, defaulting to 1000 as S3 does.
+	ListPageSize int
+}
+
+func NewServer(bucket string) *Server {
+	s := &Server{
+		Bucket:    bucket,
+		objects:   map[string][]byte{},
+		multipart: map[string]map[int][]byte{},
+		Requests:  map[string]int{},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Config returns a client configuration for the server.
+func (s *Server) Config() s3.Config {
+	return s3.Config{
+		Endpoint:  s.URL,
+		Bucket:    s.Bucket,
+		Region:    Region,
+		AccessKey: AccessKey,
+		SecretKey: SecretKey,
+		PathStyle: true,
+	}
+}
+
+// Object returns the stored contents of an object.
+func (s *Server) Object(key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	contents, found := s.objects[key]
+	return contents, found
+}
+
+// Keys returns the sorted keys of all stored objects.
+func (s *Server) Keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// PendingUploads returns the number of multipart uploads that were neither completed nor aborted.
+func (s *Server) PendingUploads() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.multipart)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	if !s.authorized(r, body) {
+		writeError(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	prefix := "/" + s.Bucket + "/"
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		writeError(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	key := strings.TrimPrefix(r.URL.Path, prefix)
+	query := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Requests[r.Method]++
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.uploadID++
+		id := strconv.Itoa(s.uploadID)
+		s.multipart[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><Key>%s</Key><UploadId>%s</UploadId></InitiateMultipartUploadResult>", key, id)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		parts, found := s.multipart[query.Get("uploadId")]
+		if !found {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		number, _ := strconv.Atoi(query.Get("partNumber"))
+		parts[number] = body
+		w.Header().Set("ETag", fmt.Sprintf("\"%d\"", number))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		id := query.Get("uploadId")
+		parts, found := s.multipart[id]
+		if !found {
+			writeError(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		complete := struct {
+			Parts []s3.Part `xml:"Part"`
+		}{}
+		if err := xml.Unmarshal(body, &complete); err != nil {
+			writeError(w, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		contents := &bytes.Buffer{}
+		for _, part := range complete.Parts {
+			contents.Write(parts[part.PartNumber])
+		}
+		s.objects[key] = contents.Bytes()
+		delete(s.multipart, id)
+		fmt.Fprintf(w, "<CompleteMultipartUploadResult><Key>%s</Key></CompleteMultipartUploadResult>", key)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		delete(s.multipart, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		s.objects[key] = body
+	case r.Method == http.MethodGet && key == "" && query.Get("list-type") == "2":
+		s.list(w, query.Get("prefix"), query.Get("continuation-token"))
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		contents, found := s.objects[key]
+		if !found {
+			writeError(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		http.ServeContent(w, r, key, time.Time{}, bytes.NewReader(contents))
+	case r.Method == http.MethodDelete:
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// list writes a page of the sorted object keys after the continuation token, which is the last key of the previous page.
+func (s *Server) list(w http.ResponseWriter, prefix, token string) {
+	size := s.ListPageSize
+	if size <= 0 {
+		size = 1000
+	}
+	keys := make([]string, 0, len(s.objects))
+	for key := range s.objects {
+		if strings.HasPrefix(key, prefix) && key > token {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	result := struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []struct {
+			Key string `xml:"Key"`
+		} `xml:"Contents"`
+		IsTruncated           bool   `xml:"IsTruncated"`
+		NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	}{}
+	if len(keys) > size {
+		keys, result.IsTruncated = keys[:size], true
+		result.NextContinuationToken = keys[size-1]
+	}
+	for _, key := range keys {
+		result.Contents = append(result.Contents, struct {
+			Key string `xml:"Key"`
+		}{key})
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
+
+// authorized verifies either header or query string signatures.
+func (s *Server) authorized(r *http.Request, body []byte) bool {
+	creds := s3.Credentials{AccessKey: AccessKey, SecretKey: SecretKey, Region: Region}
+	query := r.URL.Query()
+	if signature := query.Get("X-Amz-Signature"); signature != "" {
+		now, err := time.Parse("20060102T150405Z", query.Get("X-Amz-Date"))
+		if err != nil {
+			return false
+		}
+		expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+		if err != nil || time.Now().After(now.Add(time.Duration(expires)*time.Second)) {
+			return false
+		}
+		signed := strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+		return creds.Signature(r, signed, s3.UnsignedPayload, now) == signature
+	}
+
+	authorization := r.Header.Get("Authorization")
+	fields := map[string]string{}
+	for _, field := range strings.Split(strings.TrimPrefix(authorization, "AWS4-HMAC-SHA256 "), ", ") {
+		if parts := strings.SplitN(field, "=", 2); len(parts) == 2 {
+			fields[parts[0]] = parts[1]
+		}
+	}
+	if !strings.HasPrefix(fields["Credential"], AccessKey+"/") {
+		return false
+	}
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash != s3.UnsignedPayload && payloadHash != s3.HashPayload(body) {
+		return false
+	}
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	return creds.Signature(r, signed, payloadHash, now) == fields["Signature"]
+}
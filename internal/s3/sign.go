@@ -0,0 +1,160 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// AWS Signature Version 4, as described in https://docs.aws.amazon.com/AmazonS3/latest/API/sig-v4-authenticating-requests.html
+
+const (
+	signAlgorithm   = "AWS4-HMAC-SHA256"
+	amzDateFormat   = "20060102T150405Z"
+	scopeDateFormat = "20060102"
+	service         = "s3"
+	// UnsignedPayload may be used as the payload hash when the body is not covered by the signature.
+	UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+type Credentials struct {
+	AccessKey string
+	SecretKey string
+	Region    string
+}
+
+// HashPayload returns the hex encoded SHA-256 of the payload, as used in the x-amz-content-sha256 header.
+func HashPayload(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign adds the x-amz-date, x-amz-content-sha256 and Authorization headers to the request.
+func (c Credentials) Sign(r *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	r.Header.Set("X-Amz-Date", now.Format(amzDateFormat))
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if r.Header.Get("Range") != "" {
+		signed = append(signed, "range")
+	}
+	if r.Header.Get("Content-Md5") != "" {
+		signed = append(signed, "content-md5")
+	}
+	sort.Strings(signed)
+	signature := c.Signature(r, signed, payloadHash, now)
+	r.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signAlgorithm, c.AccessKey, c.scope(now), strings.Join(signed, ";"), signature))
+}
+
+// Presign returns a copy of the URL carrying query string authentication, valid for the given duration.
+func (c Credentials) Presign(method string, target *url.URL, expires time.Duration, now time.Time) *url.URL {
+	now = now.UTC()
+	signedURL := *target
+	query := signedURL.Query()
+	query.Set("X-Amz-Algorithm", signAlgorithm)
+	query.Set("X-Amz-Credential", c.AccessKey+"/"+c.scope(now))
+	query.Set("X-Amz-Date", now.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", fmt.Sprintf("%d", int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	signedURL.RawQuery = canonicalQuery(query)
+	r := &http.Request{Method: method, URL: &signedURL, Host: signedURL.Host, Header: http.Header{}}
+	query.Set("X-Amz-Signature", c.Signature(r, []string{"host"}, UnsignedPayload, now))
+	signedURL.RawQuery = canonicalQuery(query)
+	return &signedURL
+}
+
+func (c Credentials) scope(now time.Time) string {
+	return fmt.Sprintf("%s/%s/%s/aws4_request", now.Format(scopeDateFormat), c.Region, service)
+}
+
+// Signature computes the request signature over the named, lower case headers.
+// It is exported so that test servers can verify requests made by the client.
+func (c Credentials) Signature(r *http.Request, signedHeaders []string, payloadHash string, now time.Time) string {
+	now = now.UTC()
+	var headers strings.Builder
+	for _, name := range signedHeaders {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+			if value == "" {
+				value = r.URL.Host
+			}
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	query := r.URL.Query()
+	query.Del("X-Amz-Signature")
+	canonical := strings.Join([]string{
+		r.Method,
+		EscapePath(r.URL.Path),
+		canonicalQuery(query),
+		headers.String(),
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := strings.Join([]string{
+		signAlgorithm,
+		now.Format(amzDateFormat),
+		c.scope(now),
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretKey), now.Format(scopeDateFormat))
+	key = hmacSHA256(key, c.Region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, toSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, escape(key, true)+"="+escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// EscapePath encodes a decoded URL path using the strict encoding required by Signature Version 4.
+func EscapePath(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return escape(path, false)
+}
+
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
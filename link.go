@@ -0,0 +1,199 @@
+package uploader
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"uploader/internal/auth"
+	"uploader/internal/responses"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Short links redirect to another URL from /l/{key}. Their keys are taken from the same key space as uploads, so
+// a key identifies either an upload or a link.
+
+const (
+	linkPath = "/l/"
+	// linkField is the form field holding the URL to shorten.
+	linkField = "url"
+	// maxLinkLength is the longest URL that can be shortened.
+	maxLinkLength = 4096
+)
+
+// ErrInvalidURL is returned when shortening a URL that is not an absolute http or https URL.
+var ErrInvalidURL = errors.New("url must be an absolute http or https url")
+
+type Link struct {
+	Key     string     `json:"key"`
+	User    string     `json:"user"`
+	Target  string     `json:"target"`
+	Created time.Time  `json:"created"`
+	Expires *time.Time `json:"expires,omitempty"`
+	// Clicks is the number of times the link has been followed.
+	Clicks int64 `json:"clicks"`
+}
+
+func (l *Link) Expired(now time.Time) bool {
+	return l.Expires != nil && !now.Before(*l.Expires)
+}
+
+type LinkMeta interface {
+	// LinkPut stores a link under a key reserved with FileKey.
+	LinkPut(link Link) error
+	LinkGet(key string) (*Link, error)
+	// LinkClick counts a click of the link, returning the updated link.
+	LinkClick(key string) (*Link, error)
+	// LinkDelete removes the link and releases its key.
+	LinkDelete(key string) error
+	// UserLinks returns the links created by the user, in key order.
+	UserLinks(user string) ([]Link, error)
+}
+
+// LinkInfo is the representation of a link returned to its owner, including its short URL.
+type LinkInfo struct {
+	Link
+	URL string `json:"url"`
+}
+
+func NewLinkInfo(link Link, base *url.URL) LinkInfo {
+	return LinkInfo{link, base.JoinPath(linkPath, link.Key).String()}
+}
+
+type LinkResponse struct {
+	responses.ResponseHeader
+	Results LinkInfo `json:"results"`
+}
+
+type LinkListResponse struct {
+	responses.ResponseHeader
+	Results []LinkInfo `json:"results"`
+}
+
+func validLink(target string) bool {
+	if len(target) > maxLinkLength {
+		return false
+	}
+	parsed, err := url.Parse(target)
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+func (u *uploadService) Shorten(target, user string, opts UploadOptions) (*Link, error) {
+	if !validLink(target) {
+		return nil, ErrInvalidURL
+	}
+	lifetime, err := u.expiry.Lifetime(user, opts.ExpiresIn)
+	if err != nil {
+		return nil, err
+	}
+	key, err := u.meta.FileKey()
+	if err != nil {
+		return nil, err
+	}
+	link := Link{Key: key, User: user, Target: target, Created: time.Now().UTC()}
+	if lifetime > 0 {
+		expires := link.Created.Add(lifetime)
+		link.Expires = &expires
+	}
+	if err := u.meta.LinkPut(link); err != nil {
+		u.abortUpload(key)
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (u *uploadService) Follow(key string) (*Link, error) {
+	link, err := u.meta.LinkGet(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	if link.Expired(time.Now()) {
+		return nil, ErrExpired
+	}
+	return u.meta.LinkClick(key)
+}
+
+func (u *uploadService) DeleteLink(key string, user *auth.User) error {
+	link, err := u.meta.LinkGet(key)
+	if errors.Is(err, ErrNotFound) {
+		return os.ErrNotExist
+	} else if err != nil {
+		return err
+	}
+	if !user.CanActAs(link.User) {
+		return ErrForbidden
+	}
+	return u.meta.LinkDelete(key)
+}
+
+func (u *uploadService) Links(user string) ([]Link, error) {
+	return u.meta.UserLinks(user)
+}
+
+func (u *Uploader) linkCreate(w http.ResponseWriter, r *http.Request) {
+	response := &LinkResponse{}
+	opts, err := uploadOptions(r)
+	if err != nil {
+		responses.Error(w, response, http.StatusBadRequest, -1005, err.Error())
+		return
+	}
+	link, err := u.us.Shorten(r.FormValue(linkField), pathUser(r), opts)
+	if errors.Is(err, ErrInvalidURL) {
+		responses.Error(w, response, http.StatusBadRequest, -1011, err.Error())
+		return
+	} else if err != nil {
+		uploadError(w, response, err)
+		return
+	}
+	response.Ok = true
+	response.Results = NewLinkInfo(*link, u.baseURL)
+	responses.Json(w, response, http.StatusCreated)
+}
+
+func (u *Uploader) linkList(w http.ResponseWriter, r *http.Request) {
+	response := &LinkListResponse{}
+	links, err := u.us.Links(pathUser(r))
+	if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	response.Ok = true
+	response.Results = make([]LinkInfo, 0, len(links))
+	for _, link := range links {
+		response.Results = append(response.Results, NewLinkInfo(link, u.baseURL))
+	}
+	responses.Json(w, response, http.StatusOK)
+}
+
+func (u *Uploader) linkDelete(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	if err := u.us.DeleteLink(chi.URLParam(r, "key"), auth.AuthUser(r.Context())); err != nil {
+		deleteError(w, response, err)
+		return
+	}
+	response.Results = true
+	responses.Json(w, response, 200)
+}
+
+func (u *Uploader) linkFollow(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	link, err := u.us.Follow(chi.URLParam(r, "key"))
+	if errors.Is(err, os.ErrNotExist) {
+		responses.Error(w, response, 404, -1004, "link not found")
+		return
+	} else if errors.Is(err, ErrExpired) {
+		responses.Error(w, response, http.StatusGone, -1006, "link has expired")
+		return
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	// Temporary redirects are not cached, so every click reaches the uploader and is counted.
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, link.Target, http.StatusFound)
+}
@@ -0,0 +1,160 @@
+package uploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"uploader/internal/auth"
+)
+
+func linkRequest(method, target, token string, form url.Values) *http.Request {
+	request := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", token))
+	return request
+}
+
+func TestUploadService_Links(t *testing.T) {
+	meta := newTestMeta()
+	service := NewUploadService(meta, newMemoryFileStore())
+
+	for _, target := range []string{"", "example.com", "/relative", "ftp://example.com/file", "javascript:alert(1)", "https://" + strings.Repeat("a", maxLinkLength)} {
+		if _, err := service.Shorten(target, "test_user", UploadOptions{}); !errors.Is(err, ErrInvalidURL) {
+			t.Errorf("expected %q to be rejected, got %v", target, err)
+		}
+	}
+
+	link, err := service.Shorten("https://example.com/page?q=1", "test_user", UploadOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error shortening url: %s", err)
+	}
+	if link.Key != "1" || link.Expires != nil {
+		t.Errorf("unexpected link %+v", link)
+	}
+	for i := int64(1); i <= 2; i++ {
+		followed, err := service.Follow(link.Key)
+		if err != nil {
+			t.Fatalf("unexpected error following link: %s", err)
+		}
+		if followed.Clicks != i || followed.Target != "https://example.com/page?q=1" {
+			t.Errorf("unexpected followed link %+v", followed)
+		}
+	}
+
+	other := &auth.User{Name: "other_user", Role: auth.RoleUser}
+	if err := service.DeleteLink(link.Key, other); !errors.Is(err, ErrForbidden) {
+		t.Errorf("expected other user to be forbidden, got %v", err)
+	}
+	if err := service.DeleteLink(link.Key, &auth.User{Name: "test_user", Role: auth.RoleUser}); err != nil {
+		t.Fatalf("unexpected error deleting link: %s", err)
+	}
+	if _, err := service.Follow(link.Key); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected deleted link to be gone, got %v", err)
+	}
+
+	service.expiry = ExpiryPolicy{ExpiryLimits: ExpiryLimits{Default: time.Hour}}
+	expiring, _ := service.Shorten("https://example.com", "test_user", UploadOptions{})
+	if expiring.Expires == nil {
+		t.Fatal("expected default expiry to apply to links")
+	}
+	if removed, err := service.ReapExpired(expiring.Expires.Add(time.Second)); err != nil || removed != 1 {
+		t.Errorf("expected expired link to be reaped, got %d %v", removed, err)
+	}
+	if _, found := meta.links[expiring.Key]; found {
+		t.Error("expected reaped link to be removed")
+	}
+}
+
+func TestLinkHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	other, _ := meta.UserRegister("other_user")
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, linkRequest(http.MethodPost, "/uploads/test_user/links", user.AuthToken, url.Values{"url": {"https://example.com/"}}))
+	assertStatusCode(t, response, http.StatusCreated)
+	created := LinkResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&created); err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if created.Results.URL != "http://localhost/l/1" || created.Results.Target != "https://example.com/" {
+		t.Errorf("unexpected link %+v", created.Results)
+	}
+
+	t.Run("follow", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/l/1", nil))
+		assertStatusCode(t, response, http.StatusFound)
+		if location := response.Header().Get("Location"); location != "https://example.com/" {
+			t.Errorf("expected redirect to target, got %q", location)
+		}
+		if meta.links["1"].Clicks != 1 {
+			t.Errorf("expected click to be counted, got %d", meta.links["1"].Clicks)
+		}
+	})
+	t.Run("list", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, linkRequest(http.MethodGet, "/uploads/test_user/links", user.AuthToken, nil))
+		assertStatusCode(t, response, http.StatusOK)
+		list := LinkListResponse{}
+		if err := json.NewDecoder(response.Body).Decode(&list); err != nil {
+			t.Fatalf("failed to decode response: %s", err)
+		}
+		if len(list.Results) != 1 || list.Results[0].Clicks != 1 {
+			t.Errorf("unexpected links %+v", list.Results)
+		}
+	})
+	t.Run("invalid url", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, linkRequest(http.MethodPost, "/uploads/test_user/links", user.AuthToken, url.Values{"url": {"not a url"}}))
+		assertStatusCode(t, response, http.StatusBadRequest)
+	})
+	t.Run("other user", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, linkRequest(http.MethodPost, "/uploads/test_user/links", other.AuthToken, url.Values{"url": {"https://example.com/"}}))
+		assertStatusCode(t, response, http.StatusForbidden)
+	})
+	t.Run("expired", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		meta.links["expired"] = &Link{Key: "expired", User: "test_user", Target: "https://example.com/", Expires: &past}
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/l/expired", nil))
+		assertStatusCode(t, response, http.StatusGone)
+	})
+	t.Run("unknown", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/l/unknown", nil))
+		assertStatusCode(t, response, http.StatusNotFound)
+	})
+	t.Run("delete", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, linkRequest(http.MethodDelete, "/uploads/test_user/links/1", user.AuthToken, nil))
+		assertStatusCode(t, response, http.StatusOK)
+		response = httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/l/1", nil))
+		assertStatusCode(t, response, http.StatusNotFound)
+	})
+	t.Run("script", func(t *testing.T) {
+		script, err := uploader.UploadScript(user, ScriptShareXShortener)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		shortener := UploadScript{}
+		if err := json.Unmarshal([]byte(script), &shortener); err != nil {
+			t.Fatalf("shortener script is not valid json: %s", err)
+		}
+		if shortener.DestinationType != "URLShortener" || shortener.RequestURL != "http://localhost/uploads/test_user/links" ||
+			shortener.Arguments["url"] != "$input$" || shortener.URL != "$json:results.url$" {
+			t.Errorf("unexpected shortener script %+v", shortener)
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package uploader
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	SortTime = "time"
+	SortSize = "size"
+
+	defaultListLimit = 50
+	maxListLimit     = 1000
+)
+
+var (
+	ErrInvalidCursor = errors.New("invalid cursor")
+	ErrInvalidSort   = errors.New("invalid sort field")
+)
+
+// ListOptions controls the filtering, ordering and pagination of an upload listing.
+type ListOptions struct {
+	// Sort is either SortTime or SortSize, defaulting to SortTime.
+	Sort      string
+	Ascending bool
+	Limit     int
+	// Cursor is the NextCursor of the previous page, or empty for the first page.
+	Cursor string
+	// ContentType matches uploads whose content type starts with the value, such as "image/".
+	ContentType string
+	// Filename matches uploads whose filename contains the value, ignoring case.
+	Filename string
+}
+
+type UploadList struct {
+	Uploads    []UploadDetails `json:"uploads"`
+	NextCursor string          `json:"next_cursor"`
+}
+
+// listCursor identifies the last upload of a page. The key breaks ties between uploads with the same sort value.
+type listCursor struct {
+	Time time.Time `json:"t,omitempty"`
+	Size int64     `json:"s,omitempty"`
+	Key  string    `json:"k"`
+}
+
+func (c listCursor) encode() string {
+	value, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+func decodeCursor(cursor string) (*listCursor, error) {
+	value, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := &listCursor{}
+	if err := json.Unmarshal(value, c); err != nil || c.Key == "" {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// less orders uploads by the sort field, then by key.
+func (o ListOptions) less(a, b listCursor) bool {
+	if o.Sort == SortSize && a.Size != b.Size {
+		return (a.Size < b.Size) == o.Ascending
+	}
+	if o.Sort != SortSize && !a.Time.Equal(b.Time) {
+		return a.Time.Before(b.Time) == o.Ascending
+	}
+	if a.Key == b.Key {
+		return false
+	}
+	return (a.Key < b.Key) == o.Ascending
+}
+
+func (o ListOptions) matches(details *UploadDetails) bool {
+	if o.ContentType != "" && !strings.HasPrefix(details.ContentType, o.ContentType) {
+		return false
+	}
+	if o.Filename != "" && !strings.Contains(strings.ToLower(details.Filename), strings.ToLower(o.Filename)) {
+		return false
+	}
+	return true
+}
+
+func cursorOf(details *UploadDetails) listCursor {
+	return listCursor{Time: details.Uploaded, Size: details.Size, Key: details.Key}
+}
+
+func (u *uploadService) List(user string, opts ListOptions) (*UploadList, error) {
+	if opts.Sort != "" && opts.Sort != SortTime && opts.Sort != SortSize {
+		return nil, ErrInvalidSort
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = defaultListLimit
+	} else if opts.Limit > maxListLimit {
+		opts.Limit = maxListLimit
+	}
+	var after *listCursor
+	if opts.Cursor != "" {
+		var err error
+		if after, err = decodeCursor(opts.Cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	files, err := u.meta.UserFiles(user)
+	if err != nil {
+		return nil, err
+	}
+	list := &UploadList{Uploads: []UploadDetails{}}
+	for i := range files {
+		if !opts.matches(&files[i]) {
+			continue
+		}
+		if after != nil && !opts.less(*after, cursorOf(&files[i])) {
+			continue
+		}
+		list.Uploads = append(list.Uploads, files[i])
+	}
+	sort.Slice(list.Uploads, func(i, j int) bool {
+		return opts.less(cursorOf(&list.Uploads[i]), cursorOf(&list.Uploads[j]))
+	})
+	if len(list.Uploads) > opts.Limit {
+		list.Uploads = list.Uploads[:opts.Limit]
+		list.NextCursor = cursorOf(&list.Uploads[opts.Limit-1]).encode()
+	}
+	return list, nil
+}
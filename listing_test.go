@@ -0,0 +1,126 @@
+package uploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"uploader/internal/auth"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func seedListing(meta *testMeta) {
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	files := []UploadDetails{
+		{Key: "a", Filename: "cat.png", ContentType: "image/png", Size: 300, Uploaded: base.Add(1 * time.Hour)},
+		{Key: "b", Filename: "notes.txt", ContentType: "text/plain", Size: 100, Uploaded: base.Add(2 * time.Hour)},
+		{Key: "c", Filename: "Dog.PNG", ContentType: "image/png", Size: 200, Uploaded: base.Add(3 * time.Hour)},
+		{Key: "d", Filename: "clip.gif", ContentType: "image/gif", Size: 200, Uploaded: base.Add(4 * time.Hour)},
+	}
+	for _, file := range files {
+		file.User = "test_user"
+		file.DeleteKey = "delete"
+		meta.FilePut(file)
+	}
+	meta.FilePut(UploadDetails{Key: "e", User: "other_user", Uploaded: base})
+}
+
+func listKeys(list *UploadList) []string {
+	keys := []string{}
+	for _, upload := range list.Uploads {
+		keys = append(keys, upload.Key)
+	}
+	return keys
+}
+
+func TestUploadService_List(t *testing.T) {
+	meta := newTestMeta()
+	seedListing(meta)
+	service := NewUploadService(meta, newMemoryFileStore())
+
+	tests := map[string]struct {
+		opts ListOptions
+		want []string
+	}{
+		"newest first":      {ListOptions{}, []string{"d", "c", "b", "a"}},
+		"oldest first":      {ListOptions{Ascending: true}, []string{"a", "b", "c", "d"}},
+		"largest first":     {ListOptions{Sort: SortSize}, []string{"a", "d", "c", "b"}},
+		"smallest first":    {ListOptions{Sort: SortSize, Ascending: true}, []string{"b", "c", "d", "a"}},
+		"content type":      {ListOptions{ContentType: "image/"}, []string{"d", "c", "a"}},
+		"filename":          {ListOptions{Filename: "png"}, []string{"c", "a"}},
+		"type and filename": {ListOptions{ContentType: "image/gif", Filename: "clip"}, []string{"d"}},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			list, err := service.List("test_user", test.opts)
+			if err != nil {
+				t.Fatalf("unexpected error listing uploads %s", err)
+			}
+			if diff := cmp.Diff(listKeys(list), test.want); diff != "" {
+				t.Errorf("unexpected listing %s", diff)
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		var pages [][]string
+		opts := ListOptions{Sort: SortSize, Limit: 3}
+		for {
+			list, err := service.List("test_user", opts)
+			if err != nil {
+				t.Fatalf("unexpected error listing uploads %s", err)
+			}
+			pages = append(pages, listKeys(list))
+			if list.NextCursor == "" {
+				break
+			}
+			opts.Cursor = list.NextCursor
+		}
+		if diff := cmp.Diff(pages, [][]string{{"a", "d", "c"}, {"b"}}); diff != "" {
+			t.Errorf("unexpected pages %s", diff)
+		}
+	})
+	t.Run("invalid cursor", func(t *testing.T) {
+		if _, err := service.List("test_user", ListOptions{Cursor: "!!"}); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("expected invalid cursor error, got %v", err)
+		}
+	})
+}
+
+func TestUploadListHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	seedListing(meta)
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	request := httptest.NewRequest(http.MethodGet, "/uploads/test_user?sort=size&order=asc&limit=1&type=image/", nil)
+	request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", user.AuthToken))
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+
+	assertStatusCode(t, response, http.StatusOK)
+	assertJSONResponse(t, response)
+	decoded := &UploadListResponse{}
+	if err := json.Unmarshal(response.Body.Bytes(), decoded); err != nil {
+		t.Fatalf("failed to decode response %s", err)
+	}
+	assertJSONNoErrors(t, decoded.ResponseHeader)
+	if len(decoded.Results.Uploads) != 1 {
+		t.Fatalf("expected one upload, got %d", len(decoded.Results.Uploads))
+	}
+	upload := decoded.Results.Uploads[0]
+	if upload.Key != "c" || upload.URL != "http://localhost/files/c" {
+		t.Errorf("unexpected upload %+v", upload)
+	}
+	if upload.DeleteURL != "http://localhost/uploads/test_user/c/delete/delete" {
+		t.Errorf("unexpected delete url %s", upload.DeleteURL)
+	}
+	if decoded.Results.NextCursor == "" {
+		t.Error("expected a next cursor")
+	}
+}
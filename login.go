@@ -0,0 +1,191 @@
+package uploader
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"uploader/internal/auth"
+	"uploader/internal/oidc"
+	"uploader/internal/responses"
+)
+
+// Browser login, either with an API token or through an OpenID Connect provider. A successful login sets a session
+// cookie, which is accepted by the same routes as bearer tokens. Users logging in through the provider are registered
+// on their first login, and bound to the issuer and subject of their ID token. Only that identity may log in as them,
+// so a provider can not be used to log in as a user registered any other way.
+
+const (
+	loginCookieName   = "uploader_login"
+	loginCallbackPath = "/auth/callback"
+	// loginTimeout is how long a user has to complete login at the provider.
+	loginTimeout = 10 * time.Minute
+	// tokenField is the form field holding the API token for token login.
+	tokenField = "token"
+	// DefaultUsernameClaim is the ID token claim used as the username when none is configured. The subject is used
+	// as it is the one claim providers never let users change.
+	DefaultUsernameClaim = "sub"
+)
+
+// validUsername matches names that are safe to use as a path segment.
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._@-]{0,63}$`)
+
+type loginHandler struct {
+	// provider is nil when OpenID Connect login is not configured, leaving only token login.
+	provider *oidc.Provider
+	sessions *auth.Sessions
+	users    auth.Store
+	// usernameClaim is the ID token claim holding the username.
+	usernameClaim string
+	// home is where users are sent after logging in or out.
+	home string
+	// failed is called with each rejected token login unless it is nil.
+	failed auth.FailureFunc
+}
+
+func newLoginHandler(provider *oidc.Provider, sessions *auth.Sessions, users auth.Store, usernameClaim, home string) *loginHandler {
+	if usernameClaim == "" {
+		usernameClaim = DefaultUsernameClaim
+	}
+	return &loginHandler{provider: provider, sessions: sessions, users: users, usernameClaim: usernameClaim, home: home}
+}
+
+// newTokenLogin returns a login handler offering only token login, with sessions that end when the server restarts.
+func newTokenLogin(base *url.URL, users auth.Store) *loginHandler {
+	sessions, err := auth.NewSessions(nil, 0)
+	if err != nil {
+		panic(err)
+	}
+	sessions.Secure = base.Scheme == "https"
+	return newLoginHandler(nil, sessions, users, "", base.JoinPath("/").String())
+}
+
+func loginError(w http.ResponseWriter, status int, message string) {
+	responses.Error(w, &responses.BaseResponse{}, status, auth.CodeAuthFailed, message)
+}
+
+// login sends the user to the provider, remembering the state and nonce of the request in a short lived cookie.
+func (l *loginHandler) login(w http.ResponseWriter, r *http.Request) {
+	state, err := randSecKey()
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	nonce, err := randSecKey()
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     loginCookieName,
+		Value:    state + "." + nonce,
+		Path:     "/auth/",
+		MaxAge:   int(loginTimeout / time.Second),
+		Secure:   l.sessions.Secure,
+		HttpOnly: true,
+		// The provider returns the user with a top level navigation, which includes lax cookies.
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, l.provider.AuthCodeURL(state, nonce), http.StatusFound)
+}
+
+func (l *loginHandler) callback(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(loginCookieName)
+	http.SetCookie(w, &http.Cookie{Name: loginCookieName, Path: "/auth/", MaxAge: -1})
+	if err != nil {
+		loginError(w, http.StatusBadRequest, "login has expired, please try again")
+		return
+	}
+	state, nonce, _ := strings.Cut(cookie.Value, ".")
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		loginError(w, http.StatusBadRequest, "login state does not match")
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		loginError(w, http.StatusUnauthorized, "login failed: "+providerErr)
+		return
+	}
+	claims, err := l.provider.Exchange(query.Get("code"), nonce)
+	if err != nil {
+		log.Printf("oidc login failed: %s", err)
+		loginError(w, http.StatusUnauthorized, "login failed")
+		return
+	}
+	name := claims.String(l.usernameClaim)
+	if !validUsername.MatchString(name) {
+		loginError(w, http.StatusForbidden, "identity provider did not supply a valid username")
+		return
+	}
+	identity := auth.Identity{Issuer: claims.String("iss"), Subject: claims.String("sub")}
+	if identity.Subject == "" {
+		loginError(w, http.StatusForbidden, "identity provider did not supply a subject")
+		return
+	}
+	user, err := l.users.UserByName(name)
+	if errors.Is(err, auth.NotFoundError) {
+		user, err = l.users.UserRegisterIdentity(name, identity)
+	}
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	if user.Identity == nil || *user.Identity != identity {
+		log.Printf("oidc login as %q refused for subject %q of %q", name, identity.Subject, identity.Issuer)
+		responses.Error(w, &responses.BaseResponse{}, http.StatusForbidden, auth.CodeForbidden,
+			"User was not registered through this identity provider")
+		return
+	}
+	if user.Disabled {
+		responses.Error(w, &responses.BaseResponse{}, http.StatusForbidden, auth.CodeForbidden, "User is disabled")
+		return
+	}
+	if err := l.sessions.Issue(w, user.Name); err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	http.Redirect(w, r, l.home, http.StatusFound)
+}
+
+// tokenLogin starts a session for the owner of the token in the submitted form. Sessions permit every operation, so
+// tokens limited to some scopes are refused.
+func (l *loginHandler) tokenLogin(w http.ResponseWriter, r *http.Request) {
+	page := loginPage{OIDC: l.provider != nil}
+	user, err := l.users.UserByAuthToken(strings.TrimSpace(r.PostFormValue(tokenField)))
+	switch {
+	case errors.Is(err, auth.DisabledError):
+		l.fail(r, err)
+		page.Error = "User is disabled"
+		renderPage(w, http.StatusForbidden, "login.html", page)
+	case err != nil:
+		l.fail(r, auth.InvalidTokenError)
+		page.Error = "Access token is invalid"
+		renderPage(w, http.StatusUnauthorized, "login.html", page)
+	case len(user.Scopes) > 0:
+		page.Error = fmt.Sprintf("Token is limited to %s and can not be used to log in", auth.FormatScopes(user.Scopes))
+		renderPage(w, http.StatusForbidden, "login.html", page)
+	default:
+		if err := l.sessions.Issue(w, user.Name); err != nil {
+			responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+			return
+		}
+		http.Redirect(w, r, l.home, http.StatusSeeOther)
+	}
+}
+
+func (l *loginHandler) fail(r *http.Request, reason error) {
+	if l.failed != nil {
+		l.failed(r, reason)
+	}
+}
+
+func (l *loginHandler) logout(w http.ResponseWriter, r *http.Request) {
+	l.sessions.Clear(w)
+	http.Redirect(w, r, l.home, http.StatusFound)
+}
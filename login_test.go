@@ -0,0 +1,148 @@
+package uploader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"uploader/internal/auth"
+	"uploader/internal/oidc"
+	"uploader/internal/oidc/oidctest"
+)
+
+func newTestLogin(t *testing.T, server *oidctest.Server, meta MetaStore) *loginHandler {
+	t.Helper()
+	provider, err := oidc.Discover(server.Config(baseURL.JoinPath(loginCallbackPath).String()))
+	if err != nil {
+		t.Fatalf("discovery failed: %s", err)
+	}
+	sessions, err := auth.NewSessions(nil, 0)
+	if err != nil {
+		t.Fatalf("failed to create sessions: %s", err)
+	}
+	return newLoginHandler(provider, sessions, meta, "", "/")
+}
+
+// loginFlow logs in through the test provider, returning the final response of the callback.
+func loginFlow(t *testing.T, uploader http.Handler) *httptest.ResponseRecorder {
+	t.Helper()
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/auth/login", nil))
+	assertStatusCode(t, response, http.StatusFound)
+	loginCookie := response.Result().Cookies()[0]
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	providerResponse, err := client.Get(response.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("authorization request failed: %s", err)
+	}
+	providerResponse.Body.Close()
+	callback, err := url.Parse(providerResponse.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid callback location: %s", err)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	request.AddCookie(loginCookie)
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	return response
+}
+
+func sessionCookie(response *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == auth.SessionCookieName {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestLoginHTTP(t *testing.T) {
+	server := oidctest.NewServer()
+	defer server.Close()
+	meta := newTestMeta()
+	uploader := newUploaderHTTP(baseURL, meta, newMemoryFileStore(), NewUploadService(meta, newMemoryFileStore()), newTestLogin(t, server, meta))
+
+	server.SetClaims(oidc.Claims{"sub": "test_user"})
+	response := loginFlow(t, uploader)
+	assertStatusCode(t, response, http.StatusFound)
+	cookie := sessionCookie(response)
+	if cookie == nil {
+		t.Fatal("expected login to set a session cookie")
+	}
+	if user, err := meta.UserByName("test_user"); err != nil {
+		t.Errorf("expected user to be registered on first login, got %s", err)
+	} else if want := (auth.Identity{Issuer: server.URL, Subject: "test_user"}); user.Identity == nil || *user.Identity != want {
+		t.Errorf("expected user to be bound to %+v, got %+v", want, user.Identity)
+	}
+
+	t.Run("session authenticates", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/uploads/test_user", nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusOK)
+	})
+	t.Run("session limited to user", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/uploads/other_user", nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusForbidden)
+	})
+	t.Run("forged session", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/uploads/test_user", nil)
+		request.AddCookie(&http.Cookie{Name: auth.SessionCookieName, Value: cookie.Value + "x"})
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusUnauthorized)
+	})
+	t.Run("disabled user", func(t *testing.T) {
+		meta.UserSetDisabled("test_user", true)
+		defer meta.UserSetDisabled("test_user", false)
+		request := httptest.NewRequest(http.MethodGet, "/uploads/test_user", nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusForbidden)
+	})
+	t.Run("second login", func(t *testing.T) {
+		response := loginFlow(t, uploader)
+		assertStatusCode(t, response, http.StatusFound)
+		if users, _ := meta.UserList(); len(users) != 1 {
+			t.Errorf("expected existing user to be reused, got %d users", len(users))
+		}
+	})
+	t.Run("invalid username", func(t *testing.T) {
+		server.SetClaims(oidc.Claims{"sub": "../admin"})
+		response := loginFlow(t, uploader)
+		assertStatusCode(t, response, http.StatusForbidden)
+	})
+	t.Run("user registered from the CLI", func(t *testing.T) {
+		meta.UserRegister("admin")
+		meta.UserSetRole("admin", auth.RoleAdmin)
+		server.SetClaims(oidc.Claims{"sub": "admin"})
+		response := loginFlow(t, uploader)
+		assertStatusCode(t, response, http.StatusForbidden)
+		if sessionCookie(response) != nil {
+			t.Error("expected no session for a user registered from the CLI")
+		}
+	})
+	t.Run("other subject with the same username", func(t *testing.T) {
+		login := newTestLogin(t, server, meta)
+		login.usernameClaim = "preferred_username"
+		uploader := newUploaderHTTP(baseURL, meta, newMemoryFileStore(), NewUploadService(meta, newMemoryFileStore()), login)
+		server.SetClaims(oidc.Claims{"sub": "attacker", "preferred_username": "test_user"})
+		response := loginFlow(t, uploader)
+		assertStatusCode(t, response, http.StatusForbidden)
+	})
+	t.Run("state mismatch", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, loginCallbackPath+"?code=abc&state=wrong", nil)
+		request.AddCookie(&http.Cookie{Name: loginCookieName, Value: "state.nonce"})
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusBadRequest)
+	})
+}
@@ -1,10 +1,15 @@
 package uploader
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"time"
 
 	"uploader/internal/auth"
 
@@ -25,6 +30,9 @@ type MetaStore interface {
 	DeleteKey() (string, error)
 
 	UploadMeta
+	PartialMeta
+	LinkMeta
+	AuditMeta
 }
 
 type BoltStore struct {
@@ -36,10 +44,19 @@ const (
 	bucketUsers       = "user"
 	bucketUserUploads = "user_upload"
 	bucketUpload      = "upload"
+	bucketPartial     = "partial_upload"
+	bucketExpiry      = "upload_expiry"
+	bucketBlob        = "blob"
+	bucketUsage       = "user_usage"
+	bucketLink        = "link"
+	bucketUserLinks   = "user_link"
+	bucketDataKey     = "data_key"
+	bucketAudit       = "audit"
+	bucketDownloads   = "download"
 )
 
 var (
-	bucketList   = []string{bucketAuth, bucketUsers, bucketUserUploads, bucketUpload}
+	bucketList   = []string{bucketAuth, bucketUsers, bucketUserUploads, bucketUpload, bucketPartial, bucketExpiry, bucketBlob, bucketUsage, bucketLink, bucketUserLinks, bucketDataKey, bucketAudit, bucketDownloads}
 	ErrDuplicate = errors.New("duplicate key")
 	ErrNotFound  = errors.New("key not found")
 )
@@ -50,11 +67,28 @@ func NewBoltStore(path string) (*BoltStore, error) {
 		return nil, err
 	}
 	if err = db.Update(func(tx *bbolt.Tx) error {
+		// Databases created before usage tracking have their totals computed from the existing uploads.
+		rebuild := tx.Bucket([]byte(bucketUsage)) == nil
 		for _, bucket := range bucketList {
 			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
 				return err
 			}
 		}
+		if err := migrateTokens(tx); err != nil {
+			return err
+		}
+		if rebuild {
+			return tx.Bucket([]byte(bucketUpload)).ForEach(func(k, v []byte) error {
+				if len(v) == 0 {
+					return nil
+				}
+				upload := &UploadDetails{}
+				if err := json.Unmarshal(v, upload); err != nil || !upload.Committed() {
+					return err
+				}
+				return addUsage(tx, upload.User, upload.Size, 1)
+			})
+		}
 		return nil
 	}); err != nil {
 		return nil, err
@@ -153,30 +187,994 @@ func (b *BoltStore) putJsonNoDupe(bucket, key string, value any) error {
 	})
 }
 
+// FilePut stores the upload details. Committed uploads are added to the index of their user, the expiry index and the
+// usage totals, and uploads that are no longer committed are removed from them.
 func (b *BoltStore) FilePut(upload UploadDetails) error {
-	return b.putJson(bucketUpload, upload.Key, upload)
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		value, err := json.Marshal(upload)
+		if err != nil {
+			return err
+		}
+		uploads := tx.Bucket([]byte(bucketUpload))
+		if previous := uploads.Get([]byte(upload.Key)); len(previous) > 0 {
+			if err := unindexExpiry(tx, previous); err != nil {
+				return err
+			}
+			if err := unindexUsage(tx, previous); err != nil {
+				return err
+			}
+		}
+		if err := uploads.Put([]byte(upload.Key), value); err != nil {
+			return err
+		}
+		index := tx.Bucket([]byte(bucketUserUploads)).Bucket([]byte(upload.User))
+		if !upload.Committed() {
+			if index == nil {
+				return nil
+			}
+			return index.Delete([]byte(upload.Key))
+		}
+		if err := addUsage(tx, upload.User, upload.Size, 1); err != nil {
+			return err
+		}
+		if upload.Expires != nil {
+			if err := tx.Bucket([]byte(bucketExpiry)).Put(expiryKey(*upload.Expires, upload.Key), []byte(upload.Key)); err != nil {
+				return err
+			}
+		}
+		if upload.User == "" {
+			return nil
+		}
+		index, err = tx.Bucket([]byte(bucketUserUploads)).CreateBucketIfNotExists([]byte(upload.User))
+		if err != nil {
+			return err
+		}
+		return index.Put([]byte(upload.Key), []byte{})
+	})
 }
 
+// FileGet returns the upload details of the key. Keys reserved by FileKey but not holding an upload, including the keys
+// of links, and uploads that are not committed are not found.
 func (b *BoltStore) FileGet(key string) (*UploadDetails, error) {
 	upload := &UploadDetails{}
-	return upload, b.getJson(bucketUpload, key, upload)
+	return upload, b.db.View(func(tx *bbolt.Tx) error {
+		v := tx.Bucket([]byte(bucketUpload)).Get([]byte(key))
+		if len(v) == 0 {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, upload); err != nil {
+			return err
+		}
+		if !upload.Committed() {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// FileDownload takes one of the downloads left of a limited upload and returns the updated details. Uploads without a
+// download limit are returned unchanged, and ErrNoDownloadsLeft is returned once every download has been taken.
+func (b *BoltStore) FileDownload(key string) (*UploadDetails, error) {
+	upload := &UploadDetails{}
+	return upload, b.db.Update(func(tx *bbolt.Tx) error {
+		uploads := tx.Bucket([]byte(bucketUpload))
+		v := uploads.Get([]byte(key))
+		if len(v) == 0 {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, upload); err != nil {
+			return err
+		}
+		if !upload.Committed() {
+			return ErrNotFound
+		}
+		if upload.DownloadsLeft == nil {
+			return nil
+		}
+		if *upload.DownloadsLeft <= 0 {
+			return ErrNoDownloadsLeft
+		}
+		*upload.DownloadsLeft--
+		value, err := json.Marshal(upload)
+		if err != nil {
+			return err
+		}
+		return uploads.Put([]byte(key), value)
+	})
 }
 
+// FileDelete removes the upload details and the entry in the user index, in any state.
+// Placeholders reserved by FileKey are removed as well.
 func (b *BoltStore) FileDelete(key string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		uploads := tx.Bucket([]byte(bucketUpload))
+		v := uploads.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		if len(v) > 0 {
+			upload := &UploadDetails{}
+			if err := json.Unmarshal(v, upload); err != nil {
+				return err
+			}
+			if index := tx.Bucket([]byte(bucketUserUploads)).Bucket([]byte(upload.User)); index != nil {
+				if err := index.Delete([]byte(key)); err != nil {
+					return err
+				}
+			}
+			if err := unindexExpiry(tx, v); err != nil {
+				return err
+			}
+			if err := unindexUsage(tx, v); err != nil {
+				return err
+			}
+		}
+		err := tx.Bucket([]byte(bucketDownloads)).DeleteBucket([]byte(key))
+		if err != nil && !errors.Is(err, bbolt.ErrBucketNotFound) {
+			return err
+		}
+		return uploads.Delete([]byte(key))
+	})
+}
+
+// expiryKey orders the expiry index by time, using the big endian unix time in nanoseconds followed by the upload key.
+func expiryKey(expires time.Time, key string) []byte {
+	index := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(index, uint64(expires.UnixNano()))
+	return append(index, key...)
+}
+
+// unindexExpiry removes the expiry index entry of the encoded upload details, if it has one.
+func unindexExpiry(tx *bbolt.Tx, value []byte) error {
+	upload := &UploadDetails{}
+	if err := json.Unmarshal(value, upload); err != nil {
+		return err
+	}
+	if upload.Expires == nil || !upload.Committed() {
+		return nil
+	}
+	return tx.Bucket([]byte(bucketExpiry)).Delete(expiryKey(*upload.Expires, upload.Key))
+}
+
+// addUsage adjusts the stored usage totals of the user.
+func addUsage(tx *bbolt.Tx, user string, bytes, files int64) error {
+	if user == "" {
+		return nil
+	}
+	bucket := tx.Bucket([]byte(bucketUsage))
+	usage := &Usage{}
+	if v := bucket.Get([]byte(user)); v != nil {
+		if err := json.Unmarshal(v, usage); err != nil {
+			return err
+		}
+	}
+	usage.Bytes += bytes
+	usage.Files += files
+	value, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(user), value)
+}
+
+// unindexUsage removes the encoded upload details from the usage totals of its user, if they are counted.
+func unindexUsage(tx *bbolt.Tx, value []byte) error {
+	upload := &UploadDetails{}
+	if err := json.Unmarshal(value, upload); err != nil {
+		return err
+	}
+	if !upload.Committed() {
+		return nil
+	}
+	return addUsage(tx, upload.User, -upload.Size, -1)
+}
+
+func (b *BoltStore) UserUsage(user string) (*Usage, error) {
+	usage := &Usage{}
+	err := b.getJson(bucketUsage, user, usage)
+	if errors.Is(err, ErrNotFound) {
+		return usage, nil
+	}
+	return usage, err
+}
+
+func (b *BoltStore) ExpiredFiles(before time.Time) ([]string, error) {
+	var keys []string
+	end := expiryKey(before, "")
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(bucketExpiry)).Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k[:8], end) <= 0; k, v = c.Next() {
+			keys = append(keys, string(v))
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// UserFiles returns the details of every upload made by the user, in key order.
+func (b *BoltStore) UserFiles(user string) ([]UploadDetails, error) {
+	var files []UploadDetails
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket([]byte(bucketUserUploads)).Bucket([]byte(user))
+		if index == nil {
+			return nil
+		}
+		uploads := tx.Bucket([]byte(bucketUpload))
+		return index.ForEach(func(k, _ []byte) error {
+			v := uploads.Get(k)
+			if len(v) == 0 {
+				return nil
+			}
+			upload := UploadDetails{}
+			if err := json.Unmarshal(v, &upload); err != nil {
+				return err
+			}
+			if upload.Committed() {
+				files = append(files, upload)
+			}
+			return nil
+		})
+	})
+	return files, err
+}
+
+func (b *BoltStore) BlobAcquire(hash, key string) (*Blob, error) {
+	blob := &Blob{Hash: hash, Key: key}
+	return blob, b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketBlob))
+		if v := bucket.Get([]byte(hash)); v != nil {
+			if err := json.Unmarshal(v, blob); err != nil {
+				return err
+			}
+		}
+		blob.Refs++
+		value, err := json.Marshal(blob)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(hash), value)
+	})
+}
+
+func (b *BoltStore) BlobRelease(hash string) (*Blob, error) {
+	blob := &Blob{}
+	return blob, b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketBlob))
+		v := bucket.Get([]byte(hash))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, blob); err != nil {
+			return err
+		}
+		blob.Refs--
+		if blob.Refs <= 0 {
+			return bucket.Delete([]byte(hash))
+		}
+		value, err := json.Marshal(blob)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(hash), value)
+	})
+}
+
+// AllFiles returns the details of every upload in any state, in key order.
+func (b *BoltStore) AllFiles() ([]UploadDetails, error) {
+	var files []UploadDetails
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketUpload)).ForEach(func(k, v []byte) error {
+			if len(v) == 0 {
+				return nil
+			}
+			upload := UploadDetails{}
+			if err := json.Unmarshal(v, &upload); err != nil {
+				return err
+			}
+			files = append(files, upload)
+			return nil
+		})
+	})
+	return files, err
+}
+
+// Placeholders returns the keys reserved by FileKey that hold neither an upload nor a link.
+func (b *BoltStore) Placeholders() ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		links := tx.Bucket([]byte(bucketLink))
+		return tx.Bucket([]byte(bucketUpload)).ForEach(func(k, v []byte) error {
+			if len(v) == 0 && links.Get(k) == nil {
+				keys = append(keys, string(k))
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (b *BoltStore) Blobs() ([]Blob, error) {
+	var blobs []Blob
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketBlob)).ForEach(func(k, v []byte) error {
+			blob := Blob{}
+			if err := json.Unmarshal(v, &blob); err != nil {
+				return err
+			}
+			blobs = append(blobs, blob)
+			return nil
+		})
+	})
+	return blobs, err
+}
+
+// BlobSet replaces the blob with the given hash, removing it if it has no references.
+func (b *BoltStore) BlobSet(blob Blob) error {
+	if blob.Refs <= 0 {
+		return b.db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte(bucketBlob)).Delete([]byte(blob.Hash))
+		})
+	}
+	return b.putJson(bucketBlob, blob.Hash, blob)
+}
+
+// LinkPut stores the link and adds it to the index of its user and the expiry index shared with uploads.
+func (b *BoltStore) LinkPut(link Link) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		value, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(bucketLink)).Put([]byte(link.Key), value); err != nil {
+			return err
+		}
+		if link.Expires != nil {
+			if err := tx.Bucket([]byte(bucketExpiry)).Put(expiryKey(*link.Expires, link.Key), []byte(link.Key)); err != nil {
+				return err
+			}
+		}
+		index, err := tx.Bucket([]byte(bucketUserLinks)).CreateBucketIfNotExists([]byte(link.User))
+		if err != nil {
+			return err
+		}
+		return index.Put([]byte(link.Key), []byte{})
+	})
+}
+
+func (b *BoltStore) LinkGet(key string) (*Link, error) {
+	link := &Link{}
+	return link, b.getJson(bucketLink, key, link)
+}
+
+func (b *BoltStore) LinkClick(key string) (*Link, error) {
+	link := &Link{}
+	return link, b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketLink))
+		v := bucket.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, link); err != nil {
+			return err
+		}
+		link.Clicks++
+		value, err := json.Marshal(link)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(key), value)
+	})
+}
+
+// LinkDelete removes the link, its index entries and the placeholder reserving its key.
+func (b *BoltStore) LinkDelete(key string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		links := tx.Bucket([]byte(bucketLink))
+		v := links.Get([]byte(key))
+		if v == nil {
+			return ErrNotFound
+		}
+		link := &Link{}
+		if err := json.Unmarshal(v, link); err != nil {
+			return err
+		}
+		if index := tx.Bucket([]byte(bucketUserLinks)).Bucket([]byte(link.User)); index != nil {
+			if err := index.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		if link.Expires != nil {
+			if err := tx.Bucket([]byte(bucketExpiry)).Delete(expiryKey(*link.Expires, key)); err != nil {
+				return err
+			}
+		}
+		if err := tx.Bucket([]byte(bucketUpload)).Delete([]byte(key)); err != nil {
+			return err
+		}
+		return links.Delete([]byte(key))
+	})
+}
+
+// UserLinks returns every link created by the user, in key order.
+func (b *BoltStore) UserLinks(user string) ([]Link, error) {
+	var links []Link
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		index := tx.Bucket([]byte(bucketUserLinks)).Bucket([]byte(user))
+		if index == nil {
+			return nil
+		}
+		bucket := tx.Bucket([]byte(bucketLink))
+		return index.ForEach(func(k, _ []byte) error {
+			v := bucket.Get(k)
+			if v == nil {
+				return nil
+			}
+			link := Link{}
+			if err := json.Unmarshal(v, &link); err != nil {
+				return err
+			}
+			links = append(links, link)
+			return nil
+		})
+	})
+	return links, err
+}
+
+func (b *BoltStore) PartialPut(upload PartialUpload) error {
+	return b.putJson(bucketPartial, upload.ID, upload)
+}
+
+func (b *BoltStore) PartialGet(id string) (*PartialUpload, error) {
+	upload := &PartialUpload{}
+	return upload, b.getJson(bucketPartial, id, upload)
+}
+
+func (b *BoltStore) PartialDelete(id string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketPartial)).Delete([]byte(id))
+	})
+}
+
+// StalePartials scans every partial upload, as there are few at any time.
+func (b *BoltStore) StalePartials(before time.Time) ([]string, error) {
+	var ids []string
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketPartial)).ForEach(func(k, v []byte) error {
+			upload := PartialUpload{}
+			if err := json.Unmarshal(v, &upload); err != nil {
+				return err
+			}
+			if upload.Updated.Before(before) {
+				ids = append(ids, string(k))
+			}
+			return nil
+		})
+	})
+	return ids, err
+}
+
+func (b *BoltStore) DataKeyPut(key string, wrapped WrappedKey) error {
+	return b.putJson(bucketDataKey, key, wrapped)
+}
+
+func (b *BoltStore) DataKeyGet(key string) (*WrappedKey, error) {
+	wrapped := &WrappedKey{}
+	return wrapped, b.getJson(bucketDataKey, key, wrapped)
+}
+
+func (b *BoltStore) DataKeyDelete(key string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketDataKey)).Delete([]byte(key))
+	})
+}
+
+func (b *BoltStore) DataKeys() ([]string, error) {
+	var keys []string
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketDataKey)).ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k))
+			return nil
+		})
+	})
+	return keys, err
+}
+
+// DataKeyRewrap replaces the data keys within a single transaction, so that a failure leaves every key unchanged.
+func (b *BoltStore) DataKeyRewrap(rewrap func(key string, wrapped WrappedKey) (*WrappedKey, error)) (int, error) {
+	replaced := map[string][]byte{}
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketDataKey))
+		if err := bucket.ForEach(func(k, v []byte) error {
+			wrapped := WrappedKey{}
+			if err := json.Unmarshal(v, &wrapped); err != nil {
+				return err
+			}
+			replacement, err := rewrap(string(k), wrapped)
+			if err != nil || replacement == nil {
+				return err
+			}
+			value, err := json.Marshal(replacement)
+			if err != nil {
+				return err
+			}
+			replaced[string(k)] = value
+			return nil
+		}); err != nil {
+			return err
+		}
+		// Keys are replaced after iterating, as bolt does not allow modifying a bucket during ForEach.
+		for k, v := range replaced {
+			if err := bucket.Put([]byte(k), v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(replaced), nil
+}
+
+// AuditAppend stores the event keyed by its time, encoded as in the expiry index, followed by a sequence number that
+// keeps events at the same time apart.
+func (b *BoltStore) AuditAppend(event AuditEvent) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket([]byte(bucketAudit))
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(binary.BigEndian.AppendUint64(expiryKey(event.Time, ""), seq), value)
+	})
+}
+
+func (b *BoltStore) AuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	var events []AuditEvent
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(bucketAudit)).Cursor()
+		k, v := c.First()
+		if !filter.Since.IsZero() {
+			k, v = c.Seek(expiryKey(filter.Since, ""))
+		}
+		for ; k != nil; k, v = c.Next() {
+			if !filter.Until.IsZero() && bytes.Compare(k[:8], expiryKey(filter.Until, "")) >= 0 {
+				break
+			}
+			event := AuditEvent{}
+			if err := json.Unmarshal(v, &event); err != nil {
+				return err
+			}
+			if !filter.Matches(&event) {
+				continue
+			}
+			events = append(events, event)
+			if filter.Limit > 0 && len(events) >= filter.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return events, err
+}
+
+// DownloadAdd counts the download within a bucket for each upload, holding the number of downloads on each UTC day.
+func (b *BoltStore) DownloadAdd(key string, at time.Time) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		days, err := tx.Bucket([]byte(bucketDownloads)).CreateBucketIfNotExists([]byte(key))
+		if err != nil {
+			return err
+		}
+		day := []byte(at.UTC().Format(downloadDay))
+		count := make([]byte, 8)
+		if v := days.Get(day); v != nil {
+			binary.BigEndian.PutUint64(count, binary.BigEndian.Uint64(v)+1)
+		} else {
+			binary.BigEndian.PutUint64(count, 1)
+		}
+		return days.Put(day, count)
+	})
+}
+
+func (b *BoltStore) Downloads(since, until time.Time) ([]DownloadCount, error) {
+	var counts []DownloadCount
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		uploads := tx.Bucket([]byte(bucketDownloads))
+		return uploads.ForEach(func(key, _ []byte) error {
+			count := DownloadCount{Key: string(key)}
+			if err := uploads.Bucket(key).ForEach(func(k, v []byte) error {
+				day, err := time.Parse(downloadDay, string(k))
+				if err != nil {
+					return err
+				}
+				if dayOverlaps(day, since, until) {
+					count.Downloads += int64(binary.BigEndian.Uint64(v))
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+			if count.Downloads > 0 {
+				counts = append(counts, count)
+			}
+			return nil
+		})
+	})
+	return counts, err
+}
+
+// storedToken reads auth bucket entries in every format that has been written. Tokens were once stored in plaintext,
+// keyed by the token and named after their user, and before the user registry the role of a user was held on each token.
+type storedToken struct {
+	auth.Token
+	Plaintext string    `json:"token"`
+	Role      auth.Role `json:"role"`
+}
+
+// migrateTokens rehashes tokens stored in plaintext, and creates the user records of tokens stored before the user registry.
+func migrateTokens(tx *bbolt.Tx) error {
+	tokens := tx.Bucket([]byte(bucketAuth))
+	var stored []*storedToken
+	if err := tokens.ForEach(func(k, v []byte) error {
+		token := &storedToken{}
+		if err := json.Unmarshal(v, token); err != nil {
+			return err
+		}
+		if token.Plaintext != "" || tx.Bucket([]byte(bucketUsers)).Get([]byte(token.User)) == nil {
+			stored = append(stored, token)
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	users := map[string]*auth.User{}
+	var names []string
+	for _, token := range stored {
+		if token.Plaintext != "" {
+			token.User = token.Name
+			token.Name = ""
+			token.Hash = auth.HashToken(token.Plaintext)
+			token.Created = time.Now().UTC()
+			if err := tokens.Delete([]byte(token.Plaintext)); err != nil {
+				return err
+			}
+		}
+		user, found := users[token.User]
+		if !found {
+			user = &auth.User{Name: token.User, Role: token.Role, Created: token.Created}
+			users[token.User] = user
+			names = append(names, user.Name)
+		}
+		if token.Name == "" {
+			// Users registered more than once have each of their tokens kept under a distinct name.
+			token.Name = auth.DefaultTokenName
+			if len(user.Tokens) > 0 {
+				token.Name = fmt.Sprintf("%s-%d", auth.DefaultTokenName, len(user.Tokens)+1)
+			}
+		}
+		user.Tokens = append(user.Tokens, token.Hash)
+		if err := putToken(tx, &token.Token); err != nil {
+			return err
+		}
+	}
+	for _, name := range names {
+		if err := putUser(tx, users[name]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (b *BoltStore) UserByAuthToken(token string) (*auth.User, error) {
+func putToken(tx *bbolt.Tx, token *auth.Token) error {
+	stored := *token
+	stored.Secret = ""
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucketAuth)).Put([]byte(token.Hash), value)
+}
+
+func getToken(tx *bbolt.Tx, hash string) (*auth.Token, error) {
+	value := tx.Bucket([]byte(bucketAuth)).Get([]byte(hash))
+	if value == nil {
+		return nil, auth.NotFoundError
+	}
+	token := &auth.Token{}
+	return token, json.Unmarshal(value, token)
+}
+
+// putUser stores the user record, without the details of any token it was authenticated with.
+func putUser(tx *bbolt.Tx, user *auth.User) error {
+	stored := *user
+	stored.AuthToken = ""
+	stored.Scopes = nil
+	value, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(bucketUsers)).Put([]byte(user.Name), value)
+}
+
+func getUser(tx *bbolt.Tx, name string) (*auth.User, error) {
+	value := tx.Bucket([]byte(bucketUsers)).Get([]byte(name))
+	if value == nil {
+		return nil, auth.NotFoundError
+	}
 	user := &auth.User{}
-	err := b.getJson(bucketAuth, token, user)
-	return user, err
+	return user, json.Unmarshal(value, user)
+}
+
+// updateUser applies update to the record of the named user.
+func (b *BoltStore) updateUser(name string, update func(user *auth.User)) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		user, err := getUser(tx, name)
+		if err != nil {
+			return err
+		}
+		update(user)
+		return putUser(tx, user)
+	})
+}
+
+func (b *BoltStore) UserByAuthToken(secret string) (*auth.User, error) {
+	hash := auth.HashToken(secret)
+	var token *auth.Token
+	var user *auth.User
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		if token, err = getToken(tx, hash); err != nil {
+			return err
+		}
+		user, err = getUser(tx, token.User)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if token.Expired(now) {
+		return nil, auth.TokenExpiredError
+	}
+	if user.Disabled {
+		return nil, auth.DisabledError
+	}
+	if token.Touch(now) {
+		// The token is read again so that a concurrent revocation is not undone.
+		if err := b.db.Update(func(tx *bbolt.Tx) error {
+			current, err := getToken(tx, hash)
+			if err != nil {
+				return err
+			}
+			current.LastUsed = token.LastUsed
+			return putToken(tx, current)
+		}); err != nil {
+			return nil, err
+		}
+	}
+	user.AuthToken = secret
+	user.Scopes = token.Scopes
+	return user, nil
 }
 
 func (b *BoltStore) UserRegister(name string) (*auth.User, error) {
-	token, err := randSecKey()
+	return b.registerUser(name, nil)
+}
+
+func (b *BoltStore) UserRegisterIdentity(name string, identity auth.Identity) (*auth.User, error) {
+	return b.registerUser(name, &identity)
+}
+
+func (b *BoltStore) registerUser(name string, identity *auth.Identity) (*auth.User, error) {
+	token, err := auth.NewToken(name, auth.DefaultTokenName, auth.TokenOptions{})
+	if err != nil {
+		return nil, err
+	}
+	user := &auth.User{Name: name, Role: auth.RoleUser, Created: token.Created, Tokens: []string{token.Hash}, Identity: identity}
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		if _, err := getUser(tx, name); err == nil {
+			return auth.DuplicateError
+		}
+		if err := putToken(tx, token); err != nil {
+			return err
+		}
+		return putUser(tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	user.AuthToken = token.Secret
+	return user, nil
+}
+
+func (b *BoltStore) UserByName(name string) (*auth.User, error) {
+	var user *auth.User
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		var err error
+		user, err = getUser(tx, name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (b *BoltStore) UserSetRole(name string, role auth.Role) error {
+	return b.updateUser(name, func(user *auth.User) { user.Role = role })
+}
+
+func (b *BoltStore) UserSetDisabled(name string, disabled bool) error {
+	return b.updateUser(name, func(user *auth.User) { user.Disabled = disabled })
+}
+
+func (b *BoltStore) UserSetQuota(name string, quota *auth.Quota) error {
+	return b.updateUser(name, func(user *auth.User) { user.Quota = quota })
+}
+
+// UserQuota returns the quota set on the record of the user, or nil if there is none.
+func (b *BoltStore) UserQuota(name string) (*QuotaLimits, error) {
+	user, err := b.UserByName(name)
+	if errors.Is(err, auth.NotFoundError) || (err == nil && user.Quota == nil) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	limits := QuotaLimits(*user.Quota)
+	return &limits, nil
+}
+
+func (b *BoltStore) UserList() ([]*auth.User, error) {
+	var users []*auth.User
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketUsers)).ForEach(func(k, v []byte) error {
+			user := &auth.User{}
+			if err := json.Unmarshal(v, user); err != nil {
+				return err
+			}
+			users = append(users, user)
+			return nil
+		})
+	})
+	return users, err
+}
+
+// UserRevoke removes every token of the named user.
+func (b *BoltStore) UserRevoke(name string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		user, err := getUser(tx, name)
+		if err != nil {
+			return err
+		}
+		bucket := tx.Bucket([]byte(bucketAuth))
+		for _, hash := range user.Tokens {
+			if err := bucket.Delete([]byte(hash)); err != nil {
+				return err
+			}
+		}
+		user.Tokens = nil
+		return putUser(tx, user)
+	})
+}
+
+// namedToken returns the record of the user and their token with the given name, which is nil if they have no such token.
+func namedToken(tx *bbolt.Tx, username, name string) (*auth.User, *auth.Token, error) {
+	user, err := getUser(tx, username)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, hash := range user.Tokens {
+		token, err := getToken(tx, hash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if token.Name == name {
+			return user, token, nil
+		}
+	}
+	return user, nil, nil
+}
+
+// removeToken deletes the token and its reference from the user, which the caller must store.
+func removeToken(tx *bbolt.Tx, user *auth.User, token *auth.Token) error {
+	for i, hash := range user.Tokens {
+		if hash == token.Hash {
+			user.Tokens = append(user.Tokens[:i], user.Tokens[i+1:]...)
+			break
+		}
+	}
+	return tx.Bucket([]byte(bucketAuth)).Delete([]byte(token.Hash))
+}
+
+func (b *BoltStore) TokenCreate(username, name string, opts auth.TokenOptions) (*auth.Token, error) {
+	token, err := auth.NewToken(username, name, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = b.db.Update(func(tx *bbolt.Tx) error {
+		user, named, err := namedToken(tx, username, name)
+		if err != nil {
+			return err
+		}
+		if named != nil {
+			return auth.TokenDuplicateError
+		}
+		if err := putToken(tx, token); err != nil {
+			return err
+		}
+		user.Tokens = append(user.Tokens, token.Hash)
+		return putUser(tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+func (b *BoltStore) TokenList(username string) ([]*auth.Token, error) {
+	var tokens []*auth.Token
+	err := b.db.View(func(tx *bbolt.Tx) error {
+		user, err := getUser(tx, username)
+		if err != nil {
+			return err
+		}
+		for _, hash := range user.Tokens {
+			token, err := getToken(tx, hash)
+			if err != nil {
+				return err
+			}
+			tokens = append(tokens, token)
+		}
+		return nil
+	})
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Name < tokens[j].Name })
+	return tokens, err
+}
+
+func (b *BoltStore) TokenRevoke(username, name string) error {
+	return b.db.Update(func(tx *bbolt.Tx) error {
+		user, named, err := namedToken(tx, username, name)
+		if err != nil {
+			return err
+		}
+		if named == nil {
+			return auth.NotFoundError
+		}
+		if err := removeToken(tx, user, named); err != nil {
+			return err
+		}
+		return putUser(tx, user)
+	})
+}
+
+func (b *BoltStore) TokenRotate(username, name string) (*auth.Token, error) {
+	var token *auth.Token
+	err := b.db.Update(func(tx *bbolt.Tx) error {
+		user, named, err := namedToken(tx, username, name)
+		if err != nil {
+			return err
+		}
+		if named == nil {
+			return auth.NotFoundError
+		}
+		if token, err = auth.NewToken(username, name, named.Options()); err != nil {
+			return err
+		}
+		if err := removeToken(tx, user, named); err != nil {
+			return err
+		}
+		if err := putToken(tx, token); err != nil {
+			return err
+		}
+		user.Tokens = append(user.Tokens, token.Hash)
+		return putUser(tx, user)
+	})
 	if err != nil {
 		return nil, err
 	}
-	user := &auth.User{AuthToken: token, Name: name}
-	return user, b.putJsonNoDupe(bucketAuth, user.AuthToken, user)
+	return token, nil
 }
@@ -1,8 +1,14 @@
 package uploader
 
 import (
+	"errors"
 	"os"
 	"testing"
+	"time"
+
+	"uploader/internal/auth"
+
+	"github.com/google/go-cmp/cmp"
 )
 
 func TestBoltStore_UserRegistration(t *testing.T) {
@@ -24,6 +30,215 @@ func TestBoltStore_UserRegistration(t *testing.T) {
 	}
 }
 
+func TestBoltStore_UserRegistry(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	registered, _ := meta.UserRegister("test_user")
+	if _, err := meta.UserRegister("test_user"); !errors.Is(err, auth.DuplicateError) {
+		t.Errorf("expected duplicate error registering a taken name, got %v", err)
+	}
+
+	user, err := meta.UserByName("test_user")
+	if err != nil {
+		t.Fatalf("unexpected error looking up user: %s", err)
+	}
+	if user.Created.IsZero() || user.AuthToken != "" || len(user.Tokens) != 1 {
+		t.Errorf("unexpected user record %+v", user)
+	}
+	if _, err := meta.UserByName("missing_user"); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected not found error for unknown user, got %v", err)
+	}
+
+	if err := meta.UserSetDisabled("test_user", true); err != nil {
+		t.Fatalf("unexpected error disabling user: %s", err)
+	}
+	if _, err := meta.UserByAuthToken(registered.AuthToken); !errors.Is(err, auth.DisabledError) {
+		t.Errorf("expected disabled error, got %v", err)
+	}
+	meta.UserSetDisabled("test_user", false)
+	if _, err := meta.UserByAuthToken(registered.AuthToken); err != nil {
+		t.Errorf("expected re-enabled user to authenticate, got %v", err)
+	}
+
+	if quota, err := meta.UserQuota("test_user"); err != nil || quota != nil {
+		t.Errorf("expected no quota override, got %v, %v", quota, err)
+	}
+	meta.UserSetQuota("test_user", &auth.Quota{MaxBytes: 100, MaxFiles: 2})
+	quota, err := meta.UserQuota("test_user")
+	if err != nil {
+		t.Fatalf("unexpected error getting quota: %s", err)
+	}
+	if diff := cmp.Diff(&QuotaLimits{MaxBytes: 100, MaxFiles: 2}, quota); diff != "" {
+		t.Errorf("unexpected quota (-want +got):\n%s", diff)
+	}
+
+	// Revoking every token keeps the user registered.
+	meta.UserRevoke("test_user")
+	if users, _ := meta.UserList(); len(users) != 1 || len(users[0].Tokens) != 0 {
+		t.Errorf("expected user without tokens to remain registered, got %v", users)
+	}
+}
+
+func TestBoltStore_UserSetRole(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	user, _ := meta.UserRegister("test_user")
+	if err := meta.UserSetRole("test_user", auth.RoleAdmin); err != nil {
+		t.Fatalf("unexpected error setting role: %s", err)
+	}
+	updated, err := meta.UserByAuthToken(user.AuthToken)
+	if err != nil {
+		t.Fatalf("failed to fetch user: %s", err)
+	}
+	if !updated.IsAdmin() {
+		t.Errorf("expected user to be an admin, got role %q", updated.Role)
+	}
+	if err := meta.UserSetRole("missing_user", auth.RoleAdmin); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected not found error for unknown user, got %v", err)
+	}
+}
+
+func TestBoltStore_UserTokens(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	bob, _ := meta.UserRegister("bob")
+	alice, _ := meta.UserRegister("alice")
+
+	users, err := meta.UserList()
+	if err != nil {
+		t.Fatalf("unexpected error listing users: %s", err)
+	}
+	if len(users) != 2 || users[0].Name != "alice" || users[1].Name != "bob" {
+		t.Fatalf("expected alice and bob in order, got %v", users)
+	}
+
+	ci, err := meta.TokenCreate("bob", "ci", auth.TokenOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error creating token: %s", err)
+	}
+	if _, err := meta.TokenCreate("bob", "ci", auth.TokenOptions{}); !errors.Is(err, auth.TokenDuplicateError) {
+		t.Errorf("expected duplicate error for existing token name, got %v", err)
+	}
+	if _, err := meta.TokenCreate("missing_user", "ci", auth.TokenOptions{}); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected not found error for unknown user, got %v", err)
+	}
+	if user, err := meta.UserByAuthToken(ci.Secret); err != nil || user.Name != "bob" {
+		t.Errorf("expected new token to authenticate bob, got %v, %v", user, err)
+	}
+
+	tokens, err := meta.TokenList("bob")
+	if err != nil {
+		t.Fatalf("unexpected error listing tokens: %s", err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "ci" || tokens[1].Name != auth.DefaultTokenName {
+		t.Fatalf("expected ci and default tokens, got %v", tokens)
+	}
+	if tokens[0].Secret != "" || tokens[0].Hash == ci.Secret {
+		t.Errorf("expected token secret not to be stored")
+	}
+	if tokens[0].LastUsed == nil {
+		t.Errorf("expected last used time to be recorded")
+	}
+
+	rotated, err := meta.TokenRotate("bob", auth.DefaultTokenName)
+	if err != nil {
+		t.Fatalf("unexpected error rotating token: %s", err)
+	}
+	if _, err := meta.UserByAuthToken(bob.AuthToken); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected old token to be invalid, got %v", err)
+	}
+	if user, err := meta.UserByAuthToken(rotated.Secret); err != nil || user.Name != "bob" {
+		t.Errorf("expected rotated token to authenticate bob, got %v, %v", user, err)
+	}
+
+	if err := meta.TokenRevoke("bob", "ci"); err != nil {
+		t.Fatalf("unexpected error revoking token: %s", err)
+	}
+	if _, err := meta.UserByAuthToken(ci.Secret); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected revoked token to be invalid, got %v", err)
+	}
+	if err := meta.TokenRevoke("bob", "ci"); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected not found error revoking a missing token, got %v", err)
+	}
+
+	if err := meta.UserRevoke("alice"); err != nil {
+		t.Fatalf("unexpected error revoking user: %s", err)
+	}
+	if _, err := meta.UserByAuthToken(alice.AuthToken); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected revoked token to be invalid, got %v", err)
+	}
+	if err := meta.UserRevoke("missing_user"); !errors.Is(err, auth.NotFoundError) {
+		t.Errorf("expected not found error for unknown user, got %v", err)
+	}
+}
+
+func TestBoltStore_TokenExpiry(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	meta.UserRegister("bob")
+	past := time.Now().Add(-time.Minute)
+	expired, _ := meta.TokenCreate("bob", "expired", auth.TokenOptions{Expires: &past})
+	if _, err := meta.UserByAuthToken(expired.Secret); !errors.Is(err, auth.TokenExpiredError) {
+		t.Errorf("expected expired token error, got %v", err)
+	}
+	future := time.Now().Add(time.Hour)
+	valid, _ := meta.TokenCreate("bob", "valid", auth.TokenOptions{Expires: &future})
+	if _, err := meta.UserByAuthToken(valid.Secret); err != nil {
+		t.Errorf("expected unexpired token to be valid, got %v", err)
+	}
+}
+
+func TestBoltStore_TokenScopes(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	meta.UserRegister("bob")
+	scoped, _ := meta.TokenCreate("bob", "ci", auth.TokenOptions{Scopes: []auth.Scope{auth.ScopeUpload}})
+	user, err := meta.UserByAuthToken(scoped.Secret)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !user.HasScope(auth.ScopeUpload) || user.HasScope(auth.ScopeDelete) {
+		t.Errorf("expected user to only have the upload scope, got %v", user.Scopes)
+	}
+	rotated, _ := meta.TokenRotate("bob", "ci")
+	if diff := cmp.Diff(scoped.Scopes, rotated.Scopes); diff != "" {
+		t.Errorf("expected rotation to keep scopes (-want +got):\n%s", diff)
+	}
+}
+
+func TestBoltStore_MigrateTokens(t *testing.T) {
+	meta := newTestBolt(t)
+	path := meta.db.Path()
+	legacy := &auth.User{Name: "bob", AuthToken: "plaintext", Role: auth.RoleAdmin}
+	if err := meta.putJson(bucketAuth, legacy.AuthToken, legacy); err != nil {
+		t.Fatalf("failed to store legacy token: %s", err)
+	}
+	meta.Close()
+
+	meta, err := NewBoltStore(path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %s", err)
+	}
+	defer meta.Close()
+	user, err := meta.UserByAuthToken("plaintext")
+	if err != nil {
+		t.Fatalf("expected legacy token to remain valid, got %s", err)
+	}
+	if user.Name != "bob" || !user.IsAdmin() {
+		t.Errorf("expected legacy admin bob, got %+v", user)
+	}
+	record, err := meta.UserByName("bob")
+	if err != nil {
+		t.Fatalf("expected user record to be created, got %s", err)
+	}
+	if len(record.Tokens) != 1 || record.Tokens[0] != auth.HashToken("plaintext") {
+		t.Errorf("expected user record to reference the migrated token, got %v", record.Tokens)
+	}
+	if err := meta.getJson(bucketAuth, "plaintext", &auth.User{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected plaintext token to be removed, got %v", err)
+	}
+}
+
 func TestBoltStore_FileDelete(t *testing.T) {
 	meta := newTestBolt(t)
 	defer meta.Close()
@@ -42,6 +257,40 @@ func TestBoltStore_FileDelete(t *testing.T) {
 	if err != nil {
 		t.Errorf("unexpected error removing file meta: %s", err)
 	}
+	if _, err = meta.FileGet("abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected file meta to be removed, got %v", err)
+	}
+	files, err := meta.UserFiles("test_user")
+	if err != nil {
+		t.Fatalf("unexpected error listing user files: %s", err)
+	}
+	if len(files) != 0 {
+		t.Errorf("expected user index to be empty, got %d files", len(files))
+	}
+}
+
+func TestBoltStore_UserFiles(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	for _, key := range []string{"a", "b"} {
+		if err := meta.FilePut(UploadDetails{Key: key, User: "test_user"}); err != nil {
+			t.Fatalf("failed creating store file: %s", err)
+		}
+	}
+	meta.FilePut(UploadDetails{Key: "c", User: "other_user"})
+	// Placeholders created by FileKey are not part of any listing.
+	meta.FileKey()
+
+	files, err := meta.UserFiles("test_user")
+	if err != nil {
+		t.Fatalf("unexpected error listing user files: %s", err)
+	}
+	if len(files) != 2 || files[0].Key != "a" || files[1].Key != "b" {
+		t.Errorf("unexpected user files %+v", files)
+	}
+	if files, _ = meta.UserFiles("missing_user"); len(files) != 0 {
+		t.Errorf("expected no files for unknown user, got %d", len(files))
+	}
 }
 
 func TestBoltStore_FileKey(t *testing.T) {
@@ -99,6 +348,334 @@ func TestBoltStore_FilePut(t *testing.T) {
 	})
 }
 
+func TestBoltStore_Partial(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	err := meta.PartialPut(PartialUpload{ID: "abc", User: "test_user", Length: 10, Offset: 4, Chunks: []int64{4}})
+	if err != nil {
+		t.Fatalf("unexpected error putting partial upload %s", err)
+	}
+	partial, err := meta.PartialGet("abc")
+	if err != nil {
+		t.Fatalf("unexpected error getting partial upload %s", err)
+	}
+	if partial.Offset != 4 || len(partial.Chunks) != 1 {
+		t.Errorf("partial upload did not round trip, got %+v", partial)
+	}
+	updated := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	meta.PartialPut(PartialUpload{ID: "def", User: "test_user", Length: 10, Updated: updated})
+	stale, err := meta.StalePartials(updated.Add(time.Second))
+	if err != nil {
+		t.Fatalf("unexpected error listing stale partial uploads %s", err)
+	}
+	if diff := cmp.Diff([]string{"abc", "def"}, stale); diff != "" {
+		t.Errorf("unexpected stale partial uploads %s", diff)
+	}
+	if stale, _ = meta.StalePartials(updated); len(stale) != 1 {
+		t.Errorf("expected only the upload without an update time to be stale, got %v", stale)
+	}
+	if err = meta.PartialDelete("abc"); err != nil {
+		t.Fatalf("unexpected error deleting partial upload %s", err)
+	}
+	if _, err = meta.PartialGet("abc"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected partial upload to be removed, got %v", err)
+	}
+}
+
+func TestBoltStore_ExpiredFiles(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	now := time.Now()
+	expiries := map[string]time.Time{"a": now.Add(-time.Hour), "b": now.Add(-time.Minute), "c": now.Add(time.Hour)}
+	for key, expires := range expiries {
+		expires := expires
+		if err := meta.FilePut(UploadDetails{Key: key, User: "test_user", Expires: &expires}); err != nil {
+			t.Fatalf("failed creating store file: %s", err)
+		}
+	}
+	meta.FilePut(UploadDetails{Key: "d", User: "test_user"})
+
+	keys, err := meta.ExpiredFiles(now)
+	if err != nil {
+		t.Fatalf("unexpected error listing expired files: %s", err)
+	}
+	if diff := cmp.Diff(keys, []string{"a", "b"}); diff != "" {
+		t.Errorf("unexpected expired files %s", diff)
+	}
+
+	// Replacing and deleting uploads keeps the index up to date.
+	meta.FilePut(UploadDetails{Key: "a", User: "test_user"})
+	meta.FileDelete("b")
+	if keys, _ = meta.ExpiredFiles(now.Add(2 * time.Hour)); !cmp.Equal(keys, []string{"c"}) {
+		t.Errorf("expected only c to remain in the index, got %v", keys)
+	}
+}
+
+func TestBoltStore_Blobs(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	blob, err := meta.BlobAcquire("hash", "blob-a")
+	if err != nil {
+		t.Fatalf("unexpected error acquiring blob: %s", err)
+	}
+	if blob.Key != "blob-a" || blob.Refs != 1 {
+		t.Errorf("unexpected new blob %+v", blob)
+	}
+	if blob, _ = meta.BlobAcquire("hash", "blob-b"); blob.Key != "blob-a" || blob.Refs != 2 {
+		t.Errorf("expected existing blob to be referenced, got %+v", blob)
+	}
+	if blob, _ = meta.BlobRelease("hash"); blob.Refs != 1 {
+		t.Errorf("expected one remaining reference, got %d", blob.Refs)
+	}
+	if blob, _ = meta.BlobRelease("hash"); blob.Refs != 0 || blob.Key != "blob-a" {
+		t.Errorf("expected blob to be released, got %+v", blob)
+	}
+	if _, err = meta.BlobRelease("hash"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected released blob to be removed, got %v", err)
+	}
+}
+
+func TestBoltStore_UserUsage(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	meta.FilePut(UploadDetails{Key: "a", User: "test_user", Size: 10})
+	meta.FilePut(UploadDetails{Key: "b", User: "test_user", Size: 20})
+	// Replacing an upload must not count it twice.
+	meta.FilePut(UploadDetails{Key: "b", User: "test_user", Size: 25})
+	meta.FilePut(UploadDetails{Key: "c", User: "other_user", Size: 40})
+	meta.FileDelete("a")
+
+	usage, err := meta.UserUsage("test_user")
+	if err != nil {
+		t.Fatalf("unexpected error getting usage: %s", err)
+	}
+	if *usage != (Usage{Bytes: 25, Files: 1}) {
+		t.Errorf("unexpected usage %+v", usage)
+	}
+	if usage, _ = meta.UserUsage("missing_user"); *usage != (Usage{}) {
+		t.Errorf("expected no usage for unknown user, got %+v", usage)
+	}
+}
+
+func TestBoltStore_UploadState(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	expires := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	pending := UploadDetails{Key: "a", User: "test_user", Size: 10, Expires: &expires, State: UploadPending}
+	meta.FilePut(pending)
+
+	if _, err := meta.FileGet("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected pending upload to not be found, got %v", err)
+	}
+	if files, _ := meta.UserFiles("test_user"); len(files) != 0 {
+		t.Errorf("expected pending upload to not be listed, got %+v", files)
+	}
+	if usage, _ := meta.UserUsage("test_user"); *usage != (Usage{}) {
+		t.Errorf("expected pending upload to not be counted, got %+v", usage)
+	}
+	if keys, _ := meta.ExpiredFiles(expires.Add(time.Second)); len(keys) != 0 {
+		t.Errorf("expected pending upload to not expire, got %v", keys)
+	}
+
+	committed := pending
+	committed.State = UploadCommitted
+	meta.FilePut(committed)
+	if usage, _ := meta.UserUsage("test_user"); *usage != (Usage{Bytes: 10, Files: 1}) {
+		t.Errorf("expected committed upload to be counted, got %+v", usage)
+	}
+
+	deleting := committed
+	deleting.State = UploadDeleting
+	meta.FilePut(deleting)
+	if files, _ := meta.UserFiles("test_user"); len(files) != 0 {
+		t.Errorf("expected deleting upload to not be listed, got %+v", files)
+	}
+	if usage, _ := meta.UserUsage("test_user"); *usage != (Usage{}) {
+		t.Errorf("expected deleting upload to not be counted, got %+v", usage)
+	}
+	if keys, _ := meta.ExpiredFiles(expires.Add(time.Second)); len(keys) != 0 {
+		t.Errorf("expected deleting upload to not expire, got %v", keys)
+	}
+	if files, _ := meta.AllFiles(); len(files) != 1 || files[0].State != UploadDeleting {
+		t.Errorf("expected deleting upload to be kept until removed, got %+v", files)
+	}
+}
+
+func TestBoltStore_Placeholders(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	placeholder, _ := meta.FileKey()
+	upload, _ := meta.FileKey()
+	meta.FilePut(UploadDetails{Key: upload, User: "test_user"})
+	link, _ := meta.FileKey()
+	meta.LinkPut(Link{Key: link, User: "test_user", Target: "https://example.com"})
+
+	keys, err := meta.Placeholders()
+	if err != nil {
+		t.Fatalf("unexpected error listing placeholders: %s", err)
+	}
+	if diff := cmp.Diff([]string{placeholder}, keys); diff != "" {
+		t.Errorf("unexpected placeholders %s", diff)
+	}
+}
+
+func TestBoltStore_Audit(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	at := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	events := []AuditEvent{
+		{Time: at, Action: AuditUpload, Actor: "test_user", Key: "a"},
+		// Events at the same time are kept apart.
+		{Time: at, Action: AuditUpload, Actor: "test_user", Key: "b"},
+		{Time: at.Add(time.Hour), Action: AuditDelete, Actor: "other_user", Key: "a"},
+		{Time: at.Add(2 * time.Hour), Action: AuditAuthFailure, Detail: "access token is invalid"},
+	}
+	// Events are ordered by time rather than the order they were appended.
+	for _, i := range []int{3, 0, 1, 2} {
+		if err := meta.AuditAppend(events[i]); err != nil {
+			t.Fatalf("unexpected error appending event: %s", err)
+		}
+	}
+
+	tests := map[string]struct {
+		filter AuditFilter
+		want   []AuditEvent
+	}{
+		"all":    {AuditFilter{}, events},
+		"since":  {AuditFilter{Since: at.Add(time.Hour)}, events[2:]},
+		"until":  {AuditFilter{Until: at.Add(time.Hour)}, events[:2]},
+		"range":  {AuditFilter{Since: at.Add(time.Minute), Until: at.Add(2 * time.Hour)}, events[2:3]},
+		"action": {AuditFilter{Action: AuditUpload}, events[:2]},
+		"actor":  {AuditFilter{Actor: "other_user"}, events[2:3]},
+		"key":    {AuditFilter{Key: "a"}, []AuditEvent{events[0], events[2]}},
+		"limit":  {AuditFilter{Since: at.Add(time.Minute), Limit: 1}, events[2:3]},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := meta.AuditEvents(test.filter)
+			if err != nil {
+				t.Fatalf("unexpected error listing events: %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected events %s", diff)
+			}
+		})
+	}
+}
+
+func TestBoltStore_Downloads(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	day := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	meta.DownloadAdd("a", day.Add(time.Hour))
+	meta.DownloadAdd("a", day.Add(23*time.Hour))
+	meta.DownloadAdd("a", day.Add(25*time.Hour))
+	meta.DownloadAdd("b", day.Add(-time.Hour))
+
+	tests := map[string]struct {
+		since, until time.Time
+		want         []DownloadCount
+	}{
+		"all":        {time.Time{}, time.Time{}, []DownloadCount{{"a", 3}, {"b", 1}}},
+		"day":        {day, day.Add(24 * time.Hour), []DownloadCount{{"a", 2}}},
+		"within day": {day.Add(2 * time.Hour), day.Add(3 * time.Hour), []DownloadCount{{"a", 2}}},
+		"since":      {day.Add(24 * time.Hour), time.Time{}, []DownloadCount{{"a", 1}}},
+		"until":      {time.Time{}, day, []DownloadCount{{"b", 1}}},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			got, err := meta.Downloads(test.since, test.until)
+			if err != nil {
+				t.Fatalf("unexpected error counting downloads: %s", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected downloads %s", diff)
+			}
+		})
+	}
+
+	meta.FilePut(UploadDetails{Key: "a", User: "test_user", Uploaded: day})
+	if err := meta.FileDelete("a"); err != nil {
+		t.Fatalf("unexpected error deleting upload: %s", err)
+	}
+	if got, _ := meta.Downloads(time.Time{}, time.Time{}); !cmp.Equal([]DownloadCount{{"b", 1}}, got) {
+		t.Errorf("expected downloads of the deleted upload to be removed, got %v", got)
+	}
+}
+
+func TestBoltStore_Links(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	key, err := meta.FileKey()
+	if err != nil {
+		t.Fatalf("failed to reserve key: %s", err)
+	}
+	expires := time.Now().Add(time.Hour)
+	if err := meta.LinkPut(Link{Key: key, User: "test_user", Target: "https://example.com", Expires: &expires}); err != nil {
+		t.Fatalf("unexpected error storing link: %s", err)
+	}
+	if _, err := meta.FileGet(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected link key not to be an upload, got %v", err)
+	}
+	for i := int64(1); i <= 2; i++ {
+		if link, err := meta.LinkClick(key); err != nil || link.Clicks != i {
+			t.Errorf("expected %d clicks, got %+v %v", i, link, err)
+		}
+	}
+	links, err := meta.UserLinks("test_user")
+	if err != nil || len(links) != 1 || links[0].Clicks != 2 {
+		t.Errorf("unexpected user links %+v %v", links, err)
+	}
+	if keys, _ := meta.ExpiredFiles(expires); !cmp.Equal(keys, []string{key}) {
+		t.Errorf("expected link in the expiry index, got %v", keys)
+	}
+
+	if err := meta.LinkDelete(key); err != nil {
+		t.Fatalf("unexpected error deleting link: %s", err)
+	}
+	if _, err := meta.LinkGet(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected link to be removed, got %v", err)
+	}
+	if links, _ = meta.UserLinks("test_user"); len(links) != 0 {
+		t.Errorf("expected user index to be empty, got %+v", links)
+	}
+	if keys, _ := meta.ExpiredFiles(expires); len(keys) != 0 {
+		t.Errorf("expected expiry index to be empty, got %v", keys)
+	}
+	if err := meta.FileDelete(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected key placeholder to be released, got %v", err)
+	}
+}
+
+func TestBoltStore_FileDownload(t *testing.T) {
+	meta := newTestBolt(t)
+	defer meta.Close()
+	downloads := int64(2)
+	meta.FilePut(UploadDetails{Key: "limited", User: "test_user", DownloadsLeft: &downloads})
+	meta.FilePut(UploadDetails{Key: "unlimited", User: "test_user"})
+
+	for want := int64(1); want >= 0; want-- {
+		details, err := meta.FileDownload("limited")
+		if err != nil || *details.DownloadsLeft != want {
+			t.Fatalf("expected %d downloads left, got %+v %v", want, details, err)
+		}
+	}
+	if _, err := meta.FileDownload("limited"); !errors.Is(err, ErrNoDownloadsLeft) {
+		t.Errorf("expected no downloads left, got %v", err)
+	}
+	if details, _ := meta.FileGet("limited"); *details.DownloadsLeft != 0 {
+		t.Errorf("expected downloads left to be stored, got %d", *details.DownloadsLeft)
+	}
+	if details, err := meta.FileDownload("unlimited"); err != nil || details.DownloadsLeft != nil {
+		t.Errorf("expected unlimited upload to be unchanged, got %+v %v", details, err)
+	}
+	if _, err := meta.FileDownload("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected missing upload not to be found, got %v", err)
+	}
+}
+
 func newTestBolt(t testing.TB) *BoltStore {
 	f, err := os.CreateTemp(t.TempDir(), "testdb-")
 	if err != nil {
@@ -0,0 +1,373 @@
+package uploader
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"uploader/internal/auth"
+	"uploader/internal/metrics"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// metricsPath is where metrics are served when they share the address of the uploader.
+const metricsPath = "/metrics"
+
+// Store labels of the store operation metrics.
+const (
+	storeFile = "file"
+	storeMeta = "meta"
+)
+
+// Metrics are the Prometheus metrics of an uploader. A nil *Metrics records nothing, so that callers need not check
+// whether metrics are enabled.
+type Metrics struct {
+	registry        *metrics.Registry
+	requests        *metrics.Counter
+	requestDuration *metrics.Histogram
+	uploadedBytes   *metrics.Counter
+	servedBytes     *metrics.Counter
+	uploadSize      *metrics.Histogram
+	authFailures    *metrics.Counter
+	storeDuration   *metrics.Histogram
+	storeErrors     *metrics.Counter
+}
+
+// NewMetrics creates the metrics of an uploader, reading the stored bytes and objects of each user from meta whenever
+// the metrics are collected.
+func NewMetrics(meta MetaStore) *Metrics {
+	r := metrics.NewRegistry()
+	m := &Metrics{
+		registry: r,
+		requests: r.Counter("uploader_http_requests_total",
+			"HTTP requests served by route, method and status.", "route", "method", "status"),
+		requestDuration: r.Histogram("uploader_http_request_duration_seconds",
+			"Time taken to serve HTTP requests by route and method.", metrics.DefaultBuckets, "route", "method"),
+		uploadedBytes: r.Counter("uploader_uploaded_bytes_total", "Bytes of files uploaded."),
+		servedBytes:   r.Counter("uploader_served_bytes_total", "Bytes of files served to downloads."),
+		uploadSize: r.Histogram("uploader_upload_size_bytes",
+			"Size of uploaded files.", metrics.ExponentialBuckets(1024, 4, 10)),
+		authFailures: r.Counter("uploader_auth_failures_total",
+			"Requests rejected by authentication by reason.", "reason"),
+		storeDuration: r.Histogram("uploader_store_operation_duration_seconds",
+			"Time taken by file and meta store operations.", metrics.DefaultBuckets, "store", "operation"),
+		storeErrors: r.Counter("uploader_store_operation_errors_total",
+			"File and meta store operations that failed.", "store", "operation"),
+	}
+	r.GaugeFunc("uploader_user_stored_bytes", "Total size of the uploads of each user.", []string{"user"},
+		func() ([]metrics.Sample, error) {
+			return usageSamples(meta, func(usage *Usage) int64 { return usage.Bytes })
+		})
+	r.GaugeFunc("uploader_user_stored_objects", "Number of uploads of each user.", []string{"user"},
+		func() ([]metrics.Sample, error) {
+			return usageSamples(meta, func(usage *Usage) int64 { return usage.Files })
+		})
+	return m
+}
+
+func usageSamples(meta MetaStore, value func(usage *Usage) int64) ([]metrics.Sample, error) {
+	users, err := meta.UserList()
+	if err != nil {
+		return nil, err
+	}
+	samples := make([]metrics.Sample, 0, len(users))
+	for _, user := range users {
+		usage, err := meta.UserUsage(user.Name)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, metrics.Sample{Labels: []string{user.Name}, Value: float64(value(usage))})
+	}
+	return samples, nil
+}
+
+// Handler returns a handler serving the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return m.registry.Handler()
+}
+
+// observeRequests is middleware counting and timing requests by the route pattern they matched.
+func (u *Uploader) observeRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := u.metrics
+		if m == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		m.requests.Inc(route, r.Method, strconv.Itoa(status))
+		m.requestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+func (m *Metrics) upload(size int64) {
+	if m == nil {
+		return
+	}
+	m.uploadedBytes.Add(float64(size))
+	m.uploadSize.Observe(float64(size))
+}
+
+func (m *Metrics) authFailure(reason error) {
+	if m == nil {
+		return
+	}
+	m.authFailures.Inc(reason.Error())
+}
+
+// servedWriter returns w counting the bytes of files written to it.
+func (m *Metrics) servedWriter(w http.ResponseWriter) http.ResponseWriter {
+	if m == nil {
+		return w
+	}
+	return &servedResponseWriter{ResponseWriter: w, served: m.servedBytes}
+}
+
+type servedResponseWriter struct {
+	http.ResponseWriter
+	served *metrics.Counter
+}
+
+func (s *servedResponseWriter) Write(p []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(p)
+	s.served.Add(float64(n))
+	return n, err
+}
+
+// storeOperation times an operation of a store started at start. Errors expected in normal use, such as missing
+// files, are not counted as failures.
+func (m *Metrics) storeOperation(store, operation string, start time.Time, err error) {
+	m.storeDuration.Observe(time.Since(start).Seconds(), store, operation)
+	if err != nil && !expectedStoreError(err) {
+		m.storeErrors.Inc(store, operation)
+	}
+}
+
+func expectedStoreError(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrNoDownloadsLeft) ||
+		errors.Is(err, auth.NotFoundError) || errors.Is(err, auth.DisabledError) || errors.Is(err, auth.TokenExpiredError)
+}
+
+func observe[T any](m *Metrics, store, operation string, op func() (T, error)) (T, error) {
+	start := time.Now()
+	v, err := op()
+	m.storeOperation(store, operation, start, err)
+	return v, err
+}
+
+func observeErr(m *Metrics, store, operation string, op func() error) error {
+	start := time.Now()
+	err := op()
+	m.storeOperation(store, operation, start, err)
+	return err
+}
+
+// instrumentedFileStore times the operations of a FileStore.
+type instrumentedFileStore struct {
+	store   FileStore
+	metrics *Metrics
+}
+
+func (i *instrumentedFileStore) Close() error {
+	return i.store.Close()
+}
+
+func (i *instrumentedFileStore) Get(key string) (io.ReadCloser, error) {
+	return observe(i.metrics, storeFile, "Get", func() (io.ReadCloser, error) { return i.store.Get(key) })
+}
+
+func (i *instrumentedFileStore) Put(key string, r io.Reader) error {
+	return observeErr(i.metrics, storeFile, "Put", func() error { return i.store.Put(key, r) })
+}
+
+func (i *instrumentedFileStore) Delete(key string) error {
+	return observeErr(i.metrics, storeFile, "Delete", func() error { return i.store.Delete(key) })
+}
+
+// instrumentFileStore times the operations of the store. The optional capabilities of the store are kept, so the
+// instrumented store only implements URLSigner and FileLister if the store does.
+func instrumentFileStore(store FileStore, m *Metrics) FileStore {
+	i := &instrumentedFileStore{store: store, metrics: m}
+	signer, signs := store.(URLSigner)
+	lister, lists := store.(FileLister)
+	switch {
+	case signs && lists:
+		return &instrumentedSignerLister{instrumentedLister{i, lister}, signer}
+	case signs:
+		return &instrumentedSigner{i, signer}
+	case lists:
+		return &instrumentedLister{i, lister}
+	}
+	return i
+}
+
+// instrumentedSigner is an instrumentedFileStore signing URLs with the store. Signing is not timed, as it does not
+// contact the store.
+type instrumentedSigner struct {
+	*instrumentedFileStore
+	URLSigner
+}
+
+// instrumentedLister is an instrumentedFileStore timing the listing of the store.
+type instrumentedLister struct {
+	*instrumentedFileStore
+	lister FileLister
+}
+
+func (i *instrumentedLister) Keys(prefix string) ([]string, error) {
+	return observe(i.metrics, storeFile, "Keys", func() ([]string, error) { return i.lister.Keys(prefix) })
+}
+
+// instrumentedSignerLister is an instrumentedFileStore for stores that both sign URLs and list files.
+type instrumentedSignerLister struct {
+	instrumentedLister
+	URLSigner
+}
+
+// instrumentedMeta times the operations of a MetaStore made while serving requests. Administrative operations are
+// passed through untimed.
+type instrumentedMeta struct {
+	MetaStore
+	metrics *Metrics
+}
+
+func (i *instrumentedMeta) FileKey() (string, error) {
+	return observe(i.metrics, storeMeta, "FileKey", i.MetaStore.FileKey)
+}
+
+func (i *instrumentedMeta) FilePut(details UploadDetails) error {
+	return observeErr(i.metrics, storeMeta, "FilePut", func() error { return i.MetaStore.FilePut(details) })
+}
+
+func (i *instrumentedMeta) FileGet(key string) (*UploadDetails, error) {
+	return observe(i.metrics, storeMeta, "FileGet", func() (*UploadDetails, error) { return i.MetaStore.FileGet(key) })
+}
+
+func (i *instrumentedMeta) FileDownload(key string) (*UploadDetails, error) {
+	return observe(i.metrics, storeMeta, "FileDownload", func() (*UploadDetails, error) { return i.MetaStore.FileDownload(key) })
+}
+
+func (i *instrumentedMeta) FileDelete(key string) error {
+	return observeErr(i.metrics, storeMeta, "FileDelete", func() error { return i.MetaStore.FileDelete(key) })
+}
+
+func (i *instrumentedMeta) UserFiles(user string) ([]UploadDetails, error) {
+	return observe(i.metrics, storeMeta, "UserFiles", func() ([]UploadDetails, error) { return i.MetaStore.UserFiles(user) })
+}
+
+func (i *instrumentedMeta) ExpiredFiles(before time.Time) ([]string, error) {
+	return observe(i.metrics, storeMeta, "ExpiredFiles", func() ([]string, error) { return i.MetaStore.ExpiredFiles(before) })
+}
+
+func (i *instrumentedMeta) UserUsage(user string) (*Usage, error) {
+	return observe(i.metrics, storeMeta, "UserUsage", func() (*Usage, error) { return i.MetaStore.UserUsage(user) })
+}
+
+func (i *instrumentedMeta) BlobAcquire(hash, key string) (*Blob, error) {
+	return observe(i.metrics, storeMeta, "BlobAcquire", func() (*Blob, error) { return i.MetaStore.BlobAcquire(hash, key) })
+}
+
+func (i *instrumentedMeta) BlobRelease(hash string) (*Blob, error) {
+	return observe(i.metrics, storeMeta, "BlobRelease", func() (*Blob, error) { return i.MetaStore.BlobRelease(hash) })
+}
+
+func (i *instrumentedMeta) PartialPut(upload PartialUpload) error {
+	return observeErr(i.metrics, storeMeta, "PartialPut", func() error { return i.MetaStore.PartialPut(upload) })
+}
+
+func (i *instrumentedMeta) PartialGet(id string) (*PartialUpload, error) {
+	return observe(i.metrics, storeMeta, "PartialGet", func() (*PartialUpload, error) { return i.MetaStore.PartialGet(id) })
+}
+
+func (i *instrumentedMeta) PartialDelete(id string) error {
+	return observeErr(i.metrics, storeMeta, "PartialDelete", func() error { return i.MetaStore.PartialDelete(id) })
+}
+
+func (i *instrumentedMeta) StalePartials(before time.Time) ([]string, error) {
+	return observe(i.metrics, storeMeta, "StalePartials", func() ([]string, error) { return i.MetaStore.StalePartials(before) })
+}
+
+func (i *instrumentedMeta) LinkPut(link Link) error {
+	return observeErr(i.metrics, storeMeta, "LinkPut", func() error { return i.MetaStore.LinkPut(link) })
+}
+
+func (i *instrumentedMeta) LinkClick(key string) (*Link, error) {
+	return observe(i.metrics, storeMeta, "LinkClick", func() (*Link, error) { return i.MetaStore.LinkClick(key) })
+}
+
+func (i *instrumentedMeta) LinkDelete(key string) error {
+	return observeErr(i.metrics, storeMeta, "LinkDelete", func() error { return i.MetaStore.LinkDelete(key) })
+}
+
+func (i *instrumentedMeta) UserLinks(user string) ([]Link, error) {
+	return observe(i.metrics, storeMeta, "UserLinks", func() ([]Link, error) { return i.MetaStore.UserLinks(user) })
+}
+
+func (i *instrumentedMeta) UserByAuthToken(token string) (*auth.User, error) {
+	return observe(i.metrics, storeMeta, "UserByAuthToken", func() (*auth.User, error) { return i.MetaStore.UserByAuthToken(token) })
+}
+
+func (i *instrumentedMeta) UserByName(name string) (*auth.User, error) {
+	return observe(i.metrics, storeMeta, "UserByName", func() (*auth.User, error) { return i.MetaStore.UserByName(name) })
+}
+
+func (i *instrumentedMeta) AuditAppend(event AuditEvent) error {
+	return observeErr(i.metrics, storeMeta, "AuditAppend", func() error { return i.MetaStore.AuditAppend(event) })
+}
+
+func (i *instrumentedMeta) DownloadAdd(key string, at time.Time) error {
+	return observeErr(i.metrics, storeMeta, "DownloadAdd", func() error { return i.MetaStore.DownloadAdd(key, at) })
+}
+
+// requireMetricsAuth returns the handler requiring the bearer token, or basic auth with the username and password,
+// unless neither is set.
+func requireMetricsAuth(next http.Handler, token, username, password string) http.Handler {
+	if token == "" && username == "" {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var valid bool
+		if token != "" {
+			valid = subtle.ConstantTimeCompare([]byte(r.Header.Get(auth.HTTPHeaderName)), []byte("Bearer "+token)) == 1
+		} else {
+			user, pass, ok := r.BasicAuth()
+			valid = ok && subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1 &&
+				subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+			w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		}
+		if !valid {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (u *Uploader) metricsGet(w http.ResponseWriter, r *http.Request) {
+	if u.metricsRoute == nil {
+		http.NotFound(w, r)
+		return
+	}
+	u.metricsRoute.ServeHTTP(w, r)
+}
+
+// MetricsHandler returns the handler serving metrics with the configured auth, or nil if metrics are disabled.
+func (u *Uploader) MetricsHandler() http.Handler {
+	return u.metricsHandler
+}
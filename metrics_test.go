@@ -0,0 +1,160 @@
+package uploader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"uploader/internal/auth"
+)
+
+// newTestMetricsUploader creates an uploader with instrumented stores and metrics served at metricsPath, as configured
+// by NewUploaderFromConfig.
+func newTestMetricsUploader(meta MetaStore, store FileStore) (*Uploader, *Metrics) {
+	m := NewMetrics(meta)
+	meta, store = &instrumentedMeta{MetaStore: meta, metrics: m}, instrumentFileStore(store, m)
+	u := NewUploaderHTTP(baseURL, meta, store)
+	u.metrics, u.metricsHandler, u.metricsRoute = m, m.Handler(), m.Handler()
+	return u, m
+}
+
+func TestMetricsHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	uploader, _ := newTestMetricsUploader(meta, newMemoryFileStore())
+	serve := func(request *http.Request) *httptest.ResponseRecorder {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		return response
+	}
+
+	assertStatusCode(t, serve(uploadRequest(t, user.AuthToken)), http.StatusAccepted)
+	assertStatusCode(t, serve(uploadRequest(t, "wrong")), http.StatusUnauthorized)
+	assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/files/1", nil)), http.StatusOK)
+	assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/files/missing", nil)), http.StatusNotFound)
+	assertStatusCode(t, serve(httptest.NewRequest(http.MethodGet, "/missing", nil)), http.StatusNotFound)
+
+	response := serve(httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	assertStatusCode(t, response, http.StatusOK)
+	body := response.Body.String()
+	for _, line := range []string{
+		`uploader_http_requests_total{route="/uploads/{user}",method="POST",status="202"} 1`,
+		`uploader_http_requests_total{route="/uploads/{user}",method="POST",status="401"} 1`,
+		`uploader_http_requests_total{route="/files/{key}",method="GET",status="200"} 1`,
+		`uploader_http_requests_total{route="/files/{key}",method="GET",status="404"} 1`,
+		`uploader_http_requests_total{route="unmatched",method="GET",status="404"} 1`,
+		`uploader_http_request_duration_seconds_count{route="/files/{key}",method="GET"} 2`,
+		`uploader_uploaded_bytes_total 13`,
+		`uploader_served_bytes_total 13`,
+		`uploader_upload_size_bytes_bucket{le="1024"} 1`,
+		`uploader_auth_failures_total{reason="access token is invalid"} 1`,
+		`uploader_store_operation_duration_seconds_count{store="file",operation="Put"} 1`,
+		`uploader_store_operation_duration_seconds_count{store="file",operation="Get"} 1`,
+		`uploader_user_stored_bytes{user="test_user"} 13`,
+		`uploader_user_stored_objects{user="test_user"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("expected metrics to contain %s", line)
+		}
+	}
+	// Missing files are expected, so are not store errors.
+	if strings.Contains(body, "uploader_store_operation_errors_total{") {
+		t.Errorf("expected no store errors, got %s", body)
+	}
+}
+
+func TestMetrics_StoreErrors(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	uploader, m := newTestMetricsUploader(meta, failingFileStore{newMemoryFileStore()})
+
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, uploadRequest(t, user.AuthToken))
+	assertStatusCode(t, response, http.StatusInternalServerError)
+	if errors := m.storeErrors.Value(storeFile, "Put"); errors != 1 {
+		t.Errorf("expected failed put to be counted, got %v", errors)
+	}
+	if uploaded := m.uploadedBytes.Value(); uploaded != 0 {
+		t.Errorf("expected failed upload to not be counted, got %v bytes", uploaded)
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	uploader := NewUploaderHTTP(baseURL, newTestMeta(), newMemoryFileStore())
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, metricsPath, nil))
+	assertStatusCode(t, response, http.StatusNotFound)
+	if uploader.MetricsHandler() != nil {
+		t.Error("expected no metrics handler")
+	}
+}
+
+func TestMetricsCfg(t *testing.T) {
+	m := NewMetrics(newTestMeta())
+	tests := map[string]struct {
+		cfg    metricsCfg
+		header string
+		basic  []string
+		status int
+	}{
+		"public":         {metricsCfg{}, "", nil, 200},
+		"token":          {metricsCfg{Token: "secret"}, "Bearer secret", nil, 200},
+		"wrong token":    {metricsCfg{Token: "secret"}, "Bearer wrong", nil, 401},
+		"missing token":  {metricsCfg{Token: "secret"}, "", nil, 401},
+		"basic":          {metricsCfg{Username: "prometheus", Password: "secret"}, "", []string{"prometheus", "secret"}, 200},
+		"wrong password": {metricsCfg{Username: "prometheus", Password: "secret"}, "", []string{"prometheus", "wrong"}, 401},
+		"missing basic":  {metricsCfg{Username: "prometheus", Password: "secret"}, "", nil, 401},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			handler, err := test.cfg.handler(m)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			request := httptest.NewRequest(http.MethodGet, metricsPath, nil)
+			if test.header != "" {
+				request.Header.Set(auth.HTTPHeaderName, test.header)
+			}
+			if test.basic != nil {
+				request.SetBasicAuth(test.basic[0], test.basic[1])
+			}
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+			if response.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, response.Code)
+			}
+		})
+	}
+
+	for name, cfg := range map[string]metricsCfg{
+		"token and username": {Token: "secret", Username: "prometheus", Password: "secret"},
+		"no password":        {Username: "prometheus"},
+	} {
+		if _, err := cfg.handler(m); err == nil {
+			t.Errorf("%s: expected configuration to be rejected", name)
+		}
+	}
+}
+
+func TestInstrumentFileStore(t *testing.T) {
+	s3Store, _ := newTestS3Store(t)
+	tests := map[string]struct {
+		store        FileStore
+		signs, lists bool
+	}{
+		"memory":   {newMemoryFileStore(), false, true},
+		"unlisted": {unlistedFileStore{newMemoryFileStore()}, false, false},
+		"s3":       {s3Store, true, true},
+	}
+	for name, test := range tests {
+		store := instrumentFileStore(test.store, NewMetrics(newTestMeta()))
+		if _, signs := store.(URLSigner); signs != test.signs {
+			t.Errorf("%s: expected URLSigner to be %t", name, test.signs)
+		}
+		if _, lists := store.(FileLister); lists != test.lists {
+			t.Errorf("%s: expected FileLister to be %t", name, test.lists)
+		}
+	}
+}
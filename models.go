@@ -2,6 +2,7 @@ package uploader
 
 import (
 	"net/url"
+	"time"
 
 	"uploader/internal/responses"
 )
@@ -11,6 +12,11 @@ type UploadResponseBody struct {
 	DeleteURL string `json:"delete_url"`
 	Size      int    `json:"size"`
 	Filename  string `json:"filename"`
+
+	// PreviewURL is the preview page of the upload, set when preview pages are enabled.
+	PreviewURL string `json:"preview_url,omitempty"`
+	// PasteURL is the highlighted view of the upload, set for text uploads.
+	PasteURL string `json:"paste_url,omitempty"`
 }
 
 type UploadResponse struct {
@@ -24,6 +30,49 @@ func (u *UploadResponse) FromDetails(details *UploadDetails) {
 	u.Results.DeleteURL = details.deleteUrl
 }
 
+// UploadInfo is the representation of an upload returned to its owner, including the built URLs.
+type UploadInfo struct {
+	UploadDetails
+	URL       string `json:"url"`
+	DeleteURL string `json:"delete_url"`
+	// HasPassword replaces the password hash, which is never returned.
+	HasPassword bool `json:"password,omitempty"`
+}
+
+// Protected reports whether the upload has a password or a limited number of downloads.
+func (u UploadInfo) Protected() bool {
+	return u.HasPassword || u.DownloadsLeft != nil
+}
+
+type UploadListResponse struct {
+	responses.ResponseHeader
+	Results struct {
+		Uploads    []UploadInfo `json:"uploads"`
+		NextCursor string       `json:"next_cursor"`
+	} `json:"results"`
+}
+
+func (u *UploadListResponse) FromList(list *UploadList, base *url.URL) {
+	u.Ok = true
+	u.Results.Uploads = make([]UploadInfo, 0, len(list.Uploads))
+	for _, details := range list.Uploads {
+		u.Results.Uploads = append(u.Results.Uploads, NewUploadInfo(details, base))
+	}
+	u.Results.NextCursor = list.NextCursor
+}
+
+// UploadState is the stage of an upload between the MetaStore and the FileStore. Uploads are pending while their file
+// is written and deleting while it is removed, and are only served, listed and counted once committed. Fsck rolls back
+// pending uploads and finishes deleting uploads left by an interrupted upload or delete.
+type UploadState string
+
+const (
+	// UploadCommitted is the empty state, so that uploads stored before states were recorded are committed.
+	UploadCommitted UploadState = ""
+	UploadPending   UploadState = "pending"
+	UploadDeleting  UploadState = "deleting"
+)
+
 type UploadDetails struct {
 	Key         string `json:"key"`
 	DeleteKey   string `json:"delete"`
@@ -31,11 +80,63 @@ type UploadDetails struct {
 	Size        int64  `json:"size"`
 	ContentType string `json:"type"`
 	User        string `json:"user"`
+	// Hash is the hex encoded SHA-256 of the file contents, used as the entity tag when serving the file.
+	Hash string `json:"hash,omitempty"`
+	// Blob is the FileStore key of the content, which may be shared between uploads with the same hash.
+	// Uploads made before deduplication have no blob and are stored under their own key.
+	Blob     string    `json:"blob,omitempty"`
+	Uploaded time.Time `json:"uploaded"`
+	// Expires is the time after which the upload is no longer served, or nil if it does not expire.
+	Expires *time.Time `json:"expires,omitempty"`
+	// Language is the highlighting language of a paste, and is empty for uploads that were not pasted.
+	Language string `json:"language,omitempty"`
+
+	// Encrypted marks uploads encrypted by the client. The server never holds their key, and serves them through a
+	// viewer that decrypts them in the browser.
+	Encrypted bool `json:"encrypted,omitempty"`
+
+	// PasswordHash is the encoded hash of the password needed to download the upload, empty if it has none.
+	PasswordHash string `json:"password_hash,omitempty"`
+	// DownloadsLeft is the number of downloads before the upload is removed, or nil if downloads are not limited.
+	DownloadsLeft *int64 `json:"downloads_left,omitempty"`
+
+	State UploadState `json:"state,omitempty"`
 
 	url       string
 	deleteUrl string
 }
 
+// Committed reports whether the upload has been completely stored.
+func (u *UploadDetails) Committed() bool {
+	return u.State == UploadCommitted
+}
+
+// StorageKey returns the key of the upload contents within the FileStore.
+func (u *UploadDetails) StorageKey() string {
+	if u.Blob != "" {
+		return u.Blob
+	}
+	return u.Key
+}
+
+// ETag returns the quoted entity tag for the upload, or an empty string if no content hash is known.
+func (u *UploadDetails) ETag() string {
+	if u.Hash == "" {
+		return ""
+	}
+	return `"` + u.Hash + `"`
+}
+
+// NewUploadInfo returns the details of an upload along with its public URLs.
+func NewUploadInfo(details UploadDetails, base *url.URL) UploadInfo {
+	details.BuildUrl(base)
+	hasPassword := details.PasswordHash != ""
+	details.PasswordHash = ""
+	return UploadInfo{details, details.url, details.deleteUrl, hasPassword}
+}
+
+// BuildUrl builds the public URLs of the upload. The URL of an encrypted upload is its viewer, which clients complete
+// with the key as the URL fragment, so that the key is never sent to the server.
 func (u *UploadDetails) BuildUrl(base *url.URL) {
 	target := base.JoinPath("/files/", u.Key)
 	u.url = target.String()
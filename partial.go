@@ -0,0 +1,137 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// PartialUpload tracks the state of a resumable upload that has not yet been completed.
+// The received data is staged in the FileStore as one object per received chunk.
+type PartialUpload struct {
+	ID       string  `json:"id"`
+	User     string  `json:"user"`
+	Filename string  `json:"name"`
+	Length   int64   `json:"length"`
+	Offset   int64   `json:"offset"`
+	Chunks   []int64 `json:"chunks"`
+	// ExpiresIn is the lifetime requested when the upload was created.
+	ExpiresIn time.Duration `json:"expires_in,omitempty"`
+	// Updated is when the upload was created or last received data.
+	Updated time.Time `json:"updated"`
+}
+
+// defaultPartialTTL is how long a partial upload may go without receiving data before it is removed as abandoned.
+const defaultPartialTTL = 24 * time.Hour
+
+// partialKeyPrefix distinguishes the staged chunks of partial uploads within the FileStore.
+const partialKeyPrefix = ".partial-"
+
+// ChunkKey returns the FileStore key used to stage the chunk at the given index.
+func (p *PartialUpload) ChunkKey(index int) string {
+	return fmt.Sprintf("%s%s-%d", partialKeyPrefix, p.ID, index)
+}
+
+type PartialMeta interface {
+	PartialPut(upload PartialUpload) error
+	PartialGet(id string) (*PartialUpload, error)
+	PartialDelete(id string) error
+	// StalePartials returns the IDs of partial uploads last updated before the given time.
+	StalePartials(before time.Time) ([]string, error)
+}
+
+// chunkedFile presents the staged chunks of a partial upload as a single seekable file.
+type chunkedFile struct {
+	store   FileStore
+	upload  *PartialUpload
+	offset  int64
+	current io.ReadCloser
+	// remaining is the number of bytes left in the current chunk reader.
+	remaining int64
+}
+
+func newChunkedFile(store FileStore, upload *PartialUpload) *chunkedFile {
+	return &chunkedFile{store: store, upload: upload}
+}
+
+func (c *chunkedFile) Read(p []byte) (int, error) {
+	if c.offset >= c.upload.Offset {
+		return 0, io.EOF
+	}
+	if c.current == nil {
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.current.Read(p)
+	c.offset += int64(n)
+	c.remaining -= int64(n)
+	if c.remaining == 0 {
+		c.current.Close()
+		c.current = nil
+		err = nil
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// open opens the chunk containing the current offset and positions the reader at that offset.
+func (c *chunkedFile) open() error {
+	start := int64(0)
+	for i, size := range c.upload.Chunks {
+		if c.offset < start+size {
+			reader, err := c.store.Get(c.upload.ChunkKey(i))
+			if err != nil {
+				return err
+			}
+			skip := c.offset - start
+			if seeker, ok := reader.(io.Seeker); ok {
+				_, err = seeker.Seek(skip, io.SeekStart)
+			} else {
+				_, err = io.CopyN(io.Discard, reader, skip)
+			}
+			if err != nil {
+				reader.Close()
+				return err
+			}
+			c.current = reader
+			c.remaining = size - skip
+			return nil
+		}
+		start += size
+	}
+	return io.EOF
+}
+
+func (c *chunkedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.offset
+	case io.SeekEnd:
+		offset += c.upload.Offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	if c.current != nil {
+		c.current.Close()
+		c.current = nil
+	}
+	c.offset = offset
+	return offset, nil
+}
+
+func (c *chunkedFile) Close() error {
+	if c.current != nil {
+		return c.current.Close()
+	}
+	return nil
+}
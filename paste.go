@@ -0,0 +1,186 @@
+package uploader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"uploader/internal/highlight"
+	"uploader/internal/responses"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Pastes are text uploads sent as the raw request body, viewed with syntax highlighting at /p/{key}. Text uploaded
+// as a file can also be viewed there, highlighted according to its file extension.
+
+const (
+	pastePath = "/p/"
+	// pasteContentType is the content type pastes are stored and served with.
+	pasteContentType = "text/plain; charset=utf-8"
+	// maxPasteSize is the largest paste accepted, and the largest text upload shown highlighted.
+	maxPasteSize = 1 << 20
+	// languageField is the query parameter holding the language of a paste, falling back to languageHeader.
+	languageField  = "lang"
+	languageHeader = "X-Paste-Language"
+	// defaultPasteName is the filename of pastes created without one.
+	defaultPasteName = "paste"
+)
+
+// pasteable reports whether uploads of the content type can be viewed as a paste.
+func pasteable(contentType string) bool {
+	return strings.HasPrefix(contentType, "text/")
+}
+
+type pasteFile struct {
+	*bytes.Reader
+}
+
+func (p pasteFile) Close() error {
+	return nil
+}
+
+// pasteHandler creates a paste from the request body. The language is given by the lang query parameter or the
+// X-Paste-Language header, and defaults to plain text.
+func (u *Uploader) pasteHandler(w http.ResponseWriter, r *http.Request) {
+	response := &UploadResponse{}
+	limit := int64(maxPasteSize)
+	if max := u.us.MaxUploadSize(); max > 0 && max < limit {
+		limit = max
+	}
+	// The body is read before the options, as parsing the form would otherwise consume bodies sent as form data.
+	contents, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	var maxBytesError *http.MaxBytesError
+	if errors.As(err, &maxBytesError) {
+		uploadError(w, response, ErrTooLarge)
+		return
+	} else if err != nil {
+		responses.Error(w, response, http.StatusBadRequest, -1001, "failed to read paste")
+		return
+	}
+	if len(contents) == 0 || !utf8.Valid(contents) {
+		responses.Error(w, response, http.StatusBadRequest, -1001, "paste must be non-empty UTF-8 text")
+		return
+	}
+	opts, err := uploadOptions(r)
+	if err != nil {
+		responses.Error(w, response, http.StatusBadRequest, -1005, err.Error())
+		return
+	}
+	language := r.URL.Query().Get(languageField)
+	if language == "" {
+		language = r.Header.Get(languageHeader)
+	}
+	if language == "" {
+		language = highlight.PlainText
+	}
+	var supported bool
+	if opts.Language, supported = highlight.Language(language); !supported {
+		responses.Error(w, response, http.StatusBadRequest, -1010, fmt.Sprintf("unsupported language %q", language))
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		name = defaultPasteName
+	}
+
+	details, err := u.us.Upload(pasteFile{bytes.NewReader(contents)}, name, int64(len(contents)), pathUser(r), opts)
+	if err != nil {
+		uploadError(w, response, err)
+		return
+	}
+	u.uploaded(r, details)
+	details.BuildUrl(u.baseURL)
+	response.FromDetails(details)
+	u.linkViews(response, details)
+	responses.Json(w, response, http.StatusAccepted)
+}
+
+// pasteLine is a numbered line of a highlighted paste.
+type pasteLine struct {
+	Number int
+	Tokens []highlight.Token
+}
+
+type pastePage struct {
+	Filename string
+	Language string
+	Size     int64
+	User     string
+	// Lines is empty when the paste is too large to highlight.
+	Lines  []pasteLine
+	RawURL string
+}
+
+// paste opens a text upload for viewing, writing an error response if it can not be viewed.
+func (u *Uploader) paste(w http.ResponseWriter, r *http.Request, key string) (*UploadDetails, io.ReadCloser, bool) {
+	response := &responses.BaseResponse{}
+	details, reader, err := u.us.Get(key, requestPassword(r))
+	if u.passwordError(w, r, err) {
+		return nil, nil, false
+	} else if errors.Is(err, os.ErrNotExist) {
+		responses.Error(w, response, 404, -1004, "file not found")
+		return nil, nil, false
+	} else if errors.Is(err, ErrExpired) {
+		responses.Error(w, response, http.StatusGone, -1006, "file has expired")
+		return nil, nil, false
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return nil, nil, false
+	}
+	if !pasteable(details.ContentType) {
+		reader.Close()
+		responses.Error(w, response, 404, -1004, "file is not text")
+		return nil, nil, false
+	}
+	return details, reader, true
+}
+
+func (u *Uploader) pasteView(w http.ResponseWriter, r *http.Request) {
+	key := chi.URLParam(r, "key")
+	details, reader, ok := u.paste(w, r, key)
+	if !ok {
+		return
+	}
+	defer reader.Close()
+	page := pastePage{
+		Filename: details.Filename,
+		Language: details.Language,
+		Size:     details.Size,
+		User:     details.User,
+		RawURL:   u.baseURL.JoinPath(pastePath, key, "raw").String(),
+	}
+	if page.Language == "" {
+		page.Language = highlight.FromFilename(details.Filename)
+	}
+	if details.Size <= maxPasteSize {
+		contents, err := io.ReadAll(io.LimitReader(reader, maxPasteSize))
+		if err != nil {
+			responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+			return
+		}
+		for i, tokens := range highlight.Lines(string(contents), page.Language) {
+			page.Lines = append(page.Lines, pasteLine{Number: i + 1, Tokens: tokens})
+		}
+	}
+	renderPage(w, http.StatusOK, "paste.html", page)
+}
+
+// pasteRaw serves the paste as plain text, so that HTML and other markup is never rendered by the browser.
+func (u *Uploader) pasteRaw(w http.ResponseWriter, r *http.Request) {
+	details, reader, ok := u.paste(w, r, chi.URLParam(r, "key"))
+	if !ok {
+		return
+	}
+	defer reader.Close()
+	w.Header().Set("Content-Type", pasteContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Length", strconv.FormatInt(details.Size, 10))
+	io.Copy(w, reader)
+}
@@ -0,0 +1,100 @@
+package uploader
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"uploader/internal/auth"
+)
+
+func pasteRequest(target, token, body string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", token))
+	return request
+}
+
+func TestPasteHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, pasteRequest("/uploads/test_user/paste?lang=golang&name=main.go", user.AuthToken, "<html>\nfunc main() {}\n"))
+	assertStatusCode(t, response, http.StatusAccepted)
+	decoded, err := decodeUploadResponse(response)
+	if err != nil {
+		t.Fatalf("failed to decode response: %s", err)
+	}
+	if decoded.Results.PasteURL != "http://localhost/p/1" {
+		t.Errorf("expected paste url, got %q", decoded.Results.PasteURL)
+	}
+	details := meta.files["1"]
+	if details.Language != "go" || details.ContentType != pasteContentType || details.Filename != "main.go" {
+		t.Errorf("unexpected paste details %+v", details)
+	}
+	list, _ := NewUploadService(meta, store).List("test_user", ListOptions{})
+	if len(list.Uploads) != 1 {
+		t.Errorf("expected paste in listing, got %d uploads", len(list.Uploads))
+	}
+
+	t.Run("view", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/p/1", nil))
+		assertStatusCode(t, response, http.StatusOK)
+		body := response.Body.String()
+		for _, want := range []string{`id="L2"`, `<a href="#L2">2</a>`, `<span class="kw">func</span>`, `&lt;html&gt;`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected paste view to contain %s, got %s", want, body)
+			}
+		}
+	})
+	t.Run("raw", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/p/1/raw", nil))
+		assertStatusCode(t, response, http.StatusOK)
+		if contentType := response.Header().Get("Content-Type"); contentType != pasteContentType {
+			t.Errorf("expected raw paste as plain text, got %s", contentType)
+		}
+		if response.Body.String() != "<html>\nfunc main() {}\n" {
+			t.Errorf("unexpected raw paste %q", response.Body.String())
+		}
+	})
+	t.Run("text upload", func(t *testing.T) {
+		meta.addFile("text", "text/plain; charset=utf-8")
+		store.Put("text", strings.NewReader("Hello, World!"))
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/p/text", nil))
+		assertStatusCode(t, response, http.StatusOK)
+	})
+	t.Run("not text", func(t *testing.T) {
+		meta.addFile("image", "image/png")
+		store.Put("image", strings.NewReader("PNG"))
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/p/image", nil))
+		assertStatusCode(t, response, http.StatusNotFound)
+	})
+
+	invalid := map[string]struct {
+		target string
+		body   string
+		status int
+	}{
+		"unknown language": {"/uploads/test_user/paste?lang=cobol", "text", http.StatusBadRequest},
+		"empty":            {"/uploads/test_user/paste", "", http.StatusBadRequest},
+		"binary":           {"/uploads/test_user/paste", "\xff\xfe", http.StatusBadRequest},
+		"too large":        {"/uploads/test_user/paste", strings.Repeat("a", maxPasteSize+1), http.StatusRequestEntityTooLarge},
+		"other user":       {"/uploads/other_user/paste", "text", http.StatusForbidden},
+	}
+	for name, test := range invalid {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, pasteRequest(test.target, user.AuthToken, test.body))
+			assertStatusCode(t, response, test.status)
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"uploader/internal/responses"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Preview pages are HTML landing pages for uploads, carrying Open Graph tags so that chat applications show an
+// embedded preview of the file rather than a bare link.
+
+const previewPath = "/v/"
+
+// PreviewPage is the data preview templates are executed with. Configured templates may use any of its fields, and
+// the formatSize function.
+type PreviewPage struct {
+	Key         string
+	Filename    string
+	ContentType string
+	// Kind is "image", "video" or "audio" for media that can be embedded, otherwise empty.
+	Kind     string
+	Size     int64
+	User     string
+	Uploaded time.Time
+	Expires  *time.Time
+	// URL is the upload itself, and DownloadURL the upload served as an attachment.
+	URL         string
+	DownloadURL string
+	// ThumbnailURL is the largest thumbnail of the upload, or empty if it has none.
+	ThumbnailURL string
+	// PageURL is the preview page itself.
+	PageURL string
+}
+
+// previews holds the templates used to render preview pages.
+type previews struct {
+	// page is the template for users without their own, which defaults to the embedded template.
+	page  *template.Template
+	users map[string]*template.Template
+	// link makes upload responses and scripts link to the preview page.
+	link bool
+}
+
+func parsePreviewTemplate(path string) (*template.Template, error) {
+	page, err := template.New(filepath.Base(path)).Funcs(webFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preview template: %w", err)
+	}
+	return page, nil
+}
+
+// newPreviews loads the preview templates from the given paths, where an empty path uses the embedded template.
+func newPreviews(path string, users map[string]string, link bool) (*previews, error) {
+	p := &previews{page: webTemplates.Lookup("preview.html"), users: map[string]*template.Template{}, link: link}
+	var err error
+	if path != "" {
+		if p.page, err = parsePreviewTemplate(path); err != nil {
+			return nil, err
+		}
+	}
+	for name, path := range users {
+		if p.users[name], err = parsePreviewTemplate(path); err != nil {
+			return nil, fmt.Errorf("user %s: %w", name, err)
+		}
+	}
+	return p, nil
+}
+
+func (p *previews) template(user string) *template.Template {
+	if page, found := p.users[user]; found {
+		return page
+	}
+	return p.page
+}
+
+func previewURL(base *url.URL, key string) string {
+	return base.JoinPath(previewPath, key).String()
+}
+
+// previewKind returns the Open Graph media kind of the content type.
+func previewKind(contentType string) string {
+	kind, _, _ := strings.Cut(contentType, "/")
+	switch kind {
+	case "image", "video", "audio":
+		return kind
+	}
+	return ""
+}
+
+func (u *Uploader) newPreviewPage(details *UploadDetails) PreviewPage {
+	info := NewUploadInfo(*details, u.baseURL)
+	page := PreviewPage{
+		Key:         details.Key,
+		Filename:    details.Filename,
+		ContentType: details.ContentType,
+		Kind:        previewKind(details.ContentType),
+		Size:        details.Size,
+		User:        details.User,
+		Uploaded:    details.Uploaded,
+		Expires:     details.Expires,
+		URL:         info.URL,
+		DownloadURL: u.baseURL.JoinPath("/files/", details.Key, details.Filename).String(),
+		PageURL:     previewURL(u.baseURL, details.Key),
+	}
+	if details.Protected() {
+		// Embedding a protected upload would fail without its password, or use up its downloads.
+		page.Kind = ""
+		return page
+	}
+	if _, found := thumbnailTypes[details.ContentType]; found {
+		if sizes := u.us.ThumbnailSizes(); len(sizes) > 0 {
+			largest := sizes[0]
+			for _, size := range sizes {
+				if size > largest {
+					largest = size
+				}
+			}
+			page.ThumbnailURL = fmt.Sprintf("%s/thumb/%d", info.URL, largest)
+		}
+	}
+	return page
+}
+
+func (u *Uploader) previewGet(w http.ResponseWriter, r *http.Request) {
+	response := &responses.BaseResponse{}
+	if u.previews == nil {
+		responses.Error(w, response, 404, -1004, "file not found")
+		return
+	}
+	details, err := u.us.Info(chi.URLParam(r, "key"))
+	if errors.Is(err, os.ErrNotExist) {
+		responses.Error(w, response, 404, -1004, "file not found")
+		return
+	} else if err != nil {
+		responses.ErrorFromError(w, response, err)
+		return
+	}
+	if details.Expired(time.Now()) {
+		responses.Error(w, response, http.StatusGone, -1006, "file has expired")
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := u.previews.template(details.User).Execute(w, u.newPreviewPage(details)); err != nil {
+		log.Printf("failed to render preview of %s: %s", details.Key, err)
+	}
+}
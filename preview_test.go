@@ -0,0 +1,104 @@
+package uploader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"uploader/internal/auth"
+)
+
+func TestPreviewHTTP(t *testing.T) {
+	meta := newTestMeta()
+	meta.UserRegister("test_user")
+	meta.addFile("1", "image/png")
+	meta.addFile("2", "video/mp4")
+	meta.addFile("3", "application/zip")
+	meta.addFile("4", "image/png")
+	meta.files["4"].User = "other_user"
+	past := time.Now().Add(-time.Hour)
+	meta.addFile("5", "image/png")
+	meta.files["5"].Expires = &past
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	t.Run("disabled", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/v/1", nil))
+		assertStatusCode(t, response, http.StatusNotFound)
+	})
+
+	custom := filepath.Join(t.TempDir(), "custom.html")
+	if err := os.WriteFile(custom, []byte(`custom {{.Filename}} {{formatSize .Size}}`), 0o600); err != nil {
+		t.Fatalf("failed to write template: %s", err)
+	}
+	var err error
+	if uploader.previews, err = newPreviews("", map[string]string{"other_user": custom}, true); err != nil {
+		t.Fatalf("failed to load previews: %s", err)
+	}
+
+	tests := map[string]struct {
+		target string
+		status int
+		want   []string
+	}{
+		"image": {"/v/1", http.StatusOK, []string{
+			`<meta property="og:title" content="test_file">`,
+			`<meta property="og:image" content="http://localhost/files/1">`,
+			`uploaded by test_user`,
+		}},
+		"video":         {"/v/2", http.StatusOK, []string{`<meta property="og:video" content="http://localhost/files/2">`}},
+		"other":         {"/v/3", http.StatusOK, []string{`<meta property="og:type" content="website">`, `http://localhost/files/3/test_file`}},
+		"user template": {"/v/4", http.StatusOK, []string{"custom test_file 13 B"}},
+		"expired":       {"/v/5", http.StatusGone, nil},
+		"unknown":       {"/v/6", http.StatusNotFound, nil},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, test.target, nil))
+			assertStatusCode(t, response, test.status)
+			for _, want := range test.want {
+				if !strings.Contains(response.Body.String(), want) {
+					t.Errorf("expected preview to contain %s, got %s", want, response.Body.String())
+				}
+			}
+		})
+	}
+
+	t.Run("upload response", func(t *testing.T) {
+		user, _ := meta.UserByName("test_user")
+		token, _ := meta.TokenCreate(user.Name, "upload", auth.TokenOptions{})
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, uploadRequest(t, token.Secret))
+		assertStatusCode(t, response, http.StatusAccepted)
+		decoded, err := decodeUploadResponse(response)
+		if err != nil {
+			t.Fatalf("failed to decode response: %s", err)
+		}
+		if !strings.HasPrefix(decoded.Results.PreviewURL, "http://localhost/v/") {
+			t.Errorf("expected preview url in response, got %q", decoded.Results.PreviewURL)
+		}
+	})
+	t.Run("script", func(t *testing.T) {
+		user, _ := meta.UserByName("test_user")
+		if script, _ := uploader.UploadScript(user, ScriptShareX); !strings.Contains(script, "$json:results.preview_url$") {
+			t.Errorf("expected script to link to previews, got %s", script)
+		}
+	})
+}
+
+func TestNewPreviews_InvalidTemplate(t *testing.T) {
+	invalid := filepath.Join(t.TempDir(), "invalid.html")
+	os.WriteFile(invalid, []byte(`{{.Filename`), 0o600)
+	if _, err := newPreviews(invalid, nil, false); err == nil {
+		t.Error("expected invalid template to fail")
+	}
+	if _, err := newPreviews("", map[string]string{"test_user": "missing.html"}, false); err == nil {
+		t.Error("expected missing user template to fail")
+	}
+}
@@ -0,0 +1,220 @@
+package uploader
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"uploader/internal/responses"
+)
+
+// Uploads may be protected by a password, and may be limited to a number of downloads after which they are removed.
+// Protected uploads are only served through the uploader, never through signed URLs, thumbnails or previews.
+
+const (
+	// passwordField is the form field holding the password of a new upload, or the password given to download one.
+	// It falls back to passwordHeader.
+	passwordField  = "password"
+	passwordHeader = "X-Password"
+	// maxDownloadsField is the form field limiting the downloads of a new upload, falling back to maxDownloadsHeader.
+	maxDownloadsField  = "max_downloads"
+	maxDownloadsHeader = "X-Max-Downloads"
+
+	passwordHashScheme = "pbkdf2-sha256"
+	// passwordIterations follows the OWASP recommendation for PBKDF2-HMAC-SHA256.
+	passwordIterations = 600000
+	passwordSaltSize   = 16
+)
+
+var (
+	// ErrPasswordRequired is returned when downloading a password protected upload without a password.
+	ErrPasswordRequired = errors.New("password required")
+	ErrWrongPassword    = errors.New("wrong password")
+	// ErrNoDownloadsLeft is returned by the MetaStore when every download of an upload has been used.
+	ErrNoDownloadsLeft = errors.New("no downloads left")
+	// ErrPasswordBusy is returned when too many passwords are being checked to check another.
+	ErrPasswordBusy = errors.New("too many password attempts, try again later")
+)
+
+// pbkdf2 derives a 32 byte key from the password, as described in RFC 8018 with HMAC-SHA256.
+func pbkdf2(password, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write(binary.BigEndian.AppendUint32(nil, 1))
+	u := prf.Sum(nil)
+	key := append([]byte{}, u...)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// HashPassword returns the encoded hash of an upload password, holding the scheme, iterations and salt used.
+func HashPassword(password string) (string, error) {
+	salt := make([]byte, passwordSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	return encodePasswordHash(password, salt, passwordIterations), nil
+}
+
+func encodePasswordHash(password string, salt []byte, iterations int) string {
+	key := pbkdf2([]byte(password), salt, iterations)
+	return fmt.Sprintf("%s$%d$%s$%s", passwordHashScheme, iterations,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+// CheckPassword reports whether the password unlocks the upload. Uploads without a password are always unlocked.
+func (u *UploadDetails) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return true
+	}
+	parts := strings.Split(u.PasswordHash, "$")
+	if len(parts) != 4 || parts[0] != passwordHashScheme {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil || iterations < 1 {
+		return false
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	expected := encodePasswordHash(password, salt, iterations)
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(u.PasswordHash)) == 1
+}
+
+// passwordLimiter caps the number of passwords checked at once. Every check takes passwordIterations rounds of HMAC, so
+// without a cap anyone could keep every CPU busy by guessing the password of a protected upload.
+type passwordLimiter chan struct{}
+
+// newPasswordLimiter allows checks on half of the CPUs, leaving the remainder to serve other requests.
+func newPasswordLimiter() passwordLimiter {
+	checks := runtime.GOMAXPROCS(0) / 2
+	if checks < 1 {
+		checks = 1
+	}
+	return make(passwordLimiter, checks)
+}
+
+// check returns ErrWrongPassword unless the password unlocks the upload. When the limit is reached it fails with
+// ErrPasswordBusy rather than queueing, so that waiting requests do not pile up.
+func (l passwordLimiter) check(details *UploadDetails, password string) error {
+	select {
+	case l <- struct{}{}:
+	default:
+		return ErrPasswordBusy
+	}
+	defer func() { <-l }()
+	if !details.CheckPassword(password) {
+		return ErrWrongPassword
+	}
+	return nil
+}
+
+// Protected reports whether the upload has a password or a limited number of downloads.
+func (u *UploadDetails) Protected() bool {
+	return u.PasswordHash != "" || u.DownloadsLeft != nil
+}
+
+// burnReader removes the upload it reads once it is closed, after its last download.
+type burnReader struct {
+	io.ReadCloser
+	once sync.Once
+	burn func()
+}
+
+func (b *burnReader) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.burn)
+	return err
+}
+
+type seekableBurnReader struct {
+	*burnReader
+	io.Seeker
+}
+
+// burnAfterRead wraps the file of the last download of an upload, removing the upload once the file is closed.
+func (u *uploadService) burnAfterRead(file io.ReadCloser, details *UploadDetails) io.ReadCloser {
+	burn := &burnReader{ReadCloser: file, burn: func() {
+		if err := u.remove(details); err != nil {
+			log.Printf("failed to remove %s after its last download: %s", details.Key, err)
+			return
+		}
+		u.recordSystem(AuditDelete, details.Key, "last download")
+	}}
+	if seeker, ok := file.(io.Seeker); ok {
+		return &seekableBurnReader{burn, seeker}
+	}
+	return burn
+}
+
+// fullDownload removes the conditional and range headers from a request for an upload with limited downloads. Its
+// download has already been taken, so the response must hold the whole file rather than a part of it or nothing.
+func fullDownload(r *http.Request) *http.Request {
+	r = r.Clone(r.Context())
+	for _, name := range []string{"Range", "If-Range", "If-Match", "If-None-Match", "If-Modified-Since", "If-Unmodified-Since"} {
+		r.Header.Del(name)
+	}
+	return r
+}
+
+// requestPassword returns the password given to download an upload, from the header or a submitted prompt.
+func requestPassword(r *http.Request) string {
+	if password := r.Header.Get(passwordHeader); password != "" {
+		return password
+	}
+	if r.Method == http.MethodPost {
+		return r.PostFormValue(passwordField)
+	}
+	return ""
+}
+
+type passwordPage struct {
+	// StyleURL is the stylesheet of the page, which is served at several depths of path.
+	StyleURL string
+	Error    string
+}
+
+// passwordError writes the response for a password error returned when opening an upload, returning false if the
+// error is not a password error. Browsers are asked for the password, while clients that sent one get an error.
+func (u *Uploader) passwordError(w http.ResponseWriter, r *http.Request, err error) bool {
+	if errors.Is(err, ErrPasswordBusy) {
+		w.Header().Set("Retry-After", "1")
+		responses.Error(w, &responses.BaseResponse{}, http.StatusTooManyRequests, -1014, err.Error())
+		return true
+	}
+	if !errors.Is(err, ErrPasswordRequired) && !errors.Is(err, ErrWrongPassword) {
+		return false
+	}
+	if r.Header.Get(passwordHeader) != "" {
+		responses.Error(w, &responses.BaseResponse{}, http.StatusUnauthorized, -1012, err.Error())
+		return true
+	}
+	page := passwordPage{StyleURL: u.baseURL.JoinPath("/static/style.css").String()}
+	if errors.Is(err, ErrWrongPassword) {
+		page.Error = "Wrong password"
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	renderPage(w, http.StatusUnauthorized, "password.html", page)
+	return true
+}
@@ -0,0 +1,232 @@
+package uploader
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCheckPassword(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("unexpected error hashing password: %s", err)
+	}
+	if strings.Contains(hash, "secret") || !strings.HasPrefix(hash, passwordHashScheme+"$") {
+		t.Errorf("unexpected password hash %s", hash)
+	}
+	if other, _ := HashPassword("secret"); other == hash {
+		t.Error("expected hashes of the same password to be salted")
+	}
+
+	tests := map[string]struct {
+		hash     string
+		password string
+		want     bool
+	}{
+		"correct":     {hash, "secret", true},
+		"wrong":       {hash, "Secret", false},
+		"empty":       {hash, "", false},
+		"no password": {"", "anything", true},
+		"iterations":  {encodePasswordHash("secret", []byte("salt"), 2), "secret", true},
+		"malformed":   {"pbkdf2-sha256$x$c2FsdA$aGFzaA", "secret", false},
+		"scheme":      {"bcrypt$2$c2FsdA$aGFzaA", "secret", false},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			details := &UploadDetails{PasswordHash: test.hash}
+			if got := details.CheckPassword(test.password); got != test.want {
+				t.Errorf("expected %t, got %t", test.want, got)
+			}
+		})
+	}
+}
+
+func TestUploadService_Protected(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	service := NewUploadService(meta, store)
+	file, _ := os.Open("./test/data/test_file.txt")
+	defer file.Close()
+	details, err := service.Upload(file, "test_file.txt", 13, "test_user", UploadOptions{Password: "secret", MaxDownloads: 2})
+	if err != nil {
+		t.Fatalf("unexpected error uploading: %s", err)
+	}
+
+	if _, _, err := service.Get(details.Key, ""); !errors.Is(err, ErrPasswordRequired) {
+		t.Errorf("expected password to be required, got %v", err)
+	}
+	if _, _, err := service.Get(details.Key, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("expected wrong password, got %v", err)
+	}
+
+	got, reader, err := service.Get(details.Key, "secret")
+	if err != nil {
+		t.Fatalf("unexpected error downloading: %s", err)
+	}
+	reader.Close()
+	if *got.DownloadsLeft != 1 {
+		t.Errorf("expected failed attempts not to use downloads, got %d left", *got.DownloadsLeft)
+	}
+
+	_, reader, err = service.Get(details.Key, "secret")
+	if err != nil {
+		t.Fatalf("unexpected error on last download: %s", err)
+	}
+	if _, _, err := service.Get(details.Key, "secret"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected no downloads to be left during the last download, got %v", err)
+	}
+	if contents, _ := io.ReadAll(reader); string(contents) != "Hello, World!" {
+		t.Errorf("unexpected contents of last download %q", contents)
+	}
+	reader.Close()
+	if _, found := meta.files[details.Key]; found {
+		t.Error("expected upload to be removed after its last download")
+	}
+	if len(store.files) != 0 {
+		t.Errorf("expected stored file to be removed, got %d files", len(store.files))
+	}
+	want := []AuditEvent{{Action: AuditDelete, Actor: AuditActorSystem, Key: details.Key, Detail: "last download"}}
+	if diff := cmp.Diff(want, meta.audit, cmpopts.IgnoreFields(AuditEvent{}, "Time")); diff != "" {
+		t.Errorf("unexpected audit log %s", diff)
+	}
+}
+
+func TestProtectedHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	request := uploadRequest(t, user.AuthToken)
+	request.Header.Set(passwordHeader, "secret")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusAccepted)
+	if stored := meta.files["1"]; stored.PasswordHash == "" || stored.DownloadsLeft != nil {
+		t.Fatalf("expected upload to be password protected, got %+v", stored)
+	}
+
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/files/1", nil))
+	assertStatusCode(t, response, http.StatusUnauthorized)
+	if !strings.Contains(response.Body.String(), `name="password"`) {
+		t.Errorf("expected password prompt, got %s", response.Body.String())
+	}
+
+	prompt := func(password string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/files/1", strings.NewReader(url.Values{"password": {password}}.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		return response
+	}
+	response = prompt("wrong")
+	assertStatusCode(t, response, http.StatusUnauthorized)
+	if !strings.Contains(response.Body.String(), "Wrong password") {
+		t.Errorf("expected wrong password to be shown, got %s", response.Body.String())
+	}
+	response = prompt("secret")
+	assertStatusCode(t, response, http.StatusOK)
+	if response.Body.String() != "Hello, World!" {
+		t.Errorf("unexpected contents %q", response.Body.String())
+	}
+
+	request = httptest.NewRequest(http.MethodGet, "/files/1/test_file.txt", nil)
+	request.Header.Set(passwordHeader, "wrong")
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusUnauthorized)
+	assertJSONResponse(t, response)
+
+	request.Header.Set(passwordHeader, "secret")
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusOK)
+
+	request = httptest.NewRequest(http.MethodGet, "/uploads/test_user", nil)
+	request.Header.Set("Authorization", "Bearer "+user.AuthToken)
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	if body := response.Body.String(); strings.Contains(body, passwordHashScheme) || !strings.Contains(body, `"password":true`) {
+		t.Errorf("expected listing to mark the password without its hash, got %s", body)
+	}
+}
+
+func TestBurnAfterReadHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	request := uploadRequest(t, user.AuthToken)
+	request.Header.Set(maxDownloadsHeader, "1")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusAccepted)
+
+	// The download is taken by any request, so partial and conditional requests are answered with the whole file.
+	request = httptest.NewRequest(http.MethodGet, "/files/1", nil)
+	request.Header.Set("Range", "bytes=0-0")
+	request.Header.Set("If-None-Match", meta.files["1"].ETag())
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusOK)
+	if response.Body.String() != "Hello, World!" {
+		t.Errorf("expected last download to hold the whole file, got %q", response.Body.String())
+	}
+	if cc := response.Header().Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("expected limited download to not be cached, got %q", cc)
+	}
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/files/1", nil))
+	assertStatusCode(t, response, http.StatusNotFound)
+
+	request = uploadRequest(t, user.AuthToken)
+	request.Header.Set(maxDownloadsHeader, "0")
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusBadRequest)
+}
+
+func TestPasswordLimiter(t *testing.T) {
+	details := &UploadDetails{PasswordHash: encodePasswordHash("secret", []byte("salt"), 2)}
+	limiter := make(passwordLimiter, 1)
+	if err := limiter.check(details, "secret"); err != nil {
+		t.Errorf("unexpected error checking password: %v", err)
+	}
+	if err := limiter.check(details, "wrong"); !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("expected wrong password, got %v", err)
+	}
+	limiter <- struct{}{}
+	if err := limiter.check(details, "secret"); !errors.Is(err, ErrPasswordBusy) {
+		t.Errorf("expected check over the limit to be refused, got %v", err)
+	}
+}
+
+func TestPasswordBusyHTTP(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	meta.addFile("1", "text/plain")
+	meta.files["1"].PasswordHash = encodePasswordHash("secret", []byte("salt"), 2)
+	store.Put("1", strings.NewReader("Hello, World!"))
+	us := NewUploadService(meta, store)
+	us.passwords = make(passwordLimiter, 1)
+	us.passwords <- struct{}{}
+	uploader := newUploaderHTTP(baseURL, meta, store, us, nil)
+
+	request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+	request.Header.Set(passwordHeader, "secret")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusTooManyRequests)
+	if response.Header().Get("Retry-After") == "" {
+		t.Error("expected client to be told when to retry")
+	}
+}
@@ -0,0 +1,89 @@
+package uploader
+
+import "errors"
+
+var (
+	// ErrQuotaExceeded is returned when an upload would take the user over their byte or file count quota.
+	ErrQuotaExceeded = errors.New("upload quota exceeded")
+	// ErrTooLarge is returned when an upload is larger than the maximum upload size.
+	ErrTooLarge = errors.New("upload too large")
+)
+
+// Usage is the storage used by a user, counting the full size of every upload even when blobs are shared.
+type Usage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// QuotaLimits are the maximum storage for a user, where zero values are unlimited.
+type QuotaLimits struct {
+	MaxBytes int64 `json:"max_bytes"`
+	MaxFiles int64 `json:"max_files"`
+}
+
+// QuotaPolicy holds the default quota for all users, optionally overridden per user.
+// A quota set on the record of a user takes precedence over the policy.
+type QuotaPolicy struct {
+	QuotaLimits
+	// MaxUploadSize is the largest single upload accepted, or zero for no limit.
+	MaxUploadSize int64
+	Users         map[string]QuotaLimits
+}
+
+func (p QuotaPolicy) limits(user string) QuotaLimits {
+	if limits, found := p.Users[user]; found {
+		return limits
+	}
+	return p.QuotaLimits
+}
+
+// QuotaStatus reports the usage of a user against their quota.
+type QuotaStatus struct {
+	Used Usage `json:"used"`
+	QuotaLimits
+	MaxUploadSize int64 `json:"max_upload_size"`
+}
+
+// Allows reports whether a new upload of the given size fits within the quota.
+func (q *QuotaStatus) Allows(size int64) error {
+	if q.MaxUploadSize > 0 && size > q.MaxUploadSize {
+		return ErrTooLarge
+	}
+	if q.MaxBytes > 0 && q.Used.Bytes+size > q.MaxBytes {
+		return ErrQuotaExceeded
+	}
+	if q.MaxFiles > 0 && q.Used.Files+1 > q.MaxFiles {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+func (u *uploadService) Quota(user string) (*QuotaStatus, error) {
+	usage, err := u.meta.UserUsage(user)
+	if err != nil {
+		return nil, err
+	}
+	limits := u.quota.limits(user)
+	override, err := u.meta.UserQuota(user)
+	if err != nil {
+		return nil, err
+	}
+	if override != nil {
+		limits = *override
+	}
+	return &QuotaStatus{Used: *usage, QuotaLimits: limits, MaxUploadSize: u.quota.MaxUploadSize}, nil
+}
+
+// CheckQuota returns an error if the user may not make an upload of the given size.
+// The check is not atomic with the upload, so concurrent uploads may exceed the quota slightly.
+func (u *uploadService) CheckQuota(user string, size int64) error {
+	status, err := u.Quota(user)
+	if err != nil {
+		return err
+	}
+	return status.Allows(size)
+}
+
+func (u *uploadService) MaxUploadSize() int64 {
+	return u.quota.MaxUploadSize
+}
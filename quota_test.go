@@ -0,0 +1,111 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"uploader/internal/auth"
+)
+
+func TestQuotaStatus_Allows(t *testing.T) {
+	tests := map[string]struct {
+		status QuotaStatus
+		size   int64
+		err    error
+	}{
+		"unlimited":        {QuotaStatus{Used: Usage{Bytes: 1 << 40, Files: 1 << 20}}, 1 << 30, nil},
+		"within quota":     {QuotaStatus{Used: Usage{Bytes: 10, Files: 1}, QuotaLimits: QuotaLimits{MaxBytes: 20, MaxFiles: 2}}, 10, nil},
+		"bytes exceeded":   {QuotaStatus{Used: Usage{Bytes: 10}, QuotaLimits: QuotaLimits{MaxBytes: 20}}, 11, ErrQuotaExceeded},
+		"files exceeded":   {QuotaStatus{Used: Usage{Files: 2}, QuotaLimits: QuotaLimits{MaxFiles: 2}}, 1, ErrQuotaExceeded},
+		"upload too large": {QuotaStatus{MaxUploadSize: 5}, 6, ErrTooLarge},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := test.status.Allows(test.size); !errors.Is(err, test.err) {
+				t.Errorf("unexpected result, want %v got %v", test.err, err)
+			}
+		})
+	}
+}
+
+func TestQuotaHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	us := NewUploadService(meta, store)
+	us.quota = QuotaPolicy{
+		MaxUploadSize: 1024,
+		QuotaLimits:   QuotaLimits{MaxFiles: 1},
+		Users:         map[string]QuotaLimits{"big_user": {}},
+	}
+	uploader := newUploaderHTTP(baseURL, meta, store, us, nil)
+
+	t.Run("first upload", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, uploadRequest(t, user.AuthToken))
+		assertStatusCode(t, response, http.StatusAccepted)
+	})
+	t.Run("file count exceeded", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, uploadRequest(t, user.AuthToken))
+		assertStatusCode(t, response, http.StatusForbidden)
+		if len(store.files) != 1 {
+			t.Errorf("expected rejected upload to not be stored, %d files in store", len(store.files))
+		}
+	})
+	t.Run("quota endpoint", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/uploads/test_user/quota", nil)
+		request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", user.AuthToken))
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusOK)
+		decoded := &struct {
+			Results QuotaStatus `json:"results"`
+		}{}
+		if err := json.Unmarshal(response.Body.Bytes(), decoded); err != nil {
+			t.Fatalf("failed to decode response %s", err)
+		}
+		want := QuotaStatus{Used: Usage{Bytes: 13, Files: 1}, QuotaLimits: QuotaLimits{MaxFiles: 1}, MaxUploadSize: 1024}
+		if decoded.Results != want {
+			t.Errorf("unexpected quota status, want %+v got %+v", want, decoded.Results)
+		}
+	})
+
+	big, _ := meta.UserRegister("big_user")
+	t.Run("upload too large", func(t *testing.T) {
+		body := &bytes.Buffer{}
+		writer := multipart.NewWriter(body)
+		file, _ := writer.CreateFormFile(fileFieldName, "large.bin")
+		file.Write(make([]byte, 2<<20))
+		writer.Close()
+		request := httptest.NewRequest(http.MethodPost, "/uploads/big_user", body)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", big.AuthToken))
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusRequestEntityTooLarge)
+	})
+}
+
+func TestUploadService_QuotaOverride(t *testing.T) {
+	meta := newTestMeta()
+	meta.UserRegister("test_user")
+	us := NewUploadService(meta, newMemoryFileStore())
+	us.quota = QuotaPolicy{QuotaLimits: QuotaLimits{MaxFiles: 1}}
+	meta.UserSetQuota("test_user", &auth.Quota{MaxFiles: 5, MaxBytes: 100})
+
+	status, err := us.Quota("test_user")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if want := (QuotaLimits{MaxFiles: 5, MaxBytes: 100}); status.QuotaLimits != want {
+		t.Errorf("expected user record quota to take precedence, want %+v got %+v", want, status.QuotaLimits)
+	}
+}
@@ -0,0 +1,176 @@
+package uploader
+
+import (
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"uploader/internal/s3"
+)
+
+// defaultPartSize is the minimum part size accepted by S3 for all but the final part of a multipart upload.
+const defaultPartSize = 5 * 1024 * 1024
+
+// S3FileStore stores files as objects in an S3 compatible bucket.
+// Files larger than a single part are streamed using a multipart upload, so that at most one part is held in memory.
+type S3FileStore struct {
+	client   *s3.Client
+	partSize int
+	// PresignExpiry is the lifetime of signed download URLs, downloads are streamed through the uploader if it is zero.
+	PresignExpiry time.Duration
+}
+
+func NewS3FileStore(cfg s3.Config, partSize int) (*S3FileStore, error) {
+	client, err := s3.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if partSize < defaultPartSize {
+		partSize = defaultPartSize
+	}
+	return &S3FileStore{client: client, partSize: partSize}, nil
+}
+
+func (s *S3FileStore) Put(key string, r io.Reader) error {
+	part := make([]byte, s.partSize)
+	n, err := io.ReadFull(r, part)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return s.client.PutObject(key, part[:n])
+	} else if err != nil {
+		return err
+	}
+
+	uploadID, err := s.client.CreateMultipartUpload(key)
+	if err != nil {
+		return err
+	}
+	if err := s.putParts(key, uploadID, part, r); err != nil {
+		s.client.AbortMultipartUpload(key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// putParts uploads the already read first part followed by the remainder of the reader.
+func (s *S3FileStore) putParts(key, uploadID string, part []byte, r io.Reader) error {
+	var parts []s3.Part
+	for number := 1; ; number++ {
+		uploaded, err := s.client.UploadPart(key, uploadID, number, part)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, uploaded)
+
+		n, err := io.ReadFull(r, part)
+		if errors.Is(err, io.EOF) {
+			break
+		} else if errors.Is(err, io.ErrUnexpectedEOF) {
+			part = part[:n]
+		} else if err != nil {
+			return err
+		}
+	}
+	return s.client.CompleteMultipartUpload(key, uploadID, parts)
+}
+
+// Get returns a seekable reader over the object. The object is only fetched once it is read, so that requests
+// answered without the contents, such as conditional requests, don't transfer it. Seeking is implemented with ranged
+// GET requests.
+func (s *S3FileStore) Get(key string) (io.ReadCloser, error) {
+	size, err := s.client.HeadObject(key)
+	if errors.Is(err, s3.ErrNotFound) {
+		return nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, err
+	}
+	return &s3Object{client: s.client, key: key, size: size}, nil
+}
+
+func (s *S3FileStore) SignURL(key, contentType, disposition string) (string, error) {
+	if s.PresignExpiry <= 0 {
+		return "", ErrSignedURLUnsupported
+	}
+	overrides := url.Values{}
+	if contentType != "" {
+		overrides.Set("response-content-type", contentType)
+	}
+	if disposition != "" {
+		overrides.Set("response-content-disposition", disposition)
+	}
+	return s.client.PresignGetObject(key, s.PresignExpiry, overrides), nil
+}
+
+func (s *S3FileStore) Delete(key string) error {
+	err := s.client.DeleteObject(key)
+	if errors.Is(err, s3.ErrNotFound) {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (s *S3FileStore) Keys(prefix string) ([]string, error) {
+	return s.client.ListObjects(prefix)
+}
+
+func (s *S3FileStore) Close() error {
+	return nil
+}
+
+// s3Object reads an object, opening it at the current offset on the first read and reopening it when a seek moves away
+// from the open body.
+type s3Object struct {
+	client     *s3.Client
+	key        string
+	size       int64
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+	if o.body != nil && o.bodyOffset != o.offset {
+		o.body.Close()
+		o.body = nil
+	}
+	if o.body == nil {
+		body, _, err := o.client.GetObject(o.key, o.offset)
+		if err != nil {
+			return 0, err
+		}
+		o.body = body
+		o.bodyOffset = o.offset
+	}
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	o.bodyOffset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += o.offset
+	case io.SeekEnd:
+		offset += o.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	o.offset = offset
+	return offset, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body != nil {
+		return o.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package uploader
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"uploader/internal/s3/s3test"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func newTestS3Store(t testing.TB) (*S3FileStore, *s3test.Server) {
+	t.Helper()
+	server := s3test.NewServer("uploads")
+	t.Cleanup(server.Close)
+	store, err := NewS3FileStore(server.Config(), 0)
+	if err != nil {
+		t.Fatalf("failed to create s3 store %s", err)
+	}
+	return store, server
+}
+
+type failingReader struct {
+	r io.Reader
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	n, err := f.r.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestS3FileStore_Put(t *testing.T) {
+	store, server := newTestS3Store(t)
+
+	t.Run("single part", func(t *testing.T) {
+		if err := store.Put("small", strings.NewReader("Hello, World!")); err != nil {
+			t.Fatalf("unexpected error putting object %s", err)
+		}
+		contents, _ := server.Object("small")
+		if string(contents) != "Hello, World!" {
+			t.Errorf("unexpected object contents %q", contents)
+		}
+	})
+	t.Run("multipart", func(t *testing.T) {
+		large := bytes.Repeat([]byte("0123456789abcdef"), (2*defaultPartSize+1024)/16)
+		if err := store.Put("large", bytes.NewReader(large)); err != nil {
+			t.Fatalf("unexpected error putting object %s", err)
+		}
+		contents, _ := server.Object("large")
+		if !bytes.Equal(contents, large) {
+			t.Errorf("multipart object mismatch, got %d bytes want %d", len(contents), len(large))
+		}
+		if server.PendingUploads() != 0 {
+			t.Error("expected multipart upload to be completed")
+		}
+	})
+	t.Run("aborted", func(t *testing.T) {
+		large := bytes.Repeat([]byte("a"), defaultPartSize+10)
+		if err := store.Put("broken", &failingReader{bytes.NewReader(large)}); err == nil {
+			t.Fatal("expected error from failing reader")
+		}
+		if _, found := server.Object("broken"); found {
+			t.Error("expected object to not be created")
+		}
+		if server.PendingUploads() != 0 {
+			t.Error("expected multipart upload to be aborted")
+		}
+	})
+}
+
+func TestS3FileStore_Get(t *testing.T) {
+	store, server := newTestS3Store(t)
+	store.Put("abc", strings.NewReader("Hello, World!"))
+
+	reader, err := store.Get("abc")
+	if err != nil {
+		t.Fatalf("unexpected error getting object %s", err)
+	}
+	defer reader.Close()
+	if gets := server.Requests[http.MethodGet]; gets != 0 {
+		t.Errorf("expected object to not be fetched before it is read, got %d requests", gets)
+	}
+	seeker, ok := reader.(io.ReadSeeker)
+	if !ok {
+		t.Fatal("expected s3 reader to be seekable")
+	}
+	if size, _ := seeker.Seek(0, io.SeekEnd); size != 13 {
+		t.Errorf("expected size 13, got %d", size)
+	}
+	seeker.Seek(7, io.SeekStart)
+	contents, err := io.ReadAll(seeker)
+	if err != nil {
+		t.Fatalf("unexpected error reading object %s", err)
+	}
+	if string(contents) != "World!" {
+		t.Errorf("unexpected contents after seek %q", contents)
+	}
+	if gets := server.Requests[http.MethodGet]; gets != 1 {
+		t.Errorf("expected object to be fetched once, got %d requests", gets)
+	}
+
+	if _, err := store.Get("missing"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestS3FileStore_Delete(t *testing.T) {
+	store, server := newTestS3Store(t)
+	store.Put("abc", strings.NewReader("Hello, World!"))
+
+	if err := store.Delete("abc"); err != nil {
+		t.Fatalf("unexpected error deleting object %s", err)
+	}
+	if _, found := server.Object("abc"); found {
+		t.Error("expected object to be removed")
+	}
+}
+
+func TestS3FileStore_Keys(t *testing.T) {
+	store, server := newTestS3Store(t)
+	server.ListPageSize = 2
+	for _, key := range []string{"c", "a", "blob-b", "d/e"} {
+		store.Put(key, strings.NewReader(key))
+	}
+	keys, err := store.Keys("")
+	if err != nil {
+		t.Fatalf("unexpected error listing objects: %s", err)
+	}
+	if diff := cmp.Diff([]string{"a", "blob-b", "c", "d/e"}, keys); diff != "" {
+		t.Errorf("unexpected keys over several pages %s", diff)
+	}
+}
+
+func TestFileGetSignedRedirectHTTP(t *testing.T) {
+	store, server := newTestS3Store(t)
+	meta := newTestMeta()
+	meta.addFile("1", "text/plain")
+	store.Put("1", strings.NewReader("Hello, World!"))
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	t.Run("signing disabled streams", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusOK)
+		if response.Body.String() != "Hello, World!" {
+			t.Errorf("unexpected body %q", response.Body.String())
+		}
+	})
+
+	t.Run("not modified does not fetch", func(t *testing.T) {
+		gets := server.Requests[http.MethodGet]
+		request := httptest.NewRequest(http.MethodGet, "/files/1", nil)
+		request.Header.Set("If-None-Match", `"abc123"`)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusNotModified)
+		if fetched := server.Requests[http.MethodGet] - gets; fetched != 0 {
+			t.Errorf("expected object to not be fetched, got %d requests", fetched)
+		}
+	})
+
+	store.PresignExpiry = time.Minute
+	t.Run("redirect", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/files/1/test_file", nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusFound)
+		signed, err := http.Get(response.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("failed to follow signed url %s", err)
+		}
+		defer signed.Body.Close()
+		if signed.StatusCode != http.StatusOK {
+			t.Fatalf("signed url was rejected with status %d", signed.StatusCode)
+		}
+		body, _ := io.ReadAll(signed.Body)
+		if string(body) != "Hello, World!" {
+			t.Errorf("unexpected body from signed url %q", body)
+		}
+		if cd := signed.Request.URL.Query().Get("response-content-disposition"); cd != `attachment; filename="test_file"` {
+			t.Errorf("expected content disposition override, got %q", cd)
+		}
+	})
+	t.Run("missing", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/files/2", nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+
+		assertStatusCode(t, response, http.StatusNotFound)
+	})
+}
@@ -0,0 +1,130 @@
+package uploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"uploader/internal/auth"
+)
+
+// Formats accepted by Script.
+const (
+	ScriptShareX    = "sharex"
+	ScriptFlameshot = "flameshot"
+	ScriptCurl      = "curl"
+	// ScriptShareXShortener configures ShareX to shorten URLs rather than upload files.
+	ScriptShareXShortener = "sharex-shortener"
+)
+
+type UploadScript struct {
+	Version         string `json:"Version"`
+	Name            string `json:"Name"`
+	DestinationType string `json:"DestinationType"`
+	RequestMethod   string `json:"RequestMethod"`
+	RequestURL      string `json:"RequestURL"`
+	Headers         struct {
+		Authorization string `json:"Authorization"`
+	} `json:"Headers"`
+	Body         string `json:"Body"`
+	FileFormName string `json:"FileFormName,omitempty"`
+	URL          string `json:"URL"`
+	DeletionURL  string `json:"DeletionURL,omitempty"`
+
+	Arguments map[string]string `json:"Arguments,omitempty"`
+}
+
+// ReplaceToken issues the named token for a script, revoking any existing token of that name.
+// Stored tokens can not be recovered, so scripts are given a new token each time they are generated.
+func ReplaceToken(store auth.Store, user, name string, opts auth.TokenOptions) (*auth.Token, error) {
+	token, err := store.TokenCreate(user, name, opts)
+	if errors.Is(err, auth.TokenDuplicateError) {
+		if err = store.TokenRevoke(user, name); err == nil {
+			token, err = store.TokenCreate(user, name, opts)
+		}
+	}
+	return token, err
+}
+
+// ScriptOptions are the optional settings of a client configuration.
+type ScriptOptions struct {
+	// Preview copies the link to the preview page of uploads rather than the file itself.
+	Preview bool
+}
+
+// UploadScript returns a client configuration for the user in one of the formats accepted by Script.
+func (u *Uploader) UploadScript(user *auth.User, format string) (string, error) {
+	return Script(u.baseURL, user, format, ScriptOptions{Preview: u.previews != nil && u.previews.link})
+}
+
+// Script returns a client configuration that uploads as the user, in one of the supported formats.
+func Script(base *url.URL, user *auth.User, format string, opts ScriptOptions) (string, error) {
+	url := base.JoinPath("/uploads/", user.Name)
+	scopes := auth.FormatScopes(user.Scopes)
+	linkField := "url"
+	if opts.Preview {
+		linkField = "preview_url"
+	}
+	switch format {
+	case ScriptShareX:
+		return shareXScript(url, user, linkField), nil
+	case ScriptShareXShortener:
+		return shareXShortenerScript(url.JoinPath("links"), user), nil
+	case ScriptFlameshot:
+		return fmt.Sprintf(`#!/bin/sh
+# Takes a screenshot with flameshot and uploads it, copying the URL to the clipboard.
+# Token scopes: %s
+flameshot gui --raw | curl -sf -H 'Authorization: Bearer %s' -F 'file=@-;filename=screenshot.png' '%s' |
+	sed -n 's/.*"%s":"\([^"]*\)".*/\1/p' | tee /dev/stderr | xclip -selection clipboard
+`, scopes, user.AuthToken, url, linkField), nil
+	case ScriptCurl:
+		return fmt.Sprintf("# Token scopes: %s\ncurl -H 'Authorization: Bearer %s' -F 'file=@FILE' '%s'\n", scopes, user.AuthToken, url), nil
+	}
+	return "", fmt.Errorf("unknown script format %q", format)
+}
+
+func shareXScript(url *url.URL, user *auth.User, linkField string) string {
+	return marshalScript(UploadScript{
+		Version:         "13.2.1",
+		Name:            fmt.Sprintf("Uploader (%s)", auth.FormatScopes(user.Scopes)),
+		DestinationType: "ImageUploader, TextUploader, FileUploader",
+		RequestMethod:   "POST",
+		RequestURL:      url.String(),
+		Headers: struct {
+			Authorization string `json:"Authorization"`
+		}{
+			fmt.Sprintf("Bearer %s", user.AuthToken),
+		},
+		Body:         "MultipartFormData",
+		FileFormName: "file",
+		URL:          fmt.Sprintf("$json:results.%s$", linkField),
+		DeletionURL:  "$json:results.delete_url$",
+	})
+}
+
+func shareXShortenerScript(url *url.URL, user *auth.User) string {
+	return marshalScript(UploadScript{
+		Version:         "13.2.1",
+		Name:            fmt.Sprintf("Uploader shortener (%s)", auth.FormatScopes(user.Scopes)),
+		DestinationType: "URLShortener",
+		RequestMethod:   "POST",
+		RequestURL:      url.String(),
+		Headers: struct {
+			Authorization string `json:"Authorization"`
+		}{
+			fmt.Sprintf("Bearer %s", user.AuthToken),
+		},
+		Body:      "MultipartFormData",
+		Arguments: map[string]string{linkField: "$input$"},
+		URL:       "$json:results.url$",
+	})
+}
+
+func marshalScript(script UploadScript) string {
+	out, err := json.Marshal(script)
+	if err != nil {
+		panic(err)
+	}
+	return string(out)
+}
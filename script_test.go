@@ -0,0 +1,69 @@
+package uploader
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"uploader/internal/auth"
+)
+
+func TestScript(t *testing.T) {
+	base, _ := url.Parse("https://example.com")
+	user := &auth.User{Name: "test_user", AuthToken: "secret"}
+
+	out, err := Script(base, user, ScriptShareX, ScriptOptions{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	sharex := UploadScript{}
+	if err := json.Unmarshal([]byte(out), &sharex); err != nil {
+		t.Fatalf("sharex script is not valid json: %s", err)
+	}
+	if sharex.RequestURL != "https://example.com/uploads/test_user" || sharex.Headers.Authorization != "Bearer secret" {
+		t.Errorf("unexpected sharex script %+v", sharex)
+	}
+
+	for _, format := range []string{ScriptFlameshot, ScriptCurl} {
+		out, err := Script(base, user, format, ScriptOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", format, err)
+		}
+		if !strings.Contains(out, "Authorization: Bearer secret") || !strings.Contains(out, "https://example.com/uploads/test_user") {
+			t.Errorf("%s script missing token or url: %s", format, out)
+		}
+	}
+
+	scoped := &auth.User{Name: "test_user", AuthToken: "secret", Scopes: []auth.Scope{auth.ScopeUpload}}
+	out, _ = Script(base, scoped, ScriptCurl, ScriptOptions{})
+	if !strings.Contains(out, "scopes: upload") {
+		t.Errorf("expected curl script to list token scopes: %s", out)
+	}
+	out, _ = Script(base, scoped, ScriptShareX, ScriptOptions{})
+	if !strings.Contains(out, "Uploader (upload)") {
+		t.Errorf("expected sharex script name to list token scopes: %s", out)
+	}
+
+	out, _ = Script(base, user, ScriptShareX, ScriptOptions{Preview: true})
+	if !strings.Contains(out, "$json:results.preview_url$") {
+		t.Errorf("expected sharex script to link to the preview page: %s", out)
+	}
+	out, _ = Script(base, user, ScriptFlameshot, ScriptOptions{Preview: true})
+	if !strings.Contains(out, `"preview_url"`) {
+		t.Errorf("expected flameshot script to copy the preview link: %s", out)
+	}
+
+	out, _ = Script(base, user, ScriptShareXShortener, ScriptOptions{Preview: true})
+	shortener := UploadScript{}
+	if err := json.Unmarshal([]byte(out), &shortener); err != nil {
+		t.Fatalf("shortener script is not valid json: %s", err)
+	}
+	if shortener.RequestURL != "https://example.com/uploads/test_user/links" || shortener.URL != "$json:results.url$" || shortener.FileFormName != "" {
+		t.Errorf("unexpected shortener script %+v", shortener)
+	}
+
+	if _, err := Script(base, user, "unknown", ScriptOptions{}); err == nil {
+		t.Errorf("expected error for unknown format")
+	}
+}
@@ -2,21 +2,65 @@ package uploader
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/subtle"
+	"encoding/hex"
 	"errors"
 	"io"
+	"log"
 	"net/http"
 	"os"
+	"time"
+
+	"uploader/internal/auth"
 )
 
 type UploadService interface {
 	Close() error
-	Upload(r io.ReadSeekCloser, name string, size int64, user string) (*UploadDetails, error)
-	Get(key string) (*UploadDetails, io.ReadCloser, error)
-	Delete(key string) error
+	Upload(r io.ReadSeekCloser, name string, size int64, user string, opts UploadOptions) (*UploadDetails, error)
+	// Get opens an upload with its password, if it has one, taking one of its downloads if they are limited. The last
+	// download removes the upload once its reader is closed.
+	Get(key, password string) (*UploadDetails, io.ReadCloser, error)
+	// Info returns the details of an upload without opening the stored file.
+	Info(key string) (*UploadDetails, error)
+	// Delete removes an upload on behalf of the user, who must own the upload or be an admin.
+	Delete(key string, user *auth.User) error
 	DeletePublic(key, deleteKey string) error
+	List(user string, opts ListOptions) (*UploadList, error)
+	ReapExpired(now time.Time) (int, error)
+	Quota(user string) (*QuotaStatus, error)
+	CheckQuota(user string, size int64) error
+	// MaxUploadSize is the largest upload accepted, or zero if there is no limit.
+	MaxUploadSize() int64
+	// Thumbnail returns a thumbnail of an image upload and its content type. The size must be one of ThumbnailSizes.
+	Thumbnail(key string, size int) (io.ReadCloser, string, error)
+	ThumbnailSizes() []int
+	// Shorten creates a short link to the URL on behalf of the user.
+	Shorten(target, user string, opts UploadOptions) (*Link, error)
+	// Follow counts a click of the link and returns it, failing with ErrExpired if it has expired.
+	Follow(key string) (*Link, error)
+	// DeleteLink removes a link on behalf of the user, who must own the link or be an admin.
+	DeleteLink(key string, user *auth.User) error
+	Links(user string) ([]Link, error)
+}
+
+// UploadOptions are the optional settings a client may request for a new upload.
+type UploadOptions struct {
+	// ExpiresIn is the requested lifetime of the upload, zero requests the default for the user.
+	ExpiresIn time.Duration
+	// Language marks the upload as a paste, highlighted in the given language.
+	Language string
+	// Encrypted marks the upload as encrypted by the client.
+	Encrypted bool
+	// Password protects downloads of the upload, which is not protected if it is empty.
+	Password string
+	// MaxDownloads is the number of downloads after which the upload is removed, zero for no limit.
+	MaxDownloads int64
 }
 
+// ErrForbidden is returned when a user attempts to modify an upload they do not own.
+var ErrForbidden = errors.New("forbidden")
+
 type KeyMeta interface {
 	FileKey() (string, error)
 	DeleteKey() (string, error)
@@ -27,52 +71,157 @@ type UploadMeta interface {
 	Close() error
 	FilePut(details UploadDetails) error
 	FileGet(key string) (*UploadDetails, error)
+	// FileDownload takes one download of an upload with a limited number, failing with ErrNoDownloadsLeft if none are
+	// left, and returns the updated details.
+	FileDownload(key string) (*UploadDetails, error)
 	FileDelete(key string) error
+	// UserFiles returns the details of all uploads belonging to the user.
+	UserFiles(user string) ([]UploadDetails, error)
+	// ExpiredFiles returns the keys of uploads that expire at or before the given time.
+	ExpiredFiles(before time.Time) ([]string, error)
+	// UserUsage returns the total size and number of uploads belonging to the user.
+	UserUsage(user string) (*Usage, error)
+	// UserQuota returns the quota set on the record of the user, or nil if the configured quota applies.
+	UserQuota(user string) (*QuotaLimits, error)
+	BlobMeta
+	LinkMeta
 }
 
 type uploadService struct {
-	meta  UploadMeta
-	store FileStore
+	meta   UploadMeta
+	store  FileStore
+	expiry ExpiryPolicy
+	quota  QuotaPolicy
+	// thumbnails are the sizes of the thumbnails made for image uploads.
+	thumbnails []int
+	passwords  passwordLimiter
 }
 
 func NewUploadService(meta UploadMeta, store FileStore) *uploadService {
 	return &uploadService{
-		meta,
-		store,
+		meta:       meta,
+		store:      store,
+		thumbnails: DefaultThumbnailSizes,
+		passwords:  newPasswordLimiter(),
 	}
 }
 
-func (u *uploadService) Upload(file io.ReadSeekCloser, fileName string, fileSize int64, user string) (*UploadDetails, error) {
-	fileKey, err := u.meta.FileKey()
+// Upload stores the file and its details. The details are stored as pending before the file is written and committed
+// once it has been, and every step taken is undone if a later one fails.
+func (u *uploadService) Upload(file io.ReadSeekCloser, fileName string, fileSize int64, user string, opts UploadOptions) (*UploadDetails, error) {
+	lifetime, err := u.expiry.Lifetime(user, opts.ExpiresIn)
 	if err != nil {
 		return nil, err
 	}
+	if err := u.CheckQuota(user, fileSize); err != nil {
+		return nil, err
+	}
 	deleteKey, err := u.meta.DeleteKey()
 	if err != nil {
 		return nil, err
 	}
+	blobKey, err := randSecKey()
+	if err != nil {
+		return nil, err
+	}
 
 	details := UploadDetails{
-		Key:         fileKey,
 		DeleteKey:   deleteKey,
 		Filename:    fileName,
 		Size:        fileSize,
 		ContentType: contentTypeFromFile(file),
 		User:        user,
+		Uploaded:    time.Now().UTC(),
+		// Pending uploads record the key their file is written to, so that fsck can remove it if the upload is
+		// interrupted.
+		Blob:  blobKeyPrefix + blobKey,
+		State: UploadPending,
+	}
+	if opts.Language != "" {
+		// Pastes are always served as plain text, whatever their contents look like.
+		details.Language, details.ContentType = opts.Language, pasteContentType
+	}
+	if opts.Encrypted {
+		// The contents of encrypted uploads are opaque, whatever they looked like before encryption.
+		details.Encrypted, details.ContentType = true, encryptedContentType
+	}
+	if opts.Password != "" {
+		if details.PasswordHash, err = HashPassword(opts.Password); err != nil {
+			return nil, err
+		}
 	}
+	if opts.MaxDownloads > 0 {
+		details.DownloadsLeft = &opts.MaxDownloads
+	}
+	if lifetime > 0 {
+		expires := details.Uploaded.Add(lifetime)
+		details.Expires = &expires
+	}
+
+	if details.Key, err = u.meta.FileKey(); err != nil {
+		return nil, err
+	}
+	if err := u.meta.FilePut(details); err != nil {
+		u.abortUpload(details.Key)
+		return nil, err
+	}
+	blob, err := u.putBlob(details.Blob, file)
+	if err != nil {
+		u.abortUpload(details.Key)
+		return nil, err
+	}
+	details.Hash, details.Blob, details.State = blob.Hash, blob.Key, UploadCommitted
 	if err := u.meta.FilePut(details); err != nil {
+		u.releaseBlob(details.Hash)
+		u.abortUpload(details.Key)
+		return nil, err
+	}
+	// Thumbnails belong to the blob, so are only made by the first upload of its contents.
+	if blob.Refs == 1 {
+		u.generateThumbnails(file, &details)
+	}
+	return &details, nil
+}
+
+// abortUpload removes the details of a failed upload, releasing its key.
+func (u *uploadService) abortUpload(key string) {
+	if err := u.meta.FileDelete(key); err != nil {
+		log.Printf("failed to remove details of failed upload %s: %s", key, err)
+	}
+}
+
+// putBlob streams the file into the store under the new blob key while hashing it. If a blob with the same content
+// already exists, the new copy is removed and a reference to the existing blob is taken instead.
+func (u *uploadService) putBlob(key string, file io.Reader) (*Blob, error) {
+	hash := sha256.New()
+	if err := u.store.Put(key, io.TeeReader(file, hash)); err != nil {
+		u.store.Delete(key)
 		return nil, err
 	}
-	if err := u.store.Put(fileKey, file); err != nil {
+	blob, err := u.meta.BlobAcquire(hex.EncodeToString(hash.Sum(nil)), key)
+	if err != nil {
+		u.store.Delete(key)
 		return nil, err
 	}
-	return &UploadDetails{
-		Key:       fileKey,
-		DeleteKey: deleteKey,
-		Filename:  fileName,
-		Size:      fileSize,
-		User:      user,
-	}, nil
+	if blob.Key != key {
+		if err := u.store.Delete(key); err != nil {
+			log.Printf("failed to remove duplicate blob %s: %s", key, err)
+		}
+	}
+	return blob, nil
+}
+
+// releaseBlob drops a reference to the blob with the given hash, removing it from the store with the last reference.
+func (u *uploadService) releaseBlob(hash string) error {
+	blob, err := u.meta.BlobRelease(hash)
+	if err != nil {
+		return err
+	}
+	if blob.Refs > 0 {
+		return nil
+	}
+	u.removeThumbnails(blob.Key)
+	return u.store.Delete(blob.Key)
 }
 
 func contentTypeFromFile(file io.ReadSeeker) string {
@@ -87,37 +236,93 @@ func contentTypeFromFile(file io.ReadSeeker) string {
 	return contentType
 }
 
-func (u *uploadService) Get(key string) (*UploadDetails, io.ReadCloser, error) {
+func (u *uploadService) Get(key, password string) (*UploadDetails, io.ReadCloser, error) {
 	meta, err := u.meta.FileGet(key)
 	if errors.Is(err, ErrNotFound) {
 		return nil, nil, os.ErrNotExist
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if meta.Expired(time.Now()) {
+		return nil, nil, ErrExpired
+	}
+	if meta.PasswordHash != "" {
+		if password == "" {
+			return nil, nil, ErrPasswordRequired
+		}
+		if err := u.passwords.check(meta, password); err != nil {
+			return nil, nil, err
+		}
 	}
-	file, err := u.store.Get(key)
+	if meta.DownloadsLeft != nil {
+		// Uploads with no downloads left are being removed by their last download.
+		meta, err = u.meta.FileDownload(key)
+		if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNoDownloadsLeft) {
+			return nil, nil, os.ErrNotExist
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	file, err := u.store.Get(meta.StorageKey())
 	if err != nil {
 		return nil, nil, err
 	}
+	if meta.DownloadsLeft != nil && *meta.DownloadsLeft == 0 {
+		file = u.burnAfterRead(file, meta)
+	}
 	return meta, file, nil
 }
 
-func (u *uploadService) Delete(key string) error {
-	if err := u.meta.FileDelete(key); err != nil {
+func (u *uploadService) Info(key string) (*UploadDetails, error) {
+	meta, err := u.meta.FileGet(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil, os.ErrNotExist
+	}
+	return meta, err
+}
+
+func (u *uploadService) Delete(key string, user *auth.User) error {
+	entry, err := u.Info(key)
+	if err != nil {
 		return err
 	}
-	return u.store.Delete(key)
+	if !user.CanActAs(entry.User) {
+		return ErrForbidden
+	}
+	return u.remove(entry)
+}
+
+// remove deletes the stored file and its thumbnails followed by the upload metadata. Uploads that share a blob only remove the stored
+// file once the last reference has been removed. The upload is marked as deleting until the file is removed, so that fsck
+// can finish the removal if it is interrupted.
+func (u *uploadService) remove(details *UploadDetails) error {
+	deleting := *details
+	deleting.State = UploadDeleting
+	if err := u.meta.FilePut(deleting); err != nil {
+		return err
+	}
+	var err error
+	if details.Blob == "" {
+		u.removeThumbnails(details.Key)
+		err = u.store.Delete(details.Key)
+	} else {
+		err = u.releaseBlob(details.Hash)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return u.meta.FileDelete(details.Key)
 }
 
 func (u *uploadService) DeletePublic(key, deleteKey string) error {
-	entry, err := u.meta.FileGet(key)
+	entry, err := u.Info(key)
 	if err != nil {
 		return err
 	}
 	if subtle.ConstantTimeCompare([]byte(deleteKey), []byte(entry.DeleteKey)) != 1 {
-		return errors.New("unauthorized")
-	}
-	if err = u.meta.FileDelete(key); err != nil {
-		return err
+		return ErrForbidden
 	}
-	return u.store.Delete(key)
+	return u.remove(entry)
 }
 
 func (u *uploadService) Close() error {
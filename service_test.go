@@ -8,6 +8,8 @@ import (
 	"strings"
 	"testing"
 
+	"uploader/internal/auth"
+
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -20,16 +22,28 @@ func TestUploadService_Upload(t *testing.T) {
 	stats, err := os.Stat(fileName)
 	file, err := os.Open(fileName)
 
-	result, err := uploader.Upload(file, stats.Name(), stats.Size(), "test_user")
+	result, err := uploader.Upload(file, stats.Name(), stats.Size(), "test_user", UploadOptions{})
 	if err != nil {
 		t.Fatalf("did not expect error, but received %s", err)
 	}
-	if len(meta.putCalls) != 1 {
-		t.Errorf("unexpected number of calls for putting file metadata, expected %d got %d", 1, len(meta.putCalls))
+	// Uploads are stored as pending, then committed once the file has been stored.
+	if len(meta.putCalls) != 2 {
+		t.Errorf("unexpected number of calls for putting file metadata, expected %d got %d", 2, len(meta.putCalls))
+	}
+	if !result.Committed() || !meta.files[result.Key].Committed() {
+		t.Error("expected upload to be committed")
 	}
 	if result.Key != "1" {
 		t.Errorf("expected file key of 1, got %s", result.Key)
 	}
+	// SHA-256 of "Hello, World!"
+	wantHash := "dffd6021bb2bd5b0af676290809ec3a53191dd81c7f70a4b28688a362182986f"
+	if result.Hash != wantHash {
+		t.Errorf("expected hash %s, got %s", wantHash, result.Hash)
+	}
+	if result.Uploaded.IsZero() {
+		t.Error("expected upload time to be set")
+	}
 }
 
 func TestUploadService_Get(t *testing.T) {
@@ -38,7 +52,7 @@ func TestUploadService_Get(t *testing.T) {
 	uploader := NewUploadService(meta, store)
 
 	t.Run("not found", func(t *testing.T) {
-		_, _, err := uploader.Get("123")
+		_, _, err := uploader.Get("123", "")
 		if !errors.Is(err, os.ErrNotExist) {
 			t.Error("expected to get not found")
 		}
@@ -51,7 +65,7 @@ func TestUploadService_Get(t *testing.T) {
 			t.Fatalf("unexpected error seeding store %s", err)
 		}
 
-		deets, reader, err := uploader.Get("abc123")
+		deets, reader, err := uploader.Get("abc123", "")
 		if err != nil {
 			t.Fatalf("did not expect error %s", err)
 		}
@@ -74,7 +88,15 @@ func TestUploadService_Delete(t *testing.T) {
 	meta.addFile("abc", "text/plain")
 	store.Put("abc", strings.NewReader("Hello, World!"))
 
-	err := uploader.Delete("abc")
+	other := &auth.User{Name: "other_user", Role: auth.RoleUser}
+	if err := uploader.Delete("abc", other); !errors.Is(err, ErrForbidden) {
+		t.Fatalf("expected deletion by another user to be forbidden, got %v", err)
+	}
+	if _, err := store.Get("abc"); err != nil {
+		t.Fatal("expected file to remain after forbidden deletion")
+	}
+
+	err := uploader.Delete("abc", &auth.User{Name: "test_user", Role: auth.RoleUser})
 	if err != nil {
 		t.Fatalf("unexpected error on deletion %s", err)
 	}
@@ -85,3 +107,86 @@ func TestUploadService_Delete(t *testing.T) {
 		t.Error("expected store entry to be missing")
 	}
 }
+
+// failingFileStore fails to store any file, after reading part of it.
+type failingFileStore struct {
+	*memoryFileStore
+}
+
+func (f failingFileStore) Put(key string, r io.Reader) error {
+	f.files[key] = []byte("partial")
+	return errors.New("disk full")
+}
+
+func TestUploadService_UploadRollback(t *testing.T) {
+	meta := newTestMeta()
+	store := failingFileStore{newMemoryFileStore()}
+	uploader := NewUploadService(meta, store)
+	file, _ := os.Open("./test/data/test_file.txt")
+	defer file.Close()
+
+	if _, err := uploader.Upload(file, "test_file.txt", 13, "test_user", UploadOptions{}); err == nil {
+		t.Fatal("expected failure to store the file to fail the upload")
+	}
+	if len(meta.putCalls) != 1 {
+		t.Errorf("expected only the pending upload to be put, got %v", meta.putCalls)
+	}
+	if len(meta.files) != 0 || len(store.files) != 0 || len(meta.blobs) != 0 {
+		t.Errorf("expected failed upload to be rolled back, got %d uploads, %d files and %d blobs",
+			len(meta.files), len(store.files), len(meta.blobs))
+	}
+}
+
+func TestUploadService_Deduplication(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	uploader := NewUploadService(meta, store)
+
+	upload := func(contents string) *UploadDetails {
+		t.Helper()
+		details, err := uploader.Upload(&seekableMemFile{bytes.NewReader([]byte(contents))}, "file.txt", int64(len(contents)), "test_user", UploadOptions{})
+		if err != nil {
+			t.Fatalf("unexpected error uploading %s", err)
+		}
+		return details
+	}
+	first := upload("Hello, World!")
+	second := upload("Hello, World!")
+	other := upload("Goodbye, World!")
+
+	if first.Key == second.Key || first.DeleteKey == "" {
+		t.Error("expected duplicate uploads to have their own keys")
+	}
+	if first.Blob != second.Blob {
+		t.Errorf("expected duplicate uploads to share a blob, got %s and %s", first.Blob, second.Blob)
+	}
+	if len(store.files) != 2 {
+		t.Errorf("expected two blobs in the store, got %d", len(store.files))
+	}
+	if refs := meta.blobs[first.Hash].Refs; refs != 2 {
+		t.Errorf("expected two references to the shared blob, got %d", refs)
+	}
+
+	owner := &auth.User{Name: "test_user"}
+	if err := uploader.Delete(first.Key, owner); err != nil {
+		t.Fatalf("unexpected error deleting upload %s", err)
+	}
+	_, reader, err := uploader.Get(second.Key, "")
+	if err != nil {
+		t.Fatalf("expected remaining upload to be readable, got %s", err)
+	}
+	contents, _ := io.ReadAll(reader)
+	if string(contents) != "Hello, World!" {
+		t.Errorf("unexpected contents %q", contents)
+	}
+
+	if err := uploader.Delete(second.Key, owner); err != nil {
+		t.Fatalf("unexpected error deleting upload %s", err)
+	}
+	if _, err := store.Get(second.Blob); !errors.Is(err, os.ErrNotExist) {
+		t.Error("expected blob to be removed with its last reference")
+	}
+	if _, err := store.Get(other.Blob); err != nil {
+		t.Error("expected unrelated blob to remain")
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"uploader/internal/auth"
 )
@@ -16,11 +19,24 @@ type testMeta struct {
 	deleteCalls int
 	keyCalls    int
 
-	files map[string]*UploadDetails
+	files    map[string]*UploadDetails
+	partials map[string]*PartialUpload
+	blobs    map[string]*Blob
+	links    map[string]*Link
+	audit    []AuditEvent
+	// downloads holds the times each upload was downloaded.
+	downloads map[string][]time.Time
 }
 
 func newTestMeta() *testMeta {
-	return &testMeta{as: auth.NewMemoryAuthStore(), files: map[string]*UploadDetails{}}
+	return &testMeta{
+		as:        auth.NewMemoryAuthStore(),
+		files:     map[string]*UploadDetails{},
+		partials:  map[string]*PartialUpload{},
+		blobs:     map[string]*Blob{},
+		links:     map[string]*Link{},
+		downloads: map[string][]time.Time{},
+	}
 }
 
 func (s *testMeta) addFile(key, contentType string) {
@@ -28,9 +44,11 @@ func (s *testMeta) addFile(key, contentType string) {
 		Key:         key,
 		DeleteKey:   "delete",
 		Filename:    "test_file",
-		Size:        18,
+		Size:        13,
 		ContentType: contentType,
 		User:        "test_user",
+		Hash:        "abc123",
+		Uploaded:    time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC),
 	}
 }
 
@@ -42,6 +60,59 @@ func (s *testMeta) UserRegister(name string) (*auth.User, error) {
 	return s.as.UserRegister(name)
 }
 
+func (s *testMeta) UserRegisterIdentity(name string, identity auth.Identity) (*auth.User, error) {
+	return s.as.UserRegisterIdentity(name, identity)
+}
+
+func (s *testMeta) UserSetRole(name string, role auth.Role) error {
+	return s.as.UserSetRole(name, role)
+}
+
+func (s *testMeta) UserByName(name string) (*auth.User, error) {
+	return s.as.UserByName(name)
+}
+
+func (s *testMeta) UserSetDisabled(name string, disabled bool) error {
+	return s.as.UserSetDisabled(name, disabled)
+}
+
+func (s *testMeta) UserSetQuota(name string, quota *auth.Quota) error {
+	return s.as.UserSetQuota(name, quota)
+}
+
+func (s *testMeta) UserQuota(name string) (*QuotaLimits, error) {
+	user, err := s.as.UserByName(name)
+	if err != nil || user.Quota == nil {
+		return nil, nil
+	}
+	limits := QuotaLimits(*user.Quota)
+	return &limits, nil
+}
+
+func (s *testMeta) UserList() ([]*auth.User, error) {
+	return s.as.UserList()
+}
+
+func (s *testMeta) UserRevoke(name string) error {
+	return s.as.UserRevoke(name)
+}
+
+func (s *testMeta) TokenCreate(user, name string, opts auth.TokenOptions) (*auth.Token, error) {
+	return s.as.TokenCreate(user, name, opts)
+}
+
+func (s *testMeta) TokenList(user string) ([]*auth.Token, error) {
+	return s.as.TokenList(user)
+}
+
+func (s *testMeta) TokenRevoke(user, name string) error {
+	return s.as.TokenRevoke(user, name)
+}
+
+func (s *testMeta) TokenRotate(user, name string) (*auth.Token, error) {
+	return s.as.TokenRotate(user, name)
+}
+
 func (s *testMeta) UserByAuthToken(token string) (*auth.User, error) {
 	return s.as.UserByAuthToken(token)
 }
@@ -58,33 +129,236 @@ func (s *testMeta) DeleteKey() (string, error) {
 
 func (s *testMeta) FilePut(details UploadDetails) error {
 	s.putCalls = append(s.putCalls, details.Key)
+	s.files[details.Key] = &details
 	return nil
 }
 
 func (s *testMeta) FileGet(key string) (*UploadDetails, error) {
 	s.getCalls = append(s.getCalls, key)
 	entry, found := s.files[key]
-	if !found {
+	if !found || !entry.Committed() {
 		return nil, ErrNotFound
 	}
 	return entry, nil
 }
 
+func (s *testMeta) FileDownload(key string) (*UploadDetails, error) {
+	entry, found := s.files[key]
+	if !found || !entry.Committed() {
+		return nil, ErrNotFound
+	}
+	if entry.DownloadsLeft == nil {
+		return entry, nil
+	}
+	if *entry.DownloadsLeft <= 0 {
+		return nil, ErrNoDownloadsLeft
+	}
+	updated := *entry
+	left := *entry.DownloadsLeft - 1
+	updated.DownloadsLeft = &left
+	s.files[key] = &updated
+	return &updated, nil
+}
+
 func (s *testMeta) FileDelete(key string) error {
+	if _, found := s.files[key]; !found {
+		return ErrNotFound
+	}
 	delete(s.files, key)
+	delete(s.downloads, key)
 	return nil
 }
 
+func (s *testMeta) UserFiles(user string) ([]UploadDetails, error) {
+	var files []UploadDetails
+	for _, file := range s.files {
+		if file.User == user && file.Committed() {
+			files = append(files, *file)
+		}
+	}
+	return files, nil
+}
+
+func (s *testMeta) ExpiredFiles(before time.Time) ([]string, error) {
+	var keys []string
+	for key, file := range s.files {
+		if file.Committed() && file.Expired(before) {
+			keys = append(keys, key)
+		}
+	}
+	for key, link := range s.links {
+		if link.Expired(before) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (s *testMeta) UserUsage(user string) (*Usage, error) {
+	usage := &Usage{}
+	for _, file := range s.files {
+		if file.User == user && file.Committed() {
+			usage.Bytes += file.Size
+			usage.Files++
+		}
+	}
+	return usage, nil
+}
+
+func (s *testMeta) BlobAcquire(hash, key string) (*Blob, error) {
+	blob, found := s.blobs[hash]
+	if !found {
+		blob = &Blob{Hash: hash, Key: key}
+		s.blobs[hash] = blob
+	}
+	blob.Refs++
+	copied := *blob
+	return &copied, nil
+}
+
+func (s *testMeta) BlobRelease(hash string) (*Blob, error) {
+	blob, found := s.blobs[hash]
+	if !found {
+		return nil, ErrNotFound
+	}
+	blob.Refs--
+	if blob.Refs <= 0 {
+		delete(s.blobs, hash)
+	}
+	copied := *blob
+	return &copied, nil
+}
+
+func (s *testMeta) PartialPut(upload PartialUpload) error {
+	s.partials[upload.ID] = &upload
+	return nil
+}
+
+func (s *testMeta) PartialGet(id string) (*PartialUpload, error) {
+	entry, found := s.partials[id]
+	if !found {
+		return nil, ErrNotFound
+	}
+	copied := *entry
+	return &copied, nil
+}
+
+func (s *testMeta) PartialDelete(id string) error {
+	delete(s.partials, id)
+	return nil
+}
+
+func (s *testMeta) StalePartials(before time.Time) ([]string, error) {
+	var ids []string
+	for id, upload := range s.partials {
+		if upload.Updated.Before(before) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (s *testMeta) LinkPut(link Link) error {
+	s.links[link.Key] = &link
+	return nil
+}
+
+func (s *testMeta) LinkGet(key string) (*Link, error) {
+	link, found := s.links[key]
+	if !found {
+		return nil, ErrNotFound
+	}
+	copied := *link
+	return &copied, nil
+}
+
+func (s *testMeta) LinkClick(key string) (*Link, error) {
+	link, found := s.links[key]
+	if !found {
+		return nil, ErrNotFound
+	}
+	link.Clicks++
+	copied := *link
+	return &copied, nil
+}
+
+func (s *testMeta) LinkDelete(key string) error {
+	if _, found := s.links[key]; !found {
+		return ErrNotFound
+	}
+	delete(s.links, key)
+	return nil
+}
+
+func (s *testMeta) UserLinks(user string) ([]Link, error) {
+	var links []Link
+	for _, link := range s.links {
+		if link.User == user {
+			links = append(links, *link)
+		}
+	}
+	return links, nil
+}
+
+func (s *testMeta) AuditAppend(event AuditEvent) error {
+	s.audit = append(s.audit, event)
+	return nil
+}
+
+func (s *testMeta) AuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	var events []AuditEvent
+	for _, event := range s.audit {
+		if filter.Matches(&event) && (filter.Limit == 0 || len(events) < filter.Limit) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
+
+func (s *testMeta) DownloadAdd(key string, at time.Time) error {
+	s.downloads[key] = append(s.downloads[key], at)
+	return nil
+}
+
+func (s *testMeta) Downloads(since, until time.Time) ([]DownloadCount, error) {
+	var counts []DownloadCount
+	for key, times := range s.downloads {
+		count := DownloadCount{Key: key}
+		for _, at := range times {
+			if dayOverlaps(at.UTC().Truncate(24*time.Hour), since, until) {
+				count.Downloads++
+			}
+		}
+		if count.Downloads > 0 {
+			counts = append(counts, count)
+		}
+	}
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Key < counts[j].Key })
+	return counts, nil
+}
+
 type memFile struct {
-	*bytes.Buffer
+	io.Reader
 }
 
 func (m *memFile) Close() error {
 	return nil
 }
 
+// seekableMemFile is returned by the memory store unless seeking has been disabled.
+type seekableMemFile struct {
+	*bytes.Reader
+}
+
+func (m *seekableMemFile) Close() error {
+	return nil
+}
+
 type memoryFileStore struct {
-	files map[string]*memFile
+	files map[string][]byte
+	// noSeek forces Get to return readers without io.Seeker, mimicking streaming backends.
+	noSeek bool
 }
 
 func (m *memoryFileStore) Close() error {
@@ -92,18 +366,22 @@ func (m *memoryFileStore) Close() error {
 }
 
 func (m *memoryFileStore) Get(key string) (io.ReadCloser, error) {
-	if file, found := m.files[key]; found {
-		return file, nil
+	file, found := m.files[key]
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	if m.noSeek {
+		return &memFile{bytes.NewReader(file)}, nil
 	}
-	return nil, os.ErrNotExist
+	return &seekableMemFile{bytes.NewReader(file)}, nil
 }
 
 func (m *memoryFileStore) Put(key string, r io.Reader) error {
-	f := &memFile{&bytes.Buffer{}}
-	if _, err := io.Copy(f, r); err != nil {
+	contents, err := io.ReadAll(r)
+	if err != nil {
 		return err
 	}
-	m.files[key] = f
+	m.files[key] = contents
 	return nil
 }
 
@@ -112,6 +390,16 @@ func (m *memoryFileStore) Delete(key string) error {
 	return nil
 }
 
+func (m *memoryFileStore) Keys(prefix string) ([]string, error) {
+	keys := make([]string, 0, len(m.files))
+	for key := range m.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
 func newMemoryFileStore() *memoryFileStore {
-	return &memoryFileStore{files: map[string]*memFile{}}
+	return &memoryFileStore{files: map[string][]byte{}}
 }
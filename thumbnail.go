@@ -0,0 +1,261 @@
+package uploader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// thumbKeyPrefix distinguishes thumbnails from uploads and blobs within the FileStore.
+const thumbKeyPrefix = "thumb-"
+
+// maxThumbnailPixels is the largest image a thumbnail is made from, protecting against decompression bombs.
+const maxThumbnailPixels = 64 << 20
+
+// DefaultThumbnailSizes are the thumbnail sizes generated when none are configured.
+var DefaultThumbnailSizes = []int{256}
+
+// ErrNoThumbnail is returned when a thumbnail of the requested size can not be made for an upload.
+var ErrNoThumbnail = errors.New("no thumbnail available")
+
+// thumbnailTypes are the content types thumbnails are made for, with the content type of their thumbnails.
+// Thumbnails of JPEG images are themselves JPEG, while other formats may be transparent so use PNG.
+var thumbnailTypes = map[string]string{
+	"image/png":  "image/png",
+	"image/jpeg": "image/jpeg",
+	"image/gif":  "image/png",
+}
+
+// thumbnailKey returns the FileStore key of a thumbnail. Thumbnails belong to the stored file rather than the upload,
+// so uploads sharing a blob share thumbnails.
+func thumbnailKey(storageKey string, size int) string {
+	return fmt.Sprintf("%s%s-%d", thumbKeyPrefix, storageKey, size)
+}
+
+// makeThumbnail writes a thumbnail of the image, scaled to fit within a square of the given size. Images that
+// already fit are not enlarged.
+func makeThumbnail(w io.Writer, r io.ReadSeeker, contentType string, size int) error {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return err
+	}
+	if config.Width*config.Height > maxThumbnailPixels {
+		return fmt.Errorf("image of %dx%d is too large to thumbnail", config.Width, config.Height)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	var src image.Image
+	switch contentType {
+	case "image/png":
+		src, err = png.Decode(r)
+	case "image/jpeg":
+		src, err = jpeg.Decode(r)
+	case "image/gif":
+		// Only the first frame of animations is used.
+		src, err = gif.Decode(r)
+	default:
+		return ErrNoThumbnail
+	}
+	if err != nil {
+		return err
+	}
+	width, height := fitWithin(src.Bounds().Dx(), src.Bounds().Dy(), size)
+	thumb := scale(src, width, height)
+	if thumbnailTypes[contentType] == "image/jpeg" {
+		return jpeg.Encode(w, thumb, &jpeg.Options{Quality: 85})
+	}
+	return png.Encode(w, thumb)
+}
+
+// fitWithin returns the dimensions of an image scaled to fit within a square, keeping its aspect ratio.
+func fitWithin(width, height, size int) (int, int) {
+	if width <= size && height <= size {
+		return width, height
+	}
+	if width >= height {
+		return size, maxInt(1, height*size/width)
+	}
+	return maxInt(1, width*size/height), size
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// scale resizes the image by averaging the source pixels covered by each destination pixel.
+func scale(src image.Image, width, height int) *image.RGBA {
+	bounds := src.Bounds()
+	// Averaging premultiplied colours keeps transparent pixels from darkening their neighbours.
+	rgba, ok := src.(*image.RGBA)
+	if !ok {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	} else {
+		rgba = rgba.SubImage(bounds).(*image.RGBA)
+	}
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := y*srcHeight/height, maxInt((y+1)*srcHeight/height, y*srcHeight/height+1)
+		for x := 0; x < width; x++ {
+			x0, x1 := x*srcWidth/width, maxInt((x+1)*srcWidth/width, x*srcWidth/width+1)
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				offset := rgba.PixOffset(bounds.Min.X+x0, bounds.Min.Y+sy)
+				for sx := x0; sx < x1; sx++ {
+					pixel := rgba.Pix[offset : offset+4 : offset+4]
+					r += uint64(pixel[0])
+					g += uint64(pixel[1])
+					b += uint64(pixel[2])
+					a += uint64(pixel[3])
+					n++
+					offset += 4
+				}
+			}
+			offset := dst.PixOffset(x, y)
+			dst.Pix[offset] = uint8(r / n)
+			dst.Pix[offset+1] = uint8(g / n)
+			dst.Pix[offset+2] = uint8(b / n)
+			dst.Pix[offset+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func (u *uploadService) ThumbnailSizes() []int {
+	return u.thumbnails
+}
+
+// SetThumbnailSizes replaces the sizes of the thumbnails made, and removed with the uploads they belong to.
+func (u *uploadService) SetThumbnailSizes(sizes []int) {
+	u.thumbnails = sizes
+}
+
+// validThumbnail reports whether thumbnails of the given size are made for uploads of the content type.
+func (u *uploadService) validThumbnail(contentType string, size int) bool {
+	if _, found := thumbnailTypes[contentType]; !found {
+		return false
+	}
+	for _, allowed := range u.thumbnails {
+		if size == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// putThumbnail makes a thumbnail of the file and stores it, returning its contents.
+func (u *uploadService) putThumbnail(file io.ReadSeeker, details *UploadDetails, size int) ([]byte, error) {
+	thumb := &bytes.Buffer{}
+	if err := makeThumbnail(thumb, file, details.ContentType, size); err != nil {
+		return nil, err
+	}
+	if err := u.store.Put(thumbnailKey(details.StorageKey(), size), bytes.NewReader(thumb.Bytes())); err != nil {
+		return nil, err
+	}
+	return thumb.Bytes(), nil
+}
+
+// generateThumbnails stores a thumbnail of each configured size for a new upload. Failures are logged rather than
+// failing the upload, as thumbnails are made on request if missing.
+func (u *uploadService) generateThumbnails(file io.ReadSeeker, details *UploadDetails) {
+	if _, found := thumbnailTypes[details.ContentType]; !found {
+		return
+	}
+	for _, size := range u.thumbnails {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			log.Printf("failed to rewind upload %s for thumbnails: %s", details.Key, err)
+			return
+		}
+		if _, err := u.putThumbnail(file, details, size); err != nil {
+			log.Printf("failed to make %d thumbnail of %s: %s", size, details.Key, err)
+			return
+		}
+	}
+}
+
+// removeThumbnails deletes the thumbnails of a stored file. Stores that can list their files have thumbnails of every
+// size removed, including sizes that are no longer configured, while other stores only have the configured sizes.
+func (u *uploadService) removeThumbnails(storageKey string) {
+	keys := make([]string, 0, len(u.thumbnails))
+	for _, size := range u.thumbnails {
+		keys = append(keys, thumbnailKey(storageKey, size))
+	}
+	if lister, ok := u.store.(FileLister); ok {
+		prefix := thumbKeyPrefix + storageKey + "-"
+		listed, err := lister.Keys(prefix)
+		if err == nil {
+			keys = keys[:0]
+			for _, key := range listed {
+				// The prefix also matches the thumbnails of storage keys continuing with a dash.
+				if _, err := strconv.Atoi(strings.TrimPrefix(key, prefix)); err == nil {
+					keys = append(keys, key)
+				}
+			}
+		} else if !errors.Is(err, ErrListUnsupported) {
+			log.Printf("failed to list thumbnails of %s: %s", storageKey, err)
+		}
+	}
+	for _, key := range keys {
+		if err := u.store.Delete(key); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("failed to remove thumbnail of %s: %s", storageKey, err)
+		}
+	}
+}
+
+// Thumbnail returns the thumbnail of the given size for an upload, and its content type. Thumbnails missing from
+// the store, such as those of uploads made before the size was configured, are made from the upload.
+func (u *uploadService) Thumbnail(key string, size int) (io.ReadCloser, string, error) {
+	details, err := u.Info(key)
+	if err != nil {
+		return nil, "", err
+	}
+	if !u.validThumbnail(details.ContentType, size) || details.Protected() {
+		return nil, "", ErrNoThumbnail
+	}
+	if details.Expired(time.Now()) {
+		return nil, "", ErrExpired
+	}
+	contentType := thumbnailTypes[details.ContentType]
+	thumb, err := u.store.Get(thumbnailKey(details.StorageKey(), size))
+	if err == nil {
+		return thumb, contentType, nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", err
+	}
+
+	file, err := u.store.Get(details.StorageKey())
+	if err != nil {
+		return nil, "", err
+	}
+	defer file.Close()
+	seeker, ok := file.(io.ReadSeeker)
+	if !ok {
+		contents, err := io.ReadAll(file)
+		if err != nil {
+			return nil, "", err
+		}
+		seeker = bytes.NewReader(contents)
+	}
+	contents, err := u.putThumbnail(seeker, details, size)
+	if err != nil {
+		log.Printf("failed to make %d thumbnail of %s: %s", size, details.Key, err)
+		return nil, "", ErrNoThumbnail
+	}
+	return io.NopCloser(bytes.NewReader(contents)), contentType, nil
+}
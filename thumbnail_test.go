@@ -0,0 +1,194 @@
+package uploader
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"uploader/internal/auth"
+)
+
+// testImage returns an encoded image of the given dimensions, left half red and right half blue.
+func testImage(t testing.TB, contentType string, width, height int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			if x < width/2 {
+				img.Set(x, y, color.RGBA{255, 0, 0, 255})
+			} else {
+				img.Set(x, y, color.RGBA{0, 0, 255, 255})
+			}
+		}
+	}
+	encoded := &bytes.Buffer{}
+	var err error
+	switch contentType {
+	case "image/png":
+		err = png.Encode(encoded, img)
+	case "image/jpeg":
+		err = jpeg.Encode(encoded, img, nil)
+	case "image/gif":
+		err = gif.Encode(encoded, img, nil)
+	}
+	if err != nil {
+		t.Fatalf("failed to encode test image: %s", err)
+	}
+	return encoded.Bytes()
+}
+
+func TestMakeThumbnail(t *testing.T) {
+	tests := map[string]struct {
+		contentType   string
+		width, height int
+		size          int
+		wantWidth     int
+		wantHeight    int
+	}{
+		"landscape png": {"image/png", 600, 300, 100, 100, 50},
+		"portrait jpeg": {"image/jpeg", 200, 800, 100, 25, 100},
+		"gif":           {"image/gif", 300, 300, 64, 64, 64},
+		"small image":   {"image/png", 40, 20, 100, 40, 20},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			thumb := &bytes.Buffer{}
+			source := bytes.NewReader(testImage(t, test.contentType, test.width, test.height))
+			if err := makeThumbnail(thumb, source, test.contentType, test.size); err != nil {
+				t.Fatalf("failed to make thumbnail: %s", err)
+			}
+			decoded, format, err := image.Decode(thumb)
+			if err != nil {
+				t.Fatalf("failed to decode thumbnail: %s", err)
+			}
+			if "image/"+format != thumbnailTypes[test.contentType] {
+				t.Errorf("expected thumbnail of type %s, got %s", thumbnailTypes[test.contentType], format)
+			}
+			if bounds := decoded.Bounds(); bounds.Dx() != test.wantWidth || bounds.Dy() != test.wantHeight {
+				t.Errorf("expected %dx%d thumbnail, got %dx%d", test.wantWidth, test.wantHeight, bounds.Dx(), bounds.Dy())
+			}
+			// Lossy formats shift colours slightly, so only check the halves remain distinct.
+			left, _, _, _ := decoded.At(0, 0).RGBA()
+			right, _, _, _ := decoded.At(decoded.Bounds().Dx()-1, 0).RGBA()
+			if left < 0xc000 || right > 0x4000 {
+				t.Errorf("expected red left and blue right, got red components %x and %x", left, right)
+			}
+		})
+	}
+
+	t.Run("not an image", func(t *testing.T) {
+		if err := makeThumbnail(io.Discard, bytes.NewReader([]byte("Hello, World!")), "image/png", 100); err == nil {
+			t.Error("expected invalid image to fail")
+		}
+	})
+}
+
+func TestUploadService_Thumbnail(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	us := NewUploadService(meta, store)
+	us.thumbnails = []int{32, 64}
+
+	contents := testImage(t, "image/png", 128, 128)
+	details, err := us.Upload(&seekableMemFile{bytes.NewReader(contents)}, "image.png", int64(len(contents)), "test_user", UploadOptions{})
+	if err != nil {
+		t.Fatalf("upload failed: %s", err)
+	}
+	for _, size := range us.thumbnails {
+		if _, found := store.files[thumbnailKey(details.StorageKey(), size)]; !found {
+			t.Errorf("expected %d thumbnail to be stored on upload", size)
+		}
+	}
+
+	t.Run("configured size", func(t *testing.T) {
+		thumb, contentType, err := us.Thumbnail(details.Key, 32)
+		if err != nil {
+			t.Fatalf("failed to get thumbnail: %s", err)
+		}
+		defer thumb.Close()
+		if contentType != "image/png" {
+			t.Errorf("expected png thumbnail, got %s", contentType)
+		}
+		config, _, err := image.DecodeConfig(thumb)
+		if err != nil || config.Width != 32 {
+			t.Errorf("expected 32 pixel thumbnail, got %d, %v", config.Width, err)
+		}
+	})
+	t.Run("other size", func(t *testing.T) {
+		if _, _, err := us.Thumbnail(details.Key, 48); !errors.Is(err, ErrNoThumbnail) {
+			t.Errorf("expected unconfigured size to be refused, got %v", err)
+		}
+	})
+	t.Run("missing thumbnail", func(t *testing.T) {
+		store.Delete(thumbnailKey(details.StorageKey(), 64))
+		thumb, _, err := us.Thumbnail(details.Key, 64)
+		if err != nil {
+			t.Fatalf("expected thumbnail to be made on request, got %s", err)
+		}
+		thumb.Close()
+		if _, found := store.files[thumbnailKey(details.StorageKey(), 64)]; !found {
+			t.Error("expected thumbnail made on request to be stored")
+		}
+	})
+	t.Run("not an image", func(t *testing.T) {
+		meta.addFile("text", "text/plain")
+		if _, _, err := us.Thumbnail("text", 32); !errors.Is(err, ErrNoThumbnail) {
+			t.Errorf("expected no thumbnail for text, got %v", err)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		// A size that is no longer configured, and a thumbnail of another file sharing the prefix.
+		store.files[thumbnailKey(details.StorageKey(), 128)] = []byte("thumb")
+		other := thumbnailKey(details.StorageKey()+"-other", 32)
+		store.files[other] = []byte("thumb")
+		if err := us.Delete(details.Key, &auth.User{Name: "test_user", Role: auth.RoleUser}); err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		if _, found := store.files[other]; len(store.files) != 1 || !found {
+			t.Errorf("expected upload and thumbnails to be removed, %d files remain", len(store.files))
+		}
+	})
+}
+
+func TestThumbnailHTTP(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+	meta.addFile("1", "image/jpeg")
+	store.Put("1", bytes.NewReader(testImage(t, "image/jpeg", 512, 512)))
+	meta.addFile("2", "text/plain")
+
+	tests := map[string]struct {
+		target string
+		status int
+	}{
+		"thumbnail":      {"/files/1/thumb/256", http.StatusOK},
+		"invalid size":   {"/files/1/thumb/abc", http.StatusNotFound},
+		"unknown size":   {"/files/1/thumb/1024", http.StatusNotFound},
+		"not an image":   {"/files/2/thumb/256", http.StatusNotFound},
+		"unknown upload": {"/files/3/thumb/256", http.StatusNotFound},
+	}
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			response := httptest.NewRecorder()
+			uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, test.target, nil))
+			assertStatusCode(t, response, test.status)
+			if test.status == http.StatusOK && response.Header().Get("Content-Type") != "image/jpeg" {
+				t.Errorf("expected jpeg thumbnail, got %s", response.Header().Get("Content-Type"))
+			}
+			if test.status == http.StatusOK && response.Header().Get("Content-Security-Policy") != "sandbox" {
+				t.Errorf("expected thumbnail to be sandboxed, got %q", response.Header().Get("Content-Security-Policy"))
+			}
+		})
+	}
+}
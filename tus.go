@@ -0,0 +1,366 @@
+package uploader
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"uploader/internal/responses"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Implementation of the tus 1.0 resumable upload protocol, see https://tus.io/protocols/resumable-upload
+// The creation and termination extensions are supported. Completed uploads are handed to the UploadService
+// so that they are indistinguishable from uploads made through the multipart endpoint.
+
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,termination"
+	tusOffsetType = "application/offset+octet-stream"
+)
+
+type tusHandler struct {
+	meta  PartialMeta
+	store FileStore
+	us    UploadService
+	base  *url.URL
+	// uploaded records the completion of an upload.
+	uploaded func(r *http.Request, details *UploadDetails)
+	// ttl is how long a partial upload may go without receiving data before it is removed by reapStale.
+	ttl time.Duration
+
+	// active holds the IDs of uploads currently receiving data, concurrent PATCH requests are rejected.
+	mu     sync.Mutex
+	active map[string]bool
+}
+
+func newTusHandler(meta PartialMeta, store FileStore, us UploadService, base *url.URL) *tusHandler {
+	return &tusHandler{meta: meta, store: store, us: us, base: base, ttl: defaultPartialTTL, active: map[string]bool{}}
+}
+
+func (t *tusHandler) lock(id string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.active[id] {
+		return false
+	}
+	t.active[id] = true
+	return true
+}
+
+func (t *tusHandler) unlock(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.active, id)
+}
+
+// requireResumable rejects requests that don't declare a supported protocol version.
+func (t *tusHandler) requireResumable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Tus-Resumable", tusVersion)
+		if r.Method != http.MethodOptions && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			tusError(w, r, http.StatusPreconditionFailed, -1101, "unsupported tus version")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tusError(w http.ResponseWriter, r *http.Request, status, code int, message string) {
+	if r.Method == http.MethodHead {
+		w.WriteHeader(status)
+		return
+	}
+	responses.Error(w, &responses.BaseResponse{}, status, code, message)
+}
+
+func (t *tusHandler) options(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", tusExtensions)
+	if max := t.us.MaxUploadSize(); max > 0 {
+		w.Header().Set("Tus-Max-Size", strconv.FormatInt(max, 10))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (t *tusHandler) create(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		tusError(w, r, http.StatusBadRequest, -1102, "invalid or missing Upload-Length")
+		return
+	}
+	switch err := t.us.CheckQuota(pathUser(r), length); {
+	case errors.Is(err, ErrTooLarge):
+		tusError(w, r, http.StatusRequestEntityTooLarge, -1007, err.Error())
+		return
+	case errors.Is(err, ErrQuotaExceeded):
+		tusError(w, r, http.StatusForbidden, -1008, err.Error())
+		return
+	case err != nil:
+		tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+		return
+	}
+	metadata, err := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		tusError(w, r, http.StatusBadRequest, -1103, "invalid Upload-Metadata")
+		return
+	}
+	id, err := randSecKey()
+	if err != nil {
+		tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+		return
+	}
+	filename := metadata["filename"]
+	if filename == "" {
+		filename = metadata["name"]
+	}
+	expiresIn, err := ParseExpiresIn(metadata[expiresInField])
+	if err != nil {
+		tusError(w, r, http.StatusBadRequest, -1005, "invalid "+expiresInField)
+		return
+	}
+	upload := PartialUpload{ID: id, User: pathUser(r), Filename: filename, Length: length, ExpiresIn: expiresIn,
+		Updated: time.Now()}
+	if length == 0 {
+		// No data will be sent for an empty upload, so it is finished without being staged. There is no partial upload
+		// to locate, so the stored upload is given by Upload-URL alone.
+		if t.complete(w, r, &upload) {
+			w.WriteHeader(http.StatusCreated)
+		}
+		return
+	}
+	if err := t.meta.PartialPut(upload); err != nil {
+		tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+id)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// partial loads the upload referenced in the URL, writing an error response if it does not belong to the path user.
+func (t *tusHandler) partial(w http.ResponseWriter, r *http.Request) (*PartialUpload, bool) {
+	upload, err := t.meta.PartialGet(chi.URLParam(r, "id"))
+	if errors.Is(err, ErrNotFound) || (err == nil && upload.User != pathUser(r)) {
+		tusError(w, r, http.StatusNotFound, -1004, "upload not found")
+		return nil, false
+	} else if err != nil {
+		tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+		return nil, false
+	}
+	return upload, true
+}
+
+func (t *tusHandler) head(w http.ResponseWriter, r *http.Request) {
+	upload, ok := t.partial(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.WriteHeader(http.StatusOK)
+}
+
+// patch appends the request body to the upload as a new chunk. If the body is interrupted the chunk is discarded
+// and the offset remains unchanged, so clients resume from the start of the failed chunk.
+func (t *tusHandler) patch(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != tusOffsetType {
+		tusError(w, r, http.StatusUnsupportedMediaType, -1104, "content type must be "+tusOffsetType)
+		return
+	}
+	// The lock is taken before loading the upload so that the offset read below is not stale.
+	id := chi.URLParam(r, "id")
+	if !t.lock(id) {
+		tusError(w, r, http.StatusLocked, -1105, "upload is already receiving data")
+		return
+	}
+	defer t.unlock(id)
+	upload, ok := t.partial(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		tusError(w, r, http.StatusConflict, -1106, "Upload-Offset does not match current offset")
+		return
+	}
+
+	if upload.Offset < upload.Length {
+		index := len(upload.Chunks)
+		counter := &countingReader{r: io.LimitReader(r.Body, upload.Length-upload.Offset)}
+		if err := t.store.Put(upload.ChunkKey(index), counter); err != nil {
+			t.store.Delete(upload.ChunkKey(index))
+			tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+			return
+		}
+		if counter.n > 0 {
+			upload.Chunks = append(upload.Chunks, counter.n)
+			upload.Offset += counter.n
+			upload.Updated = time.Now()
+			if err := t.meta.PartialPut(*upload); err != nil {
+				tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+				return
+			}
+		} else {
+			t.store.Delete(upload.ChunkKey(index))
+		}
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+
+	if upload.Offset == upload.Length && !t.complete(w, r, upload) {
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// complete finishes an upload that has received all of its data and sets the headers locating the stored upload,
+// returning false if an error response has been written instead.
+func (t *tusHandler) complete(w http.ResponseWriter, r *http.Request, upload *PartialUpload) bool {
+	details, err := t.finish(upload)
+	if errors.Is(err, ErrExpiryTooLong) || errors.Is(err, ErrInvalidExpiry) {
+		tusError(w, r, http.StatusBadRequest, -1005, err.Error())
+		return false
+	} else if errors.Is(err, ErrQuotaExceeded) {
+		tusError(w, r, http.StatusForbidden, -1008, err.Error())
+		return false
+	} else if err != nil {
+		tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+		return false
+	}
+	t.uploaded(r, details)
+	details.BuildUrl(t.base)
+	w.Header().Set("Upload-URL", details.url)
+	w.Header().Set("Upload-Delete-URL", details.deleteUrl)
+	return true
+}
+
+// finish hands the staged chunks to the upload service and removes the partial upload. Once the upload is stored a
+// failure to remove the partial upload is only logged, as failing the request would have the client upload it again.
+func (t *tusHandler) finish(upload *PartialUpload) (*UploadDetails, error) {
+	file := newChunkedFile(t.store, upload)
+	defer file.Close()
+	details, err := t.us.Upload(file, upload.Filename, upload.Length, upload.User, UploadOptions{ExpiresIn: upload.ExpiresIn})
+	if err != nil {
+		return nil, err
+	}
+	if err := t.discard(upload); err != nil {
+		log.Printf("failed to discard completed upload %s: %s", upload.ID, err)
+	}
+	return details, nil
+}
+
+// discard removes the partial upload before its chunks, so that it can not be finished again if removing them fails.
+// Chunks that could not be removed are left for fsck.
+func (t *tusHandler) discard(upload *PartialUpload) error {
+	if err := t.meta.PartialDelete(upload.ID); err != nil {
+		return err
+	}
+	var firstErr error
+	for i := range upload.Chunks {
+		if err := t.store.Delete(upload.ChunkKey(i)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// reapStale removes partial uploads that have not received data within the TTL, returning the number removed.
+// Uploads that fail to be removed are logged and skipped.
+func (t *tusHandler) reapStale(now time.Time) (int, error) {
+	before := now.Add(-t.ttl)
+	ids, err := t.meta.StalePartials(before)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, id := range ids {
+		if !t.lock(id) {
+			// The upload is receiving data.
+			continue
+		}
+		err := t.reap(id, before)
+		t.unlock(id)
+		if err != nil {
+			log.Printf("failed to remove abandoned upload %s: %s", id, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// reap removes the partial upload if it is still stale, which is checked again once it is locked.
+func (t *tusHandler) reap(id string, before time.Time) error {
+	upload, err := t.meta.PartialGet(id)
+	if err != nil {
+		return err
+	}
+	if !upload.Updated.Before(before) {
+		return nil
+	}
+	return t.discard(upload)
+}
+
+func (t *tusHandler) terminate(w http.ResponseWriter, r *http.Request) {
+	// The lock is taken before loading the upload so that the offset read below is not stale.
+	id := chi.URLParam(r, "id")
+	if !t.lock(id) {
+		tusError(w, r, http.StatusLocked, -1105, "upload is already receiving data")
+		return
+	}
+	defer t.unlock(id)
+	upload, ok := t.partial(w, r)
+	if !ok {
+		return
+	}
+	if err := t.discard(upload); err != nil {
+		tusError(w, r, http.StatusInternalServerError, -5000, "unknown error")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseTusMetadata decodes an Upload-Metadata header of comma separated keys and optional base64 values.
+func parseTusMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	if strings.TrimSpace(header) == "" {
+		return metadata, nil
+	}
+	for _, pair := range strings.Split(header, ",") {
+		parts := strings.SplitN(strings.TrimSpace(pair), " ", 2)
+		if parts[0] == "" {
+			return nil, errors.New("empty metadata key")
+		}
+		if len(parts) == 1 {
+			metadata[parts[0]] = ""
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		metadata[parts[0]] = string(value)
+	}
+	return metadata, nil
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,229 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"uploader/internal/auth"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func tusRequest(t testing.TB, method, target, token string, body io.Reader) *http.Request {
+	t.Helper()
+	request := httptest.NewRequest(method, target, body)
+	request.Header.Set("Tus-Resumable", tusVersion)
+	request.Header.Set(auth.HTTPHeaderName, fmt.Sprintf("Bearer %s", token))
+	return request
+}
+
+func TestTusUpload(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	// Create the upload, "dGVzdC50eHQ=" is "test.txt".
+	request := tusRequest(t, http.MethodPost, "/uploads/test_user/tus", user.AuthToken, nil)
+	request.Header.Set("Upload-Length", "13")
+	request.Header.Set("Upload-Metadata", "filename dGVzdC50eHQ=,is_confidential")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusCreated)
+	location := response.Header().Get("Location")
+	if !strings.HasPrefix(location, "/uploads/test_user/tus/") {
+		t.Fatalf("unexpected upload location %s", location)
+	}
+
+	patch := func(offset int, body string) *httptest.ResponseRecorder {
+		request := tusRequest(t, http.MethodPatch, location, user.AuthToken, strings.NewReader(body))
+		request.Header.Set("Content-Type", tusOffsetType)
+		request.Header.Set("Upload-Offset", fmt.Sprintf("%d", offset))
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		return response
+	}
+
+	response = patch(0, "Hello, ")
+	assertStatusCode(t, response, http.StatusNoContent)
+	if offset := response.Header().Get("Upload-Offset"); offset != "7" {
+		t.Errorf("wrong offset after first chunk, got %s", offset)
+	}
+
+	t.Run("head reports offset", func(t *testing.T) {
+		request := tusRequest(t, http.MethodHead, location, user.AuthToken, nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusOK)
+		if offset := response.Header().Get("Upload-Offset"); offset != "7" {
+			t.Errorf("wrong offset, got %s", offset)
+		}
+		if length := response.Header().Get("Upload-Length"); length != "13" {
+			t.Errorf("wrong length, got %s", length)
+		}
+	})
+	t.Run("offset mismatch", func(t *testing.T) {
+		assertStatusCode(t, patch(3, "lo, World!"), http.StatusConflict)
+	})
+
+	response = patch(7, "World!")
+	assertStatusCode(t, response, http.StatusNoContent)
+	if url := response.Header().Get("Upload-URL"); url != "http://localhost/files/1" {
+		t.Errorf("wrong upload url, got %s", url)
+	}
+
+	details := meta.files["1"]
+	if details == nil || details.Filename != "test.txt" || details.Size != 13 {
+		t.Fatalf("unexpected upload details %+v", details)
+	}
+	if diff := cmp.Diff(string(store.files[details.StorageKey()]), "Hello, World!"); diff != "" {
+		t.Errorf("stored contents mismatch %s", diff)
+	}
+	if len(meta.partials) != 0 {
+		t.Error("expected partial upload to be removed")
+	}
+	if len(store.files) != 1 {
+		t.Errorf("expected staged chunks to be removed, %d files in store", len(store.files))
+	}
+}
+
+func TestTusEmptyUpload(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+
+	request := tusRequest(t, http.MethodPost, "/uploads/test_user/tus", user.AuthToken, nil)
+	request.Header.Set("Upload-Length", "0")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusCreated)
+	if url := response.Header().Get("Upload-URL"); url != "http://localhost/files/1" {
+		t.Errorf("expected empty upload to be finished on creation, got url %q", url)
+	}
+	if location := response.Header().Get("Location"); location != "" {
+		t.Errorf("expected no partial upload to be located, got %s", location)
+	}
+	if details := meta.files["1"]; details == nil || details.Size != 0 {
+		t.Errorf("unexpected upload details %+v", details)
+	}
+	if len(meta.partials) != 0 {
+		t.Error("expected empty upload to not be staged")
+	}
+}
+
+// undeletableFileStore fails to delete any file.
+type undeletableFileStore struct {
+	*memoryFileStore
+}
+
+func (u undeletableFileStore) Delete(key string) error {
+	return errors.New("permission denied")
+}
+
+func TestTusDiscardFailure(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	store := undeletableFileStore{newMemoryFileStore()}
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+	meta.PartialPut(PartialUpload{ID: "abc", User: "test_user", Length: 13, Offset: 7, Chunks: []int64{7}})
+	store.Put(".partial-abc-0", strings.NewReader("Hello, "))
+
+	request := tusRequest(t, http.MethodPatch, "/uploads/test_user/tus/abc", user.AuthToken, strings.NewReader("World!"))
+	request.Header.Set("Content-Type", tusOffsetType)
+	request.Header.Set("Upload-Offset", "7")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+
+	// The upload is stored, so the client must not be asked to send it again.
+	assertStatusCode(t, response, http.StatusNoContent)
+	if url := response.Header().Get("Upload-URL"); url != "http://localhost/files/1" {
+		t.Errorf("wrong upload url, got %s", url)
+	}
+	if len(meta.partials) != 0 {
+		t.Error("expected partial upload to be removed so that it can not be finished again")
+	}
+}
+
+func TestTusReapStale(t *testing.T) {
+	meta := newTestMeta()
+	store := newMemoryFileStore()
+	now := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	tus := newTusHandler(meta, store, NewUploadService(meta, store), baseURL)
+	tus.ttl = time.Hour
+	meta.PartialPut(PartialUpload{ID: "stale", Length: 10, Offset: 4, Chunks: []int64{4}, Updated: now.Add(-2 * time.Hour)})
+	store.Put(".partial-stale-0", strings.NewReader("1234"))
+	meta.PartialPut(PartialUpload{ID: "active", Length: 10, Offset: 4, Chunks: []int64{4}, Updated: now.Add(-time.Minute)})
+	store.Put(".partial-active-0", strings.NewReader("1234"))
+	meta.PartialPut(PartialUpload{ID: "locked", Length: 10, Updated: now.Add(-2 * time.Hour)})
+	tus.lock("locked")
+
+	removed, err := tus.reapStale(now)
+	if err != nil {
+		t.Fatalf("unexpected error reaping partial uploads: %s", err)
+	}
+	if removed != 1 {
+		t.Errorf("expected 1 partial upload to be removed, got %d", removed)
+	}
+	if _, found := meta.partials["stale"]; found {
+		t.Error("expected stale partial upload to be removed")
+	}
+	if _, found := store.files[".partial-stale-0"]; found {
+		t.Error("expected chunks of stale partial upload to be removed")
+	}
+	if len(meta.partials) != 2 || len(store.files) != 1 {
+		t.Errorf("expected active and locked partial uploads to remain, got %d", len(meta.partials))
+	}
+}
+
+func TestTusTerminate(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	other, _ := meta.UserRegister("other_user")
+	store := newMemoryFileStore()
+	uploader := NewUploaderHTTP(baseURL, meta, store)
+	meta.PartialPut(PartialUpload{ID: "abc", User: "test_user", Length: 10, Offset: 4, Chunks: []int64{4}})
+	store.Put(".partial-abc-0", strings.NewReader("1234"))
+
+	t.Run("other user", func(t *testing.T) {
+		request := tusRequest(t, http.MethodDelete, "/uploads/other_user/tus/abc", other.AuthToken, nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusNotFound)
+	})
+	t.Run("owner", func(t *testing.T) {
+		request := tusRequest(t, http.MethodDelete, "/uploads/test_user/tus/abc", user.AuthToken, nil)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusNoContent)
+		if len(meta.partials) != 0 || len(store.files) != 0 {
+			t.Error("expected partial upload and chunks to be removed")
+		}
+	})
+}
+
+func TestTusVersion(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	request := tusRequest(t, http.MethodPost, "/uploads/test_user/tus", user.AuthToken, nil)
+	request.Header.Set("Tus-Resumable", "0.2.2")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusPreconditionFailed)
+
+	request = httptest.NewRequest(http.MethodOptions, "/uploads/test_user/tus", nil)
+	response = httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	assertStatusCode(t, response, http.StatusNoContent)
+	if ext := response.Header().Get("Tus-Extension"); ext != tusExtensions {
+		t.Errorf("wrong extensions, got %s", ext)
+	}
+}
@@ -0,0 +1,189 @@
+package uploader
+
+import (
+	"embed"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"uploader/internal/auth"
+	"uploader/internal/responses"
+)
+
+// The web interface is rendered on the server from the embedded templates, with a small script for uploading,
+// copying links and deleting. It authenticates with the session cookie set by login.
+
+//go:embed web
+var webFiles embed.FS
+
+// galleryPageSize is the number of uploads shown on each page of the gallery.
+const galleryPageSize = 48
+
+// shareXFilenames are the names of the ShareX custom uploader files offered for download, by script format.
+var shareXFilenames = map[string]string{
+	ScriptShareX:          "uploader.sxcu",
+	ScriptShareXShortener: "uploader-shortener.sxcu",
+}
+
+// webFuncs are the functions available to the web templates, including configured preview templates.
+var webFuncs = template.FuncMap{
+	"formatSize": formatSize,
+	"isImage":    func(contentType string) bool { return strings.HasPrefix(contentType, "image/") },
+}
+
+var webTemplates = template.Must(template.New("").Funcs(webFuncs).ParseFS(webFiles, "web/templates/*.html"))
+
+func webStatic() http.Handler {
+	static, err := fs.Sub(webFiles, "web/static")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/static/", http.FileServer(http.FS(static)))
+}
+
+// formatSize returns a size in bytes in the largest whole binary unit.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func renderPage(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := webTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("failed to render %s: %s", name, err)
+	}
+}
+
+type loginPage struct {
+	// OIDC is set when login through an OpenID Connect provider is available.
+	OIDC  bool
+	Error string
+}
+
+type galleryPage struct {
+	User       *auth.User
+	Uploads    []UploadInfo
+	NextCursor string
+	Filename   string
+	Quota      *QuotaStatus
+	// UploadURL is the endpoint files are uploaded to, used for uploads and deletes by the page script.
+	UploadURL string
+	// ThumbnailSize is the size of the thumbnails shown, or zero if thumbnails are disabled.
+	ThumbnailSize int
+	// previewBase is set when copied links should be to preview pages.
+	previewBase *url.URL
+}
+
+// Link returns the link copied for the upload.
+func (g galleryPage) Link(upload UploadInfo) string {
+	if g.previewBase != nil {
+		return previewURL(g.previewBase, upload.Key)
+	}
+	return upload.URL
+}
+
+// Thumbnail returns the URL of the thumbnail shown for the upload, or an empty string if it has none.
+func (g galleryPage) Thumbnail(upload UploadInfo) string {
+	if _, found := thumbnailTypes[upload.ContentType]; !found || g.ThumbnailSize == 0 || upload.Protected() {
+		return ""
+	}
+	return fmt.Sprintf("%s/thumb/%d", upload.URL, g.ThumbnailSize)
+}
+
+// sessionUser returns the user logged in to the request, or nil if there is no valid session.
+func (u *Uploader) sessionUser(r *http.Request) *auth.User {
+	name, found := u.login.sessions.User(r)
+	if !found {
+		return nil
+	}
+	user, err := u.Auth.UserByName(name)
+	if err != nil || user.Disabled {
+		return nil
+	}
+	return user
+}
+
+// webIndex shows the gallery of the logged in user, or the login page.
+func (u *Uploader) webIndex(w http.ResponseWriter, r *http.Request) {
+	user := u.sessionUser(r)
+	if user == nil {
+		renderPage(w, http.StatusOK, "login.html", loginPage{OIDC: u.login.provider != nil})
+		return
+	}
+	query := r.URL.Query()
+	list, err := u.us.List(user.Name, ListOptions{
+		Limit:    galleryPageSize,
+		Cursor:   query.Get("cursor"),
+		Filename: query.Get("name"),
+	})
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	quota, err := u.us.Quota(user.Name)
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	page := galleryPage{
+		User:       user,
+		Uploads:    make([]UploadInfo, 0, len(list.Uploads)),
+		NextCursor: list.NextCursor,
+		Filename:   query.Get("name"),
+		Quota:      quota,
+		UploadURL:  u.baseURL.JoinPath("/uploads/", user.Name).String(),
+	}
+	if sizes := u.us.ThumbnailSizes(); len(sizes) > 0 {
+		page.ThumbnailSize = sizes[0]
+	}
+	if u.previews != nil && u.previews.link {
+		page.previewBase = u.baseURL
+	}
+	for _, details := range list.Uploads {
+		page.Uploads = append(page.Uploads, NewUploadInfo(details, u.baseURL))
+	}
+	renderPage(w, http.StatusOK, "gallery.html", page)
+}
+
+// webShareX downloads a ShareX configuration for the authenticated user, either the uploader or the URL shortener
+// chosen by the format field. Each download issues a new upload token, replacing the token of the previous download
+// of that format.
+func (u *Uploader) webShareX(w http.ResponseWriter, r *http.Request) {
+	format := r.FormValue("format")
+	if format == "" {
+		format = ScriptShareX
+	}
+	filename, found := shareXFilenames[format]
+	if !found {
+		responses.Error(w, &responses.BaseResponse{}, http.StatusBadRequest, -1001, fmt.Sprintf("unknown format %q", format))
+		return
+	}
+	user := auth.AuthUser(r.Context())
+	token, err := ReplaceToken(u.Auth, user.Name, format, auth.TokenOptions{Scopes: []auth.Scope{auth.ScopeUpload}})
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	script, err := u.UploadScript(&auth.User{Name: user.Name, AuthToken: token.Secret, Scopes: token.Scopes}, format)
+	if err != nil {
+		responses.ErrorFromError(w, &responses.BaseResponse{}, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	w.Header().Set("Cache-Control", "no-store")
+	fmt.Fprint(w, script)
+}
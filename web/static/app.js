@@ -0,0 +1,90 @@
+"use strict";
+
+const main = document.querySelector("main[data-upload-url]");
+const uploadURL = main.dataset.uploadUrl;
+const drop = document.getElementById("drop");
+const status = document.getElementById("status");
+const encrypt = document.getElementById("encrypt");
+const links = document.getElementById("links");
+const password = document.getElementById("password");
+const maxDownloads = document.getElementById("max-downloads");
+
+async function upload(files) {
+	// The keys of encrypted uploads are never sent to the server, so their links can only be shown now.
+	const encrypted = [];
+	for (const [i, file] of Array.from(files).entries()) {
+		status.textContent = `Uploading ${file.name} (${i + 1} of ${files.length})`;
+		const form = new FormData();
+		let key;
+		if (encrypt.checked) {
+			const sealed = await encryptFile(file);
+			key = sealed.key;
+			form.append("encrypted", "true");
+			form.append("file", sealed.blob, e2eFilename);
+		} else {
+			form.append("file", file);
+		}
+		if (password.value) {
+			form.append("password", password.value);
+		}
+		if (maxDownloads.value) {
+			form.append("max_downloads", maxDownloads.value);
+		}
+		const response = await fetch(uploadURL, { method: "POST", body: form, credentials: "same-origin" });
+		const body = await response.json().catch(() => ({}));
+		if (!response.ok) {
+			status.textContent = `Failed to upload ${file.name}: ${body.message || response.statusText}`;
+			return;
+		}
+		if (key) {
+			encrypted.push(`${body.results.url}#${key}`);
+		}
+	}
+	if (encrypted.length === 0) {
+		location.reload();
+		return;
+	}
+	status.textContent = "Uploaded, copy the links below as they will not be shown again";
+	links.value = encrypted.join("\n");
+	links.hidden = false;
+	links.select();
+}
+
+document.getElementById("file").addEventListener("change", (event) => upload(event.target.files));
+drop.addEventListener("dragover", (event) => {
+	event.preventDefault();
+	drop.classList.add("over");
+});
+drop.addEventListener("dragleave", () => drop.classList.remove("over"));
+drop.addEventListener("drop", (event) => {
+	event.preventDefault();
+	drop.classList.remove("over");
+	upload(event.dataTransfer.files);
+});
+
+document.addEventListener("click", async (event) => {
+	const button = event.target.closest("button[data-copy], button[data-delete]");
+	if (!button) {
+		return;
+	}
+	if (button.dataset.copy) {
+		await navigator.clipboard.writeText(button.dataset.copy);
+		button.textContent = "Copied";
+		setTimeout(() => (button.textContent = "Copy link"), 1500);
+		return;
+	}
+	const item = button.closest("li");
+	const name = item.querySelector(".name").textContent;
+	if (!confirm(`Delete ${name}?`)) {
+		return;
+	}
+	const response = await fetch(`${uploadURL}/${encodeURIComponent(button.dataset.delete)}`, {
+		method: "DELETE",
+		credentials: "same-origin",
+	});
+	if (response.ok) {
+		item.remove();
+	} else {
+		alert(`Failed to delete ${name}`);
+	}
+});
@@ -0,0 +1,45 @@
+"use strict";
+
+// Encrypted uploads are a version byte, a 12 byte nonce and the AES-256-GCM encryption of a 4 byte big endian header
+// length, a JSON header holding the name and type of the file, and the file contents. The key is carried in the URL
+// fragment as unpadded base64url. This matches the format of the Go client package.
+
+const e2eVersion = 1;
+const e2eFilename = "encrypted";
+
+function encodeKey(bytes) {
+	return btoa(String.fromCharCode(...bytes)).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function decodeKey(text) {
+	const binary = atob(text.replace(/-/g, "+").replace(/_/g, "/"));
+	return Uint8Array.from(binary, (c) => c.charCodeAt(0));
+}
+
+// encryptFile encrypts the file with a new key, returning the encrypted blob and the encoded key.
+async function encryptFile(file) {
+	const raw = crypto.getRandomValues(new Uint8Array(32));
+	const key = await crypto.subtle.importKey("raw", raw, "AES-GCM", false, ["encrypt"]);
+	const header = new TextEncoder().encode(JSON.stringify({ name: file.name, type: file.type }));
+	const contents = new Uint8Array(await file.arrayBuffer());
+	const plain = new Uint8Array(4 + header.length + contents.length);
+	new DataView(plain.buffer).setUint32(0, header.length);
+	plain.set(header, 4);
+	plain.set(contents, 4 + header.length);
+	const nonce = crypto.getRandomValues(new Uint8Array(12));
+	const sealed = await crypto.subtle.encrypt({ name: "AES-GCM", iv: nonce }, key, plain);
+	return { blob: new Blob([new Uint8Array([e2eVersion]), nonce, sealed]), key: encodeKey(raw) };
+}
+
+// decryptFile decrypts an encrypted upload, returning its name, type and contents as a blob.
+async function decryptFile(data, encodedKey) {
+	const bytes = new Uint8Array(data);
+	if (bytes[0] !== e2eVersion) {
+		throw new Error("unsupported encrypted file");
+	}
+	const key = await crypto.subtle.importKey("raw", decodeKey(encodedKey), "AES-GCM", false, ["decrypt"]);
+	const plain = new Uint8Array(await crypto.subtle.decrypt({ name: "AES-GCM", iv: bytes.slice(1, 13) }, key, bytes.slice(13)));
+	const length = new DataView(plain.buffer).getUint32(0);
+	const header = JSON.parse(new TextDecoder().decode(plain.slice(4, 4 + length)));
+	return { name: header.name, type: header.type, blob: new Blob([plain.slice(4 + length)], { type: header.type }) };
+}
@@ -0,0 +1,57 @@
+"use strict";
+
+const viewer = document.getElementById("viewer");
+const message = document.getElementById("message");
+
+async function view() {
+	const key = location.hash.slice(1);
+	if (!key) {
+		message.textContent = "This link is missing the key needed to decrypt the file.";
+		return;
+	}
+	try {
+		let response = await fetch(viewer.dataset.url);
+		// Password protected uploads are fetched again with the password.
+		while (response.status === 401) {
+			const password = prompt("This file is protected by a password");
+			if (password === null) {
+				throw new Error("password required");
+			}
+			response = await fetch(viewer.dataset.url, { headers: { "X-Password": password } });
+		}
+		if (!response.ok) {
+			throw new Error(response.statusText);
+		}
+		const file = await decryptFile(await response.arrayBuffer(), key);
+		const url = URL.createObjectURL(file.blob);
+		document.title = file.name;
+		document.getElementById("name").textContent = file.name;
+		const download = document.getElementById("download");
+		download.href = url;
+		download.download = file.name;
+		download.hidden = false;
+
+		const kind = file.type.split("/")[0];
+		if (kind === "image") {
+			const image = document.createElement("img");
+			image.src = url;
+			image.alt = file.name;
+			viewer.append(image);
+		} else if (kind === "video" || kind === "audio") {
+			const media = document.createElement(kind);
+			media.src = url;
+			media.controls = true;
+			viewer.append(media);
+		} else if (kind === "text") {
+			const text = document.createElement("pre");
+			text.textContent = await file.blob.text();
+			viewer.append(text);
+		}
+		message.remove();
+	} catch (error) {
+		message.textContent = `Failed to decrypt the file: ${error.message}`;
+		message.classList.add("error");
+	}
+}
+
+view();
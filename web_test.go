@@ -0,0 +1,132 @@
+package uploader
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"uploader/internal/auth"
+)
+
+// tokenLogin logs in to the web interface with the token, returning the response.
+func tokenLogin(uploader http.Handler, token string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/auth/token", strings.NewReader(url.Values{tokenField: {token}}.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, request)
+	return response
+}
+
+func TestWebHTTP(t *testing.T) {
+	meta := newTestMeta()
+	user, _ := meta.UserRegister("test_user")
+	scoped, _ := meta.TokenCreate("test_user", "read", auth.TokenOptions{Scopes: []auth.Scope{auth.ScopeRead}})
+	meta.addFile("1", "image/png")
+	uploader := NewUploaderHTTP(baseURL, meta, newMemoryFileStore())
+
+	response := httptest.NewRecorder()
+	uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/", nil))
+	assertStatusCode(t, response, http.StatusOK)
+	if !strings.Contains(response.Body.String(), `action="auth/token"`) {
+		t.Error("expected login page without a session")
+	}
+	if strings.Contains(response.Body.String(), `href="auth/login"`) {
+		t.Error("expected no provider login when it is not configured")
+	}
+
+	t.Run("invalid token", func(t *testing.T) {
+		response := tokenLogin(uploader, "abc")
+		assertStatusCode(t, response, http.StatusUnauthorized)
+		if sessionCookie(response) != nil {
+			t.Error("expected no session for an invalid token")
+		}
+	})
+	t.Run("scoped token", func(t *testing.T) {
+		response := tokenLogin(uploader, scoped.Secret)
+		assertStatusCode(t, response, http.StatusForbidden)
+		if sessionCookie(response) != nil {
+			t.Error("expected no session for a scoped token")
+		}
+	})
+
+	response = tokenLogin(uploader, user.AuthToken)
+	assertStatusCode(t, response, http.StatusSeeOther)
+	cookie := sessionCookie(response)
+	if cookie == nil {
+		t.Fatal("expected token login to set a session cookie")
+	}
+
+	t.Run("gallery", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusOK)
+		body := response.Body.String()
+		for _, want := range []string{`data-upload-url="http://localhost/uploads/test_user"`, `<img src="http://localhost/files/1/thumb/256"`, `data-delete="1"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("expected gallery to contain %s", want)
+			}
+		}
+	})
+	t.Run("sharex", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/sharex", nil)
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusOK)
+		if disposition := response.Header().Get("Content-Disposition"); !strings.Contains(disposition, shareXFilenames[ScriptShareX]) {
+			t.Errorf("expected config to be downloaded, got disposition %q", disposition)
+		}
+		script := UploadScript{}
+		if err := json.NewDecoder(response.Body).Decode(&script); err != nil {
+			t.Fatalf("failed to decode config: %s", err)
+		}
+		token := strings.TrimPrefix(script.Headers.Authorization, "Bearer ")
+		response = httptest.NewRecorder()
+		uploader.ServeHTTP(response, uploadRequest(t, token))
+		assertStatusCode(t, response, http.StatusAccepted)
+	})
+	t.Run("sharex shortener", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/sharex", strings.NewReader("format=sharex-shortener"))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		request.AddCookie(cookie)
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, request)
+		assertStatusCode(t, response, http.StatusOK)
+		if disposition := response.Header().Get("Content-Disposition"); !strings.Contains(disposition, shareXFilenames[ScriptShareXShortener]) {
+			t.Errorf("expected shortener config to be downloaded, got disposition %q", disposition)
+		}
+	})
+	t.Run("sharex without session", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodPost, "/sharex", nil))
+		assertStatusCode(t, response, http.StatusUnauthorized)
+	})
+	t.Run("static", func(t *testing.T) {
+		response := httptest.NewRecorder()
+		uploader.ServeHTTP(response, httptest.NewRequest(http.MethodGet, "/static/app.js", nil))
+		assertStatusCode(t, response, http.StatusOK)
+	})
+}
+
+func TestFormatSize(t *testing.T) {
+	tests := map[int64]string{
+		0:           "0 B",
+		1023:        "1023 B",
+		1024:        "1.0 KiB",
+		1536:        "1.5 KiB",
+		5 << 20:     "5.0 MiB",
+		3 << 30:     "3.0 GiB",
+		1<<40 + 1:   "1.0 TiB",
+		1<<20 - 100: "1023.9 KiB",
+	}
+	for size, want := range tests {
+		if got := formatSize(size); got != want {
+			t.Errorf("formatSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}